@@ -0,0 +1,133 @@
+package turl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/app/turl/model"
+	"github.com/beihai0xff/turl/pkg/mapping"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+var (
+	// ErrInvalidShortCode is returned when the short code is neither a generated short code nor a well-formed alias
+	ErrInvalidShortCode = fmt.Errorf("%w: invalid short code", mapping.ErrInvalidInput)
+	// ErrInvalidAlias is returned when the custom alias is malformed or can not be used
+	ErrInvalidAlias = fmt.Errorf("%w: invalid alias", mapping.ErrInvalidInput)
+	// ErrAliasConflict is returned when the custom alias is already taken
+	ErrAliasConflict = errors.New("alias is already taken")
+
+	// codePattern is the pattern of short codes, includes generated short codes and custom aliases
+	codePattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+	// defaultReservedAliases are the words used by server routes, which can not be used as custom alias
+	defaultReservedAliases = []string{"admin", "api", "debug", "healthcheck", "management", "shorten", "swagger", "v1"}
+)
+
+// newReservedAliases returns the set of reserved aliases, contains the default reserved words and the extra ones
+func newReservedAliases(extra []string) map[string]struct{} {
+	reserved := make(map[string]struct{}, len(defaultReservedAliases)+len(extra))
+	for _, words := range [][]string{defaultReservedAliases, extra} {
+		for _, v := range words {
+			reserved[strings.ToLower(v)] = struct{}{}
+		}
+	}
+
+	return reserved
+}
+
+// validateCode checks whether the short code is well-formed
+func validateCode(code []byte) error {
+	if len(code) == 0 || len(code) > model.MaxAliasLength || !codePattern.Match(code) {
+		return ErrInvalidShortCode
+	}
+
+	return nil
+}
+
+// isSequenceCode reports whether the short code is a canonical Base58 code, which may be generated.
+// The codes with leading '1's decode to the same short ID as the ones without, but they are never generated,
+// so they are not canonical, and resolved as aliases only.
+func isSequenceCode(code []byte) (uint64, bool) {
+	seq, err := mapping.Base58Decode(code)
+	return seq, err == nil && seq > 0 && code[0] != '1'
+}
+
+// codeRange is the range [lo, hi) of the short IDs generated in a short domain
+type codeRange struct {
+	lo, hi uint64
+}
+
+// fullCodeRange covers all the short IDs, it is used if the range of the short domain is unknown
+var fullCodeRange = codeRange{lo: 0, hi: math.MaxUint64}
+
+// newCodeRange returns the range of the short IDs generated from the sequence numbers starting from seqMin
+func newCodeRange(gen mapping.Generator, seqMin uint64) codeRange {
+	lo, hi := gen.Range(seqMin, math.MaxUint64)
+	return codeRange{lo: lo, hi: hi}
+}
+
+// contains reports whether the short code may be generated in the range
+func (r codeRange) contains(code []byte) bool {
+	short, ok := isSequenceCode(code)
+	return ok && short >= r.lo && short < r.hi
+}
+
+// validateAlias checks whether the custom alias can be used, the aliases which may be generated
+// in the range of the short domain are rejected, so the generated codes and aliases never collide.
+func validateAlias(alias []byte, reserved map[string]struct{}, generated codeRange) error {
+	if validateCode(alias) != nil {
+		return fmt.Errorf("%w: alias should only contain letters, digits, '_' or '-', and no more than %d characters",
+			ErrInvalidAlias, model.MaxAliasLength)
+	}
+
+	if generated.contains(alias) {
+		return fmt.Errorf("%w: alias %q looks like a generated short code", ErrInvalidAlias, alias)
+	}
+
+	if _, ok := reserved[strings.ToLower(string(alias))]; ok {
+		return fmt.Errorf("%w: alias %q is reserved", ErrInvalidAlias, alias)
+	}
+
+	return nil
+}
+
+// getRecord retrieves the record of the short domain by the generated short code or the custom alias,
+// the canonical Base58 codes are looked up as generated short codes first, since the aliases may also be Base58.
+// Only the exact code of the record is resolved, whatever the collation of the database, so that each record
+// is cached and counted under its own codes only.
+func getRecord(ctx context.Context, db storage.Storage, domain string, code []byte) (*storage.TinyURL, error) {
+	if seq, ok := isSequenceCode(code); ok {
+		record, err := db.GetByShortID(ctx, domain, seq)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return record, err
+		}
+	}
+
+	record, err := db.GetByAlias(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(record.Alias, code) { // matched by a case-insensitive collation, e.g. the alias column is not migrated yet
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return record, nil
+}
+
+// shortCode returns the short code of the record, the custom alias takes precedence over the generated one
+func shortCode(record *storage.TinyURL) []byte {
+	if len(record.Alias) > 0 {
+		return record.Alias
+	}
+
+	return mapping.Base58Encode(record.Short)
+}
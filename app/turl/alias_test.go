@@ -0,0 +1,43 @@
+package turl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+func Test_validateAlias(t *testing.T) {
+	reserved := newReservedAliases([]string{"Login"})
+	// the generated short codes are six characters
+	generated := codeRange{lo: 700000000, hi: 38068692544}
+
+	for _, v := range []string{"spring-sale", "spring_sale", "summer2024", "abcdefghi", "0abc", "promo", "sale", "1zzzzz"} {
+		require.NoError(t, validateAlias([]byte(v), reserved, generated), v)
+	}
+
+	for _, v := range []string{"", "abcdef", "zzzzzz", "spring sale", "spring/sale", "login", "LOGIN", "api", string(make([]byte, 65))} {
+		require.ErrorIs(t, validateAlias([]byte(v), reserved, generated), ErrInvalidAlias, v)
+	}
+
+	// every Base58 code may be generated in the full range
+	require.ErrorIs(t, validateAlias([]byte("promo"), reserved, fullCodeRange), ErrInvalidAlias)
+}
+
+func Test_isSequenceCode(t *testing.T) {
+	seq, ok := isSequenceCode([]byte("3yR"))
+	require.True(t, ok)
+	require.Equal(t, uint64(10000), seq)
+
+	// the codes with leading '1's are not canonical
+	for _, v := range []string{"13yR", "1", "0abc", ""} {
+		_, ok = isSequenceCode([]byte(v))
+		require.False(t, ok, v)
+	}
+}
+
+func Test_shortCode(t *testing.T) {
+	require.Equal(t, []byte("3yR"), shortCode(&storage.TinyURL{Short: 10000}))
+	require.Equal(t, []byte("spring-sale"), shortCode(&storage.TinyURL{Short: 10000, Alias: []byte("spring-sale")}))
+}
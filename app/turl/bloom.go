@@ -0,0 +1,187 @@
+package turl
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/bloom"
+	"github.com/beihai0xff/turl/pkg/mapping"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+const (
+	// defaultFalsePositiveRate is the default false positive rate of the bloom filter
+	defaultFalsePositiveRate = 0.01
+	// defaultBloomRefreshInterval is the default interval to load the short codes created by the other instances
+	defaultBloomRefreshInterval = 10 * time.Second
+	// bloomLoadBatchSize is the number of records loaded from storage in each batch
+	bloomLoadBatchSize = 10000
+	// bloomRescanWindow is how long the gaps of the loaded IDs are scanned again, the inserts committed later
+	// than the window after the records of the greater IDs are loaded are missed
+	bloomRescanWindow = time.Minute
+)
+
+// codeFilter is the bloom filter of the existing short codes, which rejects the short codes not exist without any I/O.
+// The filter is loaded from storage in background on startup, and then loads the records created since last load
+// periodically, since the short codes may be created by the other instances, the short codes created by the
+// current instance are added on creation. The auto-increment IDs may commit out of order under concurrent inserts,
+// so the gaps of the loaded IDs are scanned again until they are older than the rescan window.
+type codeFilter struct {
+	filter *bloom.Filter
+	db     storage.Storage
+	// lastID is the max ID of the loaded records
+	lastID uint
+	// gaps are the first IDs of the gaps of the loaded IDs, and when they are found
+	gaps map[uint]time.Time
+	// ready reports whether the existing records are loaded, the filter accepts every short code before ready
+	ready atomic.Bool
+
+	interval time.Duration
+	now      func() time.Time
+	wg       sync.WaitGroup
+	stop     chan struct{}
+}
+
+// newCodeFilter creates the bloom filter of the existing short codes, nil if the bloom filter is disabled
+func newCodeFilter(db storage.Storage, c *configs.BloomConfig) (*codeFilter, error) {
+	if c == nil || !c.Enable {
+		return nil, nil //nolint:nilnil
+	}
+
+	p := c.FalsePositiveRate
+	if p == 0 {
+		p = defaultFalsePositiveRate
+	}
+
+	filter, err := bloom.New(c.Capacity, p)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &codeFilter{
+		filter:   filter,
+		db:       db,
+		gaps:     make(map[uint]time.Time),
+		interval: c.RefreshInterval,
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+
+	if f.interval <= 0 {
+		f.interval = defaultBloomRefreshInterval
+	}
+
+	f.wg.Add(1)
+	go f.run()
+
+	return f, nil
+}
+
+// run loads the existing records, and then loads the new records periodically
+func (f *codeFilter) run() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.load(); err != nil {
+			slog.Error("failed to load short codes into bloom filter", slog.Any("error", err))
+		} else if !f.ready.Swap(true) {
+			slog.Info("bloom filter of short codes is ready", slog.Uint64("count", f.filter.Count()))
+		}
+
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// load adds the records created since last load into the filter, the records in the gaps of the loaded IDs
+// are loaded again, since they may be committed after the records of the greater IDs.
+func (f *codeFilter) load() error {
+	now := f.now()
+	after := f.rescanFrom(now)
+
+	for {
+		select {
+		case <-f.stop:
+			return nil
+		default:
+		}
+
+		records, err := f.db.ListAfter(context.Background(), after, bloomLoadBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			f.add(r)
+
+			if r.ID > after+1 {
+				if _, ok := f.gaps[after+1]; !ok {
+					f.gaps[after+1] = now
+				}
+			}
+
+			after = r.ID
+			f.lastID = max(f.lastID, r.ID)
+		}
+
+		if len(records) < bloomLoadBatchSize {
+			return nil
+		}
+	}
+}
+
+// rescanFrom drops the gaps older than the rescan window, and returns the ID to load the records after
+func (f *codeFilter) rescanFrom(now time.Time) uint {
+	after := f.lastID
+
+	for id, found := range f.gaps {
+		if now.Sub(found) >= bloomRescanWindow {
+			delete(f.gaps, id)
+			continue
+		}
+
+		after = min(after, id-1)
+	}
+
+	return after
+}
+
+// add adds the short codes of the record into the filter
+func (f *codeFilter) add(record *storage.TinyURL) {
+	f.filter.Add(filterKey(record.Domain, mapping.Base58Encode(record.Short)))
+
+	if len(record.Alias) > 0 {
+		f.filter.Add(filterKey(record.Domain, record.Alias))
+	}
+}
+
+// mayExist reports whether the short code of the domain may exist, false means it definitely does not exist
+func (f *codeFilter) mayExist(domain string, code []byte) bool {
+	if !f.ready.Load() {
+		return true
+	}
+
+	return f.filter.Test(filterKey(domain, code))
+}
+
+// Close stops loading the records
+func (f *codeFilter) Close() {
+	close(f.stop)
+	f.wg.Wait()
+}
+
+// filterKey returns the filter key of the short code in the domain, the short codes are resolved exactly
+// in every database dialect, so they are not normalized.
+func filterKey(domain string, code []byte) []byte {
+	return []byte(domainKey(domain, code))
+}
@@ -0,0 +1,116 @@
+package turl
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/internal/tests/mocks"
+	"github.com/beihai0xff/turl/pkg/bloom"
+	"github.com/beihai0xff/turl/pkg/mapping"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+func Test_newCodeFilter(t *testing.T) {
+	f, err := newCodeFilter(nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, f)
+
+	f, err = newCodeFilter(nil, &configs.BloomConfig{Enable: false, Capacity: 100})
+	require.NoError(t, err)
+	require.Nil(t, f)
+
+	_, err = newCodeFilter(nil, &configs.BloomConfig{Enable: true})
+	require.Error(t, err)
+}
+
+func Test_codeFilter(t *testing.T) {
+	mockStorage := mocks.NewMockStorage(t)
+
+	batch := make([]*storage.TinyURL, bloomLoadBatchSize)
+	for i := range batch {
+		batch[i] = &storage.TinyURL{Short: uint64(i)}
+		batch[i].ID = uint(i + 1)
+	}
+
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(0), bloomLoadBatchSize).Return(batch, nil).Times(1)
+
+	second := []*storage.TinyURL{{Domain: "go.example.com", Short: 1, Alias: []byte("Spring-Sale")}}
+	second[0].ID = bloomLoadBatchSize + 1
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(bloomLoadBatchSize), bloomLoadBatchSize).Return(second, nil).Times(1)
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(bloomLoadBatchSize+1), bloomLoadBatchSize).Return(nil, nil).Maybe()
+
+	f, err := newCodeFilter(mockStorage, &configs.BloomConfig{Enable: true, Capacity: 100000, RefreshInterval: time.Hour})
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	require.Eventually(t, f.ready.Load, time.Second, 10*time.Millisecond)
+
+	require.True(t, f.mayExist("", mapping.Base58Encode(9999)))
+	require.True(t, f.mayExist("go.example.com", mapping.Base58Encode(1)))
+	require.True(t, f.mayExist("go.example.com", []byte("Spring-Sale")))
+	require.False(t, f.mayExist("go.example.com", []byte("spring-sale")))
+	require.False(t, f.mayExist("", []byte("Spring-Sale")))
+	require.False(t, f.mayExist("", mapping.Base58Encode(bloomLoadBatchSize*10)))
+
+	// the short codes created by the current instance are added on creation
+	f.add(&storage.TinyURL{Short: bloomLoadBatchSize * 10})
+	require.True(t, f.mayExist("", mapping.Base58Encode(bloomLoadBatchSize*10)))
+}
+
+func Test_codeFilter_load(t *testing.T) {
+	mockStorage := mocks.NewMockStorage(t)
+	now := time.Now()
+	f := &codeFilter{filter: newTestBloom(t), db: mockStorage, gaps: make(map[uint]time.Time), now: func() time.Time { return now }}
+	f.ready.Store(true)
+
+	newRecord := func(id uint, short uint64) *storage.TinyURL {
+		r := &storage.TinyURL{Short: short}
+		r.ID = id
+
+		return r
+	}
+
+	// the record of ID 2 is not committed yet
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(0), bloomLoadBatchSize).
+		Return([]*storage.TinyURL{newRecord(1, 1000), newRecord(3, 3000)}, nil).Times(1)
+	require.NoError(t, f.load())
+	require.Equal(t, uint(3), f.lastID)
+	require.False(t, f.mayExist("", mapping.Base58Encode(2000)))
+
+	// the gap is scanned again, and the record committed out of order is loaded
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(1), bloomLoadBatchSize).
+		Return([]*storage.TinyURL{newRecord(2, 2000), newRecord(3, 3000)}, nil).Times(1)
+	require.NoError(t, f.load())
+	require.True(t, f.mayExist("", mapping.Base58Encode(2000)))
+	require.Equal(t, uint(3), f.lastID)
+
+	// the gaps are no longer scanned after the rescan window
+	now = now.Add(bloomRescanWindow)
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(3), bloomLoadBatchSize).Return(nil, nil).Times(1)
+	require.NoError(t, f.load())
+	require.Empty(t, f.gaps)
+}
+
+func newTestBloom(t *testing.T) *bloom.Filter {
+	filter, err := bloom.New(100, 0.01)
+	require.NoError(t, err)
+
+	return filter
+}
+
+func Test_codeFilter_notReady(t *testing.T) {
+	mockStorage := mocks.NewMockStorage(t)
+	mockStorage.EXPECT().ListAfter(mock.Anything, uint(0), bloomLoadBatchSize).Return(nil, errors.New("test error")).Maybe()
+
+	f, err := newCodeFilter(mockStorage, &configs.BloomConfig{Enable: true, Capacity: 100, RefreshInterval: time.Hour})
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	// accepts every short code before the existing records are loaded
+	require.True(t, f.mayExist("", []byte("zzzzzz")))
+}
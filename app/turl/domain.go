@@ -0,0 +1,153 @@
+package turl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/mapping"
+)
+
+// ErrUnknownDomain is returned when the target domain of creating a short URL is not served
+var ErrUnknownDomain = fmt.Errorf("%w: unknown domain", mapping.ErrInvalidInput)
+
+// domain is a short domain served by turl server
+type domain struct {
+	// name is the namespace of the short codes in storage and cache, empty for the default domain
+	name string
+	// base is the base URL of the short URLs
+	base string
+	// redirectStatus is the default http status code of the redirects
+	redirectStatus int
+	// fallbackURL is the page to redirect to if the short URL is not resolved, empty to respond the error
+	fallbackURL string
+}
+
+// shortURL returns the short URL of the short code in the domain
+func (d *domain) shortURL(code string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(d.base, "/"), code)
+}
+
+// domains are the short domains served by turl server, indexed by host
+type domains struct {
+	def    *domain
+	byHost map[string]*domain
+}
+
+// newDomains returns the short domains of the config, the ServerConfig.Domain is the default domain,
+// whose short codes are in the empty namespace.
+func newDomains(c *configs.ServerConfig) *domains {
+	def := &domain{base: c.Domain, redirectStatus: http.StatusFound}
+	ds := &domains{def: def, byHost: map[string]*domain{configs.DomainHost(c.Domain): def}}
+
+	for _, v := range c.Domains {
+		host := configs.DomainHost(v.Domain)
+
+		d, ok := ds.byHost[host]
+		if !ok {
+			d = &domain{name: host, base: v.Domain}
+			ds.byHost[host] = d
+		}
+
+		d.redirectStatus, d.fallbackURL = v.RedirectStatus, v.FallbackURL
+		if d.redirectStatus == 0 {
+			d.redirectStatus = http.StatusFound
+		}
+	}
+
+	return ds
+}
+
+// resolve returns the domain of the request host, the unknown hosts fall back to the default domain
+func (ds *domains) resolve(host string) *domain {
+	if d, ok := ds.byHost[configs.DomainHost(host)]; ok {
+		return d
+	}
+
+	return ds.def
+}
+
+// lookup returns the target domain of the management API, the default domain is returned if target is empty
+func (ds *domains) lookup(target string) (*domain, error) {
+	if target == "" {
+		return ds.def, nil
+	}
+
+	if d, ok := ds.byHost[configs.DomainHost(target)]; ok {
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("%w %q", ErrUnknownDomain, target)
+}
+
+// sequenceConfigs returns the tddl config with the sequences of the short domains,
+// the domain sequences are named after the domain hosts.
+func sequenceConfigs(c *configs.ServerConfig) *configs.TDDLConfig {
+	t := *c.TDDL
+	t.Sequences = make(map[string]*configs.SequenceConfig, len(c.TDDL.Sequences)+len(c.Domains))
+
+	for name, sc := range c.TDDL.Sequences {
+		t.Sequences[name] = sc
+	}
+
+	for _, v := range c.Domains {
+		if v.Sequence != nil {
+			t.Sequences[configs.DomainHost(v.Domain)] = v.Sequence
+		}
+	}
+
+	return &t
+}
+
+// codeRanges returns the ranges of the short IDs generated in the short domains by the generator, keyed by
+// the domain names. The default domain generates from the default sequence and the tenant sequences,
+// and the other domains from their own sequences, the snowflake sequence numbers may be any number.
+func codeRanges(c *configs.ServerConfig, gen mapping.Generator) map[string]codeRange {
+	start := func(sc *configs.SequenceConfig) uint64 {
+		switch {
+		case c.TDDL.Type == configs.TDDLTypeSnowflake:
+			return 0
+		case sc != nil && sc.StartNum > 0:
+			return sc.StartNum
+		default:
+			return c.TDDL.StartNum
+		}
+	}
+
+	seqMin := start(nil)
+	for _, sc := range c.TDDL.Sequences {
+		seqMin = min(seqMin, start(sc))
+	}
+
+	def := configs.DomainHost(c.Domain)
+	ranges := map[string]codeRange{"": newCodeRange(gen, seqMin)}
+
+	for _, v := range c.Domains {
+		if host := configs.DomainHost(v.Domain); host != def {
+			ranges[host] = newCodeRange(gen, start(v.Sequence))
+		}
+	}
+
+	return ranges
+}
+
+// domainKey returns the key of the short code in the domain namespace, which is used by the cache and analytics,
+// the keys of the default domain are the short codes themselves.
+func domainKey(domain string, code []byte) string {
+	if domain == "" {
+		return string(code)
+	}
+
+	return domain + "/" + string(code)
+}
+
+// splitDomainKey splits the key returned by domainKey into the domain and the short code,
+// the short codes never contain '/'.
+func splitDomainKey(key string) (string, []byte) {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i], []byte(key[i+1:])
+	}
+
+	return "", []byte(key)
+}
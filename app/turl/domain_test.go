@@ -0,0 +1,94 @@
+package turl
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/mapping"
+)
+
+func Test_domains(t *testing.T) {
+	ds := newDomains(&configs.ServerConfig{
+		Domain: "http://localhost:8080",
+		Domains: []*configs.DomainConfig{
+			{Domain: "https://go.example.com/", RedirectStatus: http.StatusMovedPermanently},
+			{Domain: "http://localhost:8080", FallbackURL: "https://www.example.com/404"},
+		},
+	})
+
+	// the default domain may be listed to set its redirect status and fallback page
+	d := ds.resolve("localhost")
+	require.Equal(t, "", d.name)
+	require.Equal(t, http.StatusFound, d.redirectStatus)
+	require.Equal(t, "https://www.example.com/404", d.fallbackURL)
+	require.Equal(t, "http://localhost:8080/abc", d.shortURL("abc"))
+
+	d = ds.resolve("GO.example.com:443")
+	require.Equal(t, "go.example.com", d.name)
+	require.Equal(t, http.StatusMovedPermanently, d.redirectStatus)
+	require.Equal(t, "https://go.example.com/abc", d.shortURL("abc"))
+
+	// the unknown hosts fall back to the default domain
+	require.Same(t, ds.def, ds.resolve("127.0.0.1:8080"))
+
+	d, err := ds.lookup("go.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "go.example.com", d.name)
+
+	d, err = ds.lookup("")
+	require.NoError(t, err)
+	require.Same(t, ds.def, d)
+
+	_, err = ds.lookup("unknown.example.com")
+	require.ErrorIs(t, err, ErrUnknownDomain)
+}
+
+func Test_sequenceConfigs(t *testing.T) {
+	c := &configs.ServerConfig{
+		TDDL: &configs.TDDLConfig{Sequences: map[string]*configs.SequenceConfig{"tenant-a": {StartNum: 100}}},
+		Domains: []*configs.DomainConfig{
+			{Domain: "https://go.example.com", Sequence: &configs.SequenceConfig{StartNum: 1}},
+			{Domain: "https://s.example.com"},
+		},
+	}
+
+	got := sequenceConfigs(c)
+	require.Len(t, got.Sequences, 2)
+	require.Equal(t, uint64(1), got.Sequences["go.example.com"].StartNum)
+	require.Len(t, c.TDDL.Sequences, 1)
+}
+
+func Test_domainKey(t *testing.T) {
+	require.Equal(t, "abc", domainKey("", []byte("abc")))
+	require.Equal(t, "go.example.com/abc", domainKey("go.example.com", []byte("abc")))
+}
+
+func Test_codeRanges(t *testing.T) {
+	c := &configs.ServerConfig{
+		Domain: "https://s.example.com",
+		TDDL: &configs.TDDLConfig{
+			StartNum:  700000000,
+			Sequences: map[string]*configs.SequenceConfig{"tenant-a": {StartNum: 600000000, MaxNum: 700000000}},
+		},
+		Domains: []*configs.DomainConfig{
+			{Domain: "https://go.example.com", Sequence: &configs.SequenceConfig{StartNum: 1}},
+			{Domain: "https://x.example.com"},
+		},
+	}
+
+	ranges := codeRanges(c, mapping.Sequential{})
+	require.Equal(t, codeRange{lo: 600000000, hi: math.MaxUint64}, ranges[""])
+	require.Equal(t, codeRange{lo: 1, hi: math.MaxUint64}, ranges["go.example.com"])
+	require.Equal(t, codeRange{lo: 700000000, hi: math.MaxUint64}, ranges["x.example.com"])
+
+	c.TDDL.Type = configs.TDDLTypeSnowflake
+	require.Equal(t, fullCodeRange, codeRanges(c, mapping.Sequential{})[""])
+
+	random := codeRanges(c, mapping.Random{})[""]
+	require.True(t, random.contains([]byte("2222222")))
+	require.False(t, random.contains([]byte("promo")))
+}
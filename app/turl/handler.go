@@ -1,22 +1,48 @@
 package turl
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
 	"github.com/beihai0xff/turl/app/turl/model"
 	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/analytics"
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/breaker"
 	"github.com/beihai0xff/turl/pkg/mapping"
 )
 
+const (
+	// defaultStatsDays is the default number of recent days of the daily clicks
+	defaultStatsDays = 30
+	// maxBatchBodySize is the max body size of the batch shorten request
+	maxBatchBodySize = 4 << 20
+	// mimeNDJSON is the content type of newline delimited JSON
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// errBatchSize is returned when the batch shorten request has no item or too many items
+var errBatchSize = fmt.Errorf("the number of items should be between 1 and %d", model.MaxBatchSize)
+
 // Handler represents the request handler.
 type Handler struct {
-	domain string
-	s      Service
+	// domains are the short domains served by the handler
+	domains *domains
+	s       Service
+	// analytics records the click events, nil if analytics is disabled
+	analytics analytics.Analytics
+	// keys authenticates the API keys of the management API, nil in read-only mode
+	keys apikey.Store
+	// breaker is the circuit breaker of redis shared by the caches and the rate limiter, reported by the health check
+	breaker *breaker.Breaker
 }
 
 // NewHandler creates a new Handler.
@@ -26,10 +52,50 @@ func NewHandler(c *configs.ServerConfig) (*Handler, error) {
 		return nil, err
 	}
 
-	return &Handler{
-		s:      s,
-		domain: c.Domain,
-	}, nil
+	h := &Handler{
+		s:       s,
+		domains: newDomains(c),
+		breaker: s.breaker,
+	}
+
+	if !c.Readonly {
+		if err = s.db.AutoMigrate(&apikey.APIKey{}); err != nil {
+			return nil, err
+		}
+
+		h.keys = apikey.New(s.db)
+	}
+
+	if c.Analytics != nil && c.Analytics.Enable {
+		if h.analytics, err = newAnalytics(c, s.db); err != nil {
+			return nil, err
+		}
+	}
+
+	// warm up before the server starts, so the server is not reported healthy until the local cache is warm
+	s.queryService.warmUp(context.Background(), c.Cache.WarmUp, h.analytics)
+
+	return h, nil
+}
+
+// newAnalytics creates the analytics of the click events, which uses a dedicated connection pool, so that
+// flushing click events never contends with the redirects. The embedded SQLite file allows only one writer,
+// so the connection pool of the service is shared instead, to serialize the writes of the same process.
+// The read-only instances never write the database, the click events are dropped, and the click statistics
+// are still queried, e.g. to warm up the local cache.
+func newAnalytics(c *configs.ServerConfig, db *gorm.DB) (analytics.Analytics, error) {
+	if c.Readonly {
+		return analytics.NewReadOnly(db), nil
+	}
+
+	if c.DB.Driver != configs.DriverSQLite {
+		var err error
+		if db, err = getDB(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return analytics.New(db, c.Analytics)
 }
 
 // Create creates a new short URL from the long URL. godoc
@@ -42,7 +108,9 @@ func NewHandler(c *configs.ServerConfig) (*Handler, error) {
 //	@Param			data	body		model.CreateRequest	true	"request body"
 //	@Success		200		{object}	model.ShortenResponse
 //	@Failure		400		{object}	model.ShortenResponse
+//	@Failure		409		{object}	model.ShortenResponse
 //	@Failure		500		{object}	model.ShortenResponse
+//	@Security		BearerAuth
 //	@Router			/shorten [post]
 func (h *Handler) Create(c *gin.Context) {
 	var req model.CreateRequest
@@ -52,18 +120,129 @@ func (h *Handler) Create(c *gin.Context) {
 		return
 	}
 
-	record, err := h.s.Create(c, []byte(req.LongURL))
+	d, err := h.domains.lookup(req.Domain)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, &model.ShortenResponse{TinyURL: model.TinyURL{LongURL: req.LongURL}, Error: err.Error()})
+		c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: model.TinyURL{LongURL: req.LongURL}, Error: err.Error()})
+		return
+	}
+
+	req.Domain = d.name
+
+	record, err := h.s.Create(c, []byte(req.LongURL), &req.CreateOption)
+	if err != nil {
+		t := model.TinyURL{LongURL: req.LongURL}
+		if errors.Is(err, mapping.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: t, Error: err.Error()})
+			return
+		}
+
+		if errors.Is(err, ErrAliasConflict) || errors.Is(err, ErrOptionsConflict) {
+			c.JSON(http.StatusConflict, &model.ShortenResponse{TinyURL: t, Error: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, &model.ShortenResponse{TinyURL: t, Error: err.Error()})
+
 		return
 	}
 
-	record.ShortURL = fmt.Sprintf("%s/%s", h.domain, record.ShortURL)
+	record.ShortURL = d.shortURL(record.ShortURL)
 
 	c.JSON(http.StatusOK, &model.ShortenResponse{TinyURL: *record})
 }
 
-// Redirect redirects the short URL to the original long URL temporarily if the short URL exists. godoc
+// BatchCreate creates short URLs from long URLs in bulk, the request body is a JSON array of create requests,
+// or one create request per line if the content type is application/x-ndjson. godoc
+//
+//	@Summary		Create short links from long links in bulk
+//	@Description	Create short links from long links in bulk, returns the per-item results in the request order
+//	@Tags			command
+//	@Accept			json,application/x-ndjson
+//	@Produce		json
+//	@Param			data	body		[]model.CreateRequest	true	"request body"
+//	@Success		200		{object}	model.BatchShortenResponse
+//	@Failure		400		{object}	model.BatchShortenResponse
+//	@Failure		500		{object}	model.BatchShortenResponse
+//	@Security		BearerAuth
+//	@Router			/shorten/batch [post]
+func (h *Handler) BatchCreate(c *gin.Context) {
+	reqs, err := bindBatchRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &model.BatchShortenResponse{Error: err.Error()})
+		return
+	}
+
+	// the items targeting unknown domains fail alone, the others are created by the service
+	targets, errs := make([]*domain, len(reqs)), make([]error, len(reqs))
+	known := make([]model.CreateRequest, 0, len(reqs))
+
+	for i := range reqs {
+		if targets[i], errs[i] = h.domains.lookup(reqs[i].Domain); errs[i] == nil {
+			reqs[i].Domain = targets[i].name
+			known = append(known, reqs[i])
+		}
+	}
+
+	created, err := h.s.BatchCreate(c, known)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, &model.BatchShortenResponse{Error: err.Error()})
+		return
+	}
+
+	results := make([]model.ShortenResponse, len(reqs))
+	for i, j := 0, 0; i < len(reqs); i++ {
+		if errs[i] != nil {
+			results[i] = model.ShortenResponse{TinyURL: model.TinyURL{LongURL: reqs[i].LongURL}, Error: errs[i].Error()}
+			continue
+		}
+
+		results[i] = created[j]
+		if results[i].Error == "" {
+			results[i].ShortURL = targets[i].shortURL(results[i].ShortURL)
+		}
+
+		j++
+	}
+
+	c.JSON(http.StatusOK, &model.BatchShortenResponse{Results: results})
+}
+
+// bindBatchRequest decodes the batch shorten request, the items are validated by the service one by one,
+// so that an invalid item does not fail the whole batch.
+func bindBatchRequest(c *gin.Context) ([]model.CreateRequest, error) {
+	var (
+		reqs []model.CreateRequest
+		dec  = json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBodySize))
+	)
+
+	if c.ContentType() == mimeNDJSON {
+		for len(reqs) <= model.MaxBatchSize {
+			var req model.CreateRequest
+			if err := dec.Decode(&req); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return nil, err
+			}
+
+			reqs = append(reqs, req)
+		}
+	} else if err := dec.Decode(&reqs); err != nil {
+		return nil, err
+	}
+
+	if len(reqs) == 0 || len(reqs) > model.MaxBatchSize {
+		return nil, errBatchSize
+	}
+
+	return reqs, nil
+}
+
+// Redirect redirects the short URL to the original long URL if the short URL exists,
+// the short URL can be either a generated short code or a custom alias, which is resolved in the namespace
+// of the request host. The redirect status and the fallback page of the unresolved short URLs
+// are configured per domain. godoc
 //
 //	@Summary		Redirect to the original long URL
 //	@Description	Redirect to the original long URL
@@ -71,50 +250,173 @@ func (h *Handler) Create(c *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			short	path		string	true	"short URL"
+//	@Success		200		{string}	string	"the interstitial page"
 //	@Success		302		{string}	string
 //	@Failure		400		{object}	model.ShortenResponse
 //	@Failure		404		{object}	model.ShortenResponse
+//	@Failure		410		{object}	model.ShortenResponse
 //	@Failure		500		{object}	model.ShortenResponse
 //	@Router			/:short [get]
 func (h *Handler) Redirect(c *gin.Context) {
-	short := []byte(c.Param("short"))
-	if len(short) > 8 || len(short) < 6 {
-		c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: model.TinyURL{ShortURL: string(short)}, Error: "invalid short URL"})
+	d, short := h.domains.resolve(c.Request.Host), []byte(c.Param("short"))
+	if len(short) > model.MaxAliasLength {
+		h.redirectFailed(c, d, http.StatusBadRequest, "invalid short URL")
 		return
 	}
 
-	long, err := h.s.Retrieve(c, short)
+	target, err := h.s.Retrieve(c, d.name, short)
 	if err != nil {
-		t := model.TinyURL{ShortURL: string(short)}
 		if errors.Is(err, mapping.ErrInvalidInput) {
-			c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: t, Error: "invalid short URL"})
+			h.redirectFailed(c, d, http.StatusBadRequest, "invalid short URL")
 			return
 		}
 
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, &model.ShortenResponse{TinyURL: t, Error: "short URL not found"})
+			h.redirectFailed(c, d, http.StatusNotFound, "short URL not found")
 			return
 		}
 
-		c.JSON(http.StatusInternalServerError, &model.ShortenResponse{TinyURL: t, Error: err.Error()})
+		if errors.Is(err, ErrLinkExpired) {
+			h.redirectFailed(c, d, http.StatusGone, "short URL has expired")
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, &model.ShortenResponse{TinyURL: model.TinyURL{ShortURL: string(short)}, Error: err.Error()})
+
+		return
+	}
+
+	// the clicks of the alias and the generated short code are recorded as the same link
+	h.record(c, domainKey(d.name, target.Short))
+
+	switch target.Mode {
+	case model.RedirectDefault:
+		c.Redirect(d.redirectStatus, string(target.LongURL))
+	case model.RedirectInterstitial:
+		page, err := renderInterstitial(target.LongURL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, &model.ShortenResponse{TinyURL: model.TinyURL{ShortURL: string(short)}, Error: err.Error()})
+			return
+		}
+
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+	default:
+		c.Redirect(int(redirectStatus(target.Mode)), string(target.LongURL))
+	}
+}
+
+// redirectFailed redirects to the fallback page of the domain if configured, otherwise responds the error
+func (h *Handler) redirectFailed(c *gin.Context, d *domain, code int, msg string) {
+	if d.fallbackURL != "" {
+		c.Redirect(http.StatusFound, d.fallbackURL)
+		return
+	}
+
+	c.JSON(code, &model.ShortenResponse{TinyURL: model.TinyURL{ShortURL: c.Param("short")}, Error: msg})
+}
+
+// record records the click event of the short code key asynchronously, it never blocks the redirect
+func (h *Handler) record(c *gin.Context, key string) {
+	if h.analytics == nil {
+		return
+	}
+
+	h.analytics.Record(&analytics.Event{
+		Short:     key,
+		ClickedAt: time.Now(),
+		Referrer:  c.Request.Referer(),
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	})
+}
+
+// GetStats returns the click statistics of the short URL.
+//
+//	@Summary		Get the click statistics of the short URL
+//	@Description	Get the total clicks and the daily clicks of the short URL
+//	@Tags			query
+//	@Accept			json
+//	@Produce		json
+//	@Param			short_url	query		string	true	"short URL"
+//	@Param			days		query		int		false	"number of recent days of the daily clicks"
+//	@Success		200			{object}	model.StatsResponse
+//	@Failure		400			{object}	model.StatsResponse
+//	@Failure		404			{object}	model.StatsResponse
+//	@Failure		500			{object}	model.StatsResponse
+//	@Failure		501			{object}	model.StatsResponse
+//	@Security		BearerAuth
+//	@Router			/shorten/stats [get]
+func (h *Handler) GetStats(c *gin.Context) {
+	var req model.StatsRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, &model.StatsResponse{ShortURL: req.ShortURL, Error: err.Error()})
+		return
+	}
+
+	if h.analytics == nil {
+		c.JSON(http.StatusNotImplemented, &model.StatsResponse{ShortURL: req.ShortURL, Error: "analytics is disabled"})
+		return
+	}
+
+	d, err := h.domains.lookup(req.Domain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &model.StatsResponse{ShortURL: req.ShortURL, Error: err.Error()})
+		return
+	}
+
+	short, err := h.s.Authorize(c, d.name, []byte(req.ShortURL))
+	if err != nil {
+		if errors.Is(err, mapping.ErrInvalidInput) {
+			c.JSON(http.StatusBadRequest, &model.StatsResponse{ShortURL: req.ShortURL, Error: "invalid short URL"})
+			return
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, &model.StatsResponse{ShortURL: req.ShortURL, Error: "short URL not found"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, &model.StatsResponse{ShortURL: req.ShortURL, Error: err.Error()})
+
+		return
+	}
+
+	if req.Days == 0 {
+		req.Days = defaultStatsDays
+	}
 
+	// the daily clicks include today, so start from the beginning of the day days-1 ago
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-req.Days+1, 0, 0, 0, 0, now.Location())
+
+	stats, err := h.analytics.Stats(c, domainKey(d.name, short), since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, &model.StatsResponse{ShortURL: req.ShortURL, Error: err.Error()})
 		return
 	}
 
-	c.Redirect(http.StatusFound, string(long))
+	resp := &model.StatsResponse{ShortURL: req.ShortURL, Total: stats.Total, Daily: make([]model.DailyClicks, 0, len(stats.Daily))}
+	for _, v := range stats.Daily {
+		resp.Daily = append(resp.Daily, model.DailyClicks{Date: v.Date, Clicks: v.Clicks})
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
-// GetShortenInfo returns the original long URL of the short URL.
+// GetShortenInfo returns all the short URLs of the long URL, the first one is also set at the top level.
 //
-//	@Summary		Get the original long URL of the short URL
-//	@Description	Get the original long URL of the short URL
+//	@Summary		Get the short URLs of the long URL
+//	@Description	Get the short URLs of the long URL
 //	@Tags			query
 //	@Accept			json
 //	@Produce		json
 //	@Param			long_url	query		string	true	"long URL"
-//	@Success		200			{object}	model.ShortenResponse
-//	@Failure		400			{object}	model.ShortenResponse
-//	@Failure		500			{object}	model.ShortenResponse
+//	@Success		200			{object}	model.ShortenInfoResponse
+//	@Failure		400			{object}	model.ShortenInfoResponse
+//	@Failure		500			{object}	model.ShortenInfoResponse
+//	@Security		BearerAuth
 //	@Router			/shorten [get]
 func (h *Handler) GetShortenInfo(c *gin.Context) {
 	var req model.CreateRequest
@@ -124,15 +426,27 @@ func (h *Handler) GetShortenInfo(c *gin.Context) {
 		return
 	}
 
-	record, err := h.s.GetByLong(c, []byte(req.LongURL))
+	d, err := h.domains.lookup(req.Domain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: model.TinyURL{LongURL: req.LongURL}, Error: err.Error()})
+		return
+	}
+
+	records, err := h.s.ListByLong(c, d.name, []byte(req.LongURL))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, &model.ShortenResponse{Error: err.Error()})
 		return
 	}
 
-	record.ShortURL = fmt.Sprintf("%s/%s", h.domain, record.ShortURL)
+	resp := &model.ShortenInfoResponse{Links: make([]model.TinyURL, 0, len(records))}
+	for _, record := range records {
+		record.ShortURL = d.shortURL(record.ShortURL)
+		resp.Links = append(resp.Links, *record)
+	}
 
-	c.JSON(http.StatusOK, &model.ShortenResponse{TinyURL: *record})
+	resp.TinyURL = resp.Links[0]
+
+	c.JSON(http.StatusOK, resp)
 }
 
 // Delete deletes the short URL.
@@ -147,6 +461,7 @@ func (h *Handler) GetShortenInfo(c *gin.Context) {
 //	@Failure		400		{object}	model.ShortenResponse
 //	@Failure		404		{object}	model.ShortenResponse
 //	@Failure		500		{object}	model.ShortenResponse
+//	@Security		BearerAuth
 //	@Router			/shorten [delete]
 func (h *Handler) Delete(c *gin.Context) {
 	var req model.ShortenRequest
@@ -158,8 +473,13 @@ func (h *Handler) Delete(c *gin.Context) {
 
 	t := model.TinyURL{ShortURL: req.ShortURL}
 
-	err := h.s.Delete(c, []byte(req.ShortURL))
+	d, err := h.domains.lookup(req.Domain)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: t, Error: err.Error()})
+		return
+	}
+
+	if err = h.s.Delete(c, d.name, []byte(req.ShortURL)); err != nil {
 		if errors.Is(err, mapping.ErrInvalidInput) {
 			c.JSON(http.StatusBadRequest, &model.ShortenResponse{TinyURL: t, Error: "invalid short URL"})
 			return
@@ -180,5 +500,11 @@ func (h *Handler) Delete(c *gin.Context) {
 
 // Close closes the handler.
 func (h *Handler) Close() error {
+	if h.analytics != nil {
+		if err := h.analytics.Close(); err != nil {
+			return err
+		}
+	}
+
 	return h.s.Close()
 }
@@ -2,9 +2,11 @@ package turl
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,13 +16,18 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/beihai0xff/turl/app/turl/model"
+	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests/mocks"
+	"github.com/beihai0xff/turl/pkg/analytics"
 	"github.com/beihai0xff/turl/pkg/mapping"
 )
 
+// testDomains serves the default domain only
+var testDomains = newDomains(&configs.ServerConfig{Domain: "https://www.example.com"})
+
 func TestHandler_Create(t *testing.T) {
 	mockService := mocks.NewMockTURLService(t)
-	h := &Handler{s: mockService, domain: "https://www.example.com"}
+	h := &Handler{s: mockService, domains: testDomains}
 
 	router := gin.Default()
 	router.POST("/create", h.Create)
@@ -30,7 +37,7 @@ func TestHandler_Create(t *testing.T) {
 		req.Header.Set("Content-Type", "application/json")
 		resp := httptest.NewRecorder()
 
-		mockService.EXPECT().Create(mock.Anything, mock.Anything).Return(&model.TinyURL{
+		mockService.EXPECT().Create(mock.Anything, mock.Anything, mock.Anything).Return(&model.TinyURL{
 			ShortURL:  "abcefg",
 			LongURL:   "https://www.example.com",
 			CreatedAt: time.Now(),
@@ -43,6 +50,15 @@ func TestHandler_Create(t *testing.T) {
 		require.Contains(t, resp.Body.String(), `"long_url":"https://www.example.com"`)
 	})
 
+	t.Run("CreateUnknownDomain", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"long_url":"https://www.example.com","domain":"go.example.com"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+		require.Contains(t, resp.Body.String(), "unknown domain")
+	})
+
 	t.Run("CreateInvalidURL", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/create", nil)
 		req.Header.Set("Content-Type", "application/json")
@@ -54,7 +70,7 @@ func TestHandler_Create(t *testing.T) {
 
 	t.Run("CreateURLFailed", func(t *testing.T) {
 		testErr := errors.New("test error")
-		mockService.EXPECT().Create(mock.Anything, mock.Anything).Return(nil, testErr).Times(1)
+		mockService.EXPECT().Create(mock.Anything, mock.Anything, mock.Anything).Return(nil, testErr).Times(1)
 
 		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"long_url":"https://www.example.com"}`)))
 		req.Header.Set("Content-Type", "application/json")
@@ -62,17 +78,130 @@ func TestHandler_Create(t *testing.T) {
 		router.ServeHTTP(resp, req)
 		require.Equal(t, http.StatusInternalServerError, resp.Code)
 	})
+
+	t.Run("CreateInvalidAlias", func(t *testing.T) {
+		mockService.EXPECT().Create(mock.Anything, mock.Anything, &model.CreateOption{Alias: "abcdef"}).Return(nil, ErrInvalidAlias).Times(1)
+
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"long_url":"https://www.example.com","alias":"abcdef"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("CreateAliasConflict", func(t *testing.T) {
+		mockService.EXPECT().Create(mock.Anything, mock.Anything, &model.CreateOption{Alias: "spring-sale"}).Return(nil, ErrAliasConflict).Times(1)
+
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"long_url":"https://www.example.com","alias":"spring-sale"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusConflict, resp.Code)
+	})
+
+	t.Run("CreateOptionsConflict", func(t *testing.T) {
+		mockService.EXPECT().Create(mock.Anything, mock.Anything, &model.CreateOption{RedirectMode: model.RedirectPermanent}).
+			Return(nil, ErrOptionsConflict).Times(1)
+
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"long_url":"https://www.example.com","redirect_mode":"308"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusConflict, resp.Code)
+	})
+}
+
+func TestHandler_BatchCreate(t *testing.T) {
+	mockService := mocks.NewMockTURLService(t)
+	h := &Handler{s: mockService, domains: testDomains}
+
+	router := gin.Default()
+	router.POST("/batch", h.BatchCreate)
+
+	results := []model.ShortenResponse{
+		{TinyURL: model.TinyURL{ShortURL: "abcefg", LongURL: "https://www.example.com/1"}},
+		{TinyURL: model.TinyURL{LongURL: "invalid url"}, Error: "invalid url"},
+	}
+	wantReqs := []model.CreateRequest{
+		{LongURL: "https://www.example.com/1"},
+		{LongURL: "invalid url", CreateOption: model.CreateOption{Alias: "spring-sale"}},
+	}
+
+	for _, tc := range []struct {
+		name, contentType, body string
+	}{
+		{"BatchCreateJSON", "application/json", `[{"long_url":"https://www.example.com/1"},{"long_url":"invalid url","alias":"spring-sale"}]`},
+		{"BatchCreateNDJSON", mimeNDJSON, "{\"long_url\":\"https://www.example.com/1\"}\n{\"long_url\":\"invalid url\",\"alias\":\"spring-sale\"}\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.EXPECT().BatchCreate(mock.Anything, wantReqs).Return(append([]model.ShortenResponse{}, results...), nil).Times(1)
+
+			req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Contains(t, resp.Body.String(), `"short_url":"https://www.example.com/abcefg"`)
+			require.Contains(t, resp.Body.String(), `"short_url":"","long_url":"invalid url"`)
+			require.Contains(t, resp.Body.String(), `"error":"invalid url"`)
+		})
+	}
+
+	t.Run("BatchCreateUnknownDomain", func(t *testing.T) {
+		mockService.EXPECT().BatchCreate(mock.Anything, wantReqs[:1]).Return(results[:1], nil).Times(1)
+
+		body := `[{"long_url":"https://www.example.com/1"},{"long_url":"https://www.example.com/2","domain":"go.example.com"}]`
+		req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Contains(t, resp.Body.String(), `"short_url":"https://www.example.com/abcefg"`)
+		require.Contains(t, resp.Body.String(), `"long_url":"https://www.example.com/2"`)
+		require.Contains(t, resp.Body.String(), `unknown domain`)
+	})
+
+	t.Run("BatchCreateInvalidRequest", func(t *testing.T) {
+		for _, tc := range []struct {
+			contentType, body string
+		}{
+			{"application/json", `{"long_url":"https://www.example.com"}`},
+			{"application/json", `[]`},
+			{"application/json", "[" + strings.Repeat(`{"long_url":"https://www.example.com"},`, model.MaxBatchSize) + `{}]`},
+			{mimeNDJSON, ""},
+			{mimeNDJSON, "{\"long_url\":\"https://www.example.com\"}\nnot json"},
+			{mimeNDJSON, strings.Repeat("{\"long_url\":\"https://www.example.com\"}\n", model.MaxBatchSize+1)},
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusBadRequest, resp.Code, tc.body)
+		}
+	})
+
+	t.Run("BatchCreateFailed", func(t *testing.T) {
+		mockService.EXPECT().BatchCreate(mock.Anything, mock.Anything).Return(nil, errors.New("test error")).Times(1)
+
+		req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[{"long_url":"https://www.example.com"}]`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusInternalServerError, resp.Code)
+	})
 }
 
 func TestHandler_Redirect(t *testing.T) {
 	mockService := mocks.NewMockTURLService(t)
-	h := &Handler{s: mockService}
+	h := &Handler{s: mockService, domains: testDomains}
 
 	router := gin.Default()
 	router.GET("/redirect/:short", h.Redirect)
 
 	t.Run("RedirectExistingURL", func(t *testing.T) {
-		mockService.EXPECT().Retrieve(mock.Anything, []byte("abc123")).Return([]byte("https://www.example.com"), nil).Times(1)
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc123")).Return(&model.Redirect{LongURL: []byte("https://www.example.com")}, nil).Times(1)
 
 		req := httptest.NewRequest(http.MethodGet, "/redirect/abc123", nil)
 		resp := httptest.NewRecorder()
@@ -81,8 +210,42 @@ func TestHandler_Redirect(t *testing.T) {
 		require.Equal(t, "https://www.example.com", resp.Header().Get("Location"))
 	})
 
+	t.Run("RedirectAlias", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("spring-sale")).Return(&model.Redirect{LongURL: []byte("https://www.example.com")}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/spring-sale", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusFound, resp.Code)
+		require.Equal(t, "https://www.example.com", resp.Header().Get("Location"))
+	})
+
+	t.Run("RedirectPermanent", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc124")).
+			Return(&model.Redirect{LongURL: []byte("https://www.example.com"), Mode: model.RedirectPermanent}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc124", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusPermanentRedirect, resp.Code)
+		require.Equal(t, "https://www.example.com", resp.Header().Get("Location"))
+	})
+
+	t.Run("RedirectInterstitial", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc125")).
+			Return(&model.Redirect{LongURL: []byte("https://www.example.com/?a=1&b=2"), Mode: model.RedirectInterstitial}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc125", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Empty(t, resp.Header().Get("Location"))
+		require.Equal(t, "no-referrer", resp.Header().Get("Referrer-Policy"))
+		require.Contains(t, resp.Body.String(), `<meta http-equiv="refresh" content="0; url=https://www.example.com/?a=1&amp;b=2">`)
+	})
+
 	t.Run("RedirectNonExistingURL", func(t *testing.T) {
-		mockService.EXPECT().Retrieve(mock.Anything, []byte("abc321")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc321")).Return(nil, gorm.ErrRecordNotFound).Times(1)
 
 		req := httptest.NewRequest(http.MethodGet, "/redirect/abc321", nil)
 		resp := httptest.NewRecorder()
@@ -90,13 +253,22 @@ func TestHandler_Redirect(t *testing.T) {
 		require.Equal(t, http.StatusNotFound, resp.Code)
 	})
 
+	t.Run("RedirectExpiredURL", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc456")).Return(nil, ErrLinkExpired).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc456", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusGone, resp.Code)
+	})
+
 	t.Run("RedirectInvalidURL", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/redirect/123456789", nil)
+		req := httptest.NewRequest(http.MethodGet, "/redirect/"+strings.Repeat("a", model.MaxAliasLength+1), nil)
 		resp := httptest.NewRecorder()
 		router.ServeHTTP(resp, req)
 		require.Equal(t, http.StatusBadRequest, resp.Code)
 
-		mockService.EXPECT().Retrieve(mock.Anything, []byte("0123456")).Return(nil, mapping.ErrorInvalidCharacter).Times(1)
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("0123456")).Return(nil, mapping.ErrorInvalidCharacter).Times(1)
 		req = httptest.NewRequest(http.MethodGet, "/redirect/0123456", nil)
 		resp = httptest.NewRecorder()
 		router.ServeHTTP(resp, req)
@@ -104,15 +276,224 @@ func TestHandler_Redirect(t *testing.T) {
 	})
 }
 
+func TestHandler_Redirect_domains(t *testing.T) {
+	mockService := mocks.NewMockTURLService(t)
+	h := &Handler{s: mockService, domains: newDomains(&configs.ServerConfig{
+		Domain: "https://www.example.com",
+		Domains: []*configs.DomainConfig{
+			{Domain: "https://go.example.com", RedirectStatus: http.StatusMovedPermanently, FallbackURL: "https://www.example.com/404"},
+		},
+	})}
+
+	router := gin.Default()
+	router.GET("/redirect/:short", h.Redirect)
+
+	t.Run("RedirectDomainURL", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "go.example.com", []byte("abc123")).Return(&model.Redirect{LongURL: []byte("https://www.example.com")}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc123", nil)
+		req.Host = "go.example.com"
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusMovedPermanently, resp.Code)
+		require.Equal(t, "https://www.example.com", resp.Header().Get("Location"))
+	})
+
+	t.Run("RedirectFallback", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "go.example.com", []byte("abc321")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc321", nil)
+		req.Host = "go.example.com"
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusFound, resp.Code)
+		require.Equal(t, "https://www.example.com/404", resp.Header().Get("Location"))
+	})
+
+	t.Run("RedirectDefaultDomain", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc321")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc321", nil)
+		req.Host = "unknown.example.com"
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestHandler_Redirect_record(t *testing.T) {
+	mockService := mocks.NewMockTURLService(t)
+	mockAnalytics := mocks.NewMockAnalytics(t)
+	h := &Handler{s: mockService, domains: testDomains, analytics: mockAnalytics}
+
+	router := gin.Default()
+	router.GET("/redirect/:short", h.Redirect)
+
+	t.Run("RecordClick", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc123")).
+			Return(&model.Redirect{LongURL: []byte("https://www.example.com"), Short: []byte("abc123")}, nil).Times(1)
+		mockAnalytics.EXPECT().Record(mock.MatchedBy(func(e *analytics.Event) bool {
+			return e.Short == "abc123" && e.Referrer == "https://www.referrer.com" && e.UserAgent == "test-agent" &&
+				e.IP == "192.0.2.1" && !e.ClickedAt.IsZero()
+		})).Return().Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc123", nil)
+		req.Header.Set("Referer", "https://www.referrer.com")
+		req.Header.Set("User-Agent", "test-agent")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusFound, resp.Code)
+	})
+
+	t.Run("RecordCanonicalCode", func(t *testing.T) {
+		// the clicks of the generated short code of an aliased link are recorded by the alias
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("3yR")).
+			Return(&model.Redirect{LongURL: []byte("https://www.example.com"), Short: []byte("spring-sale")}, nil).Times(1)
+		mockAnalytics.EXPECT().Record(mock.MatchedBy(func(e *analytics.Event) bool {
+			return e.Short == "spring-sale"
+		})).Return().Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/3yR", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusFound, resp.Code)
+	})
+
+	t.Run("NotRecordFailedRedirect", func(t *testing.T) {
+		mockService.EXPECT().Retrieve(mock.Anything, "", []byte("abc321")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/redirect/abc321", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestHandler_GetStats(t *testing.T) {
+	mockService, mockAnalytics := mocks.NewMockTURLService(t), mocks.NewMockAnalytics(t)
+	h := &Handler{s: mockService, domains: testDomains, analytics: mockAnalytics}
+
+	router := gin.Default()
+	router.GET("/stats", h.GetStats)
+
+	t.Run("GetStatsSuccess", func(t *testing.T) {
+		mockService.EXPECT().Authorize(mock.Anything, "", []byte("abc123")).Return([]byte("abc123"), nil).Times(1)
+		mockAnalytics.EXPECT().Stats(mock.Anything, "abc123", mock.MatchedBy(func(since time.Time) bool {
+			want := time.Now().AddDate(0, 0, -6)
+			return since.Hour() == 0 && since.Format(time.DateOnly) == want.Format(time.DateOnly)
+		})).Return(&analytics.Stats{Total: 3, Daily: []analytics.DailyClicks{{Date: "2024-01-02", Clicks: 3}}}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/stats?short_url=abc123&days=7", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.JSONEq(t, `{"short_url":"abc123","total":3,"daily":[{"date":"2024-01-02","clicks":3}]}`, resp.Body.String())
+	})
+
+	t.Run("GetStatsInvalidRequest", func(t *testing.T) {
+		for _, target := range []string{"/stats", "/stats?short_url=abc123&days=0x", "/stats?short_url=abc123&days=1000"} {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusBadRequest, resp.Code, target)
+		}
+
+		mockService.EXPECT().Authorize(mock.Anything, "", []byte("abc 123")).Return(nil, ErrInvalidShortCode).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/stats?short_url=abc%20123", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("GetStatsNotOwned", func(t *testing.T) {
+		mockService.EXPECT().Authorize(mock.Anything, "", []byte("abc456")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/stats?short_url=abc456", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("GetStatsCanonicalCode", func(t *testing.T) {
+		mockService.EXPECT().Authorize(mock.Anything, "", []byte("3yR")).Return([]byte("spring-sale"), nil).Times(1)
+		mockAnalytics.EXPECT().Stats(mock.Anything, "spring-sale", mock.Anything).Return(&analytics.Stats{Total: 5}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/stats?short_url=3yR", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.JSONEq(t, `{"short_url":"3yR","total":5,"daily":[]}`, resp.Body.String())
+	})
+
+	t.Run("GetStatsFailed", func(t *testing.T) {
+		mockService.EXPECT().Authorize(mock.Anything, "", []byte("abc321")).Return([]byte("abc321"), nil).Times(1)
+		mockAnalytics.EXPECT().Stats(mock.Anything, "abc321", mock.Anything).Return(nil, errors.New("test error")).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/stats?short_url=abc321", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusInternalServerError, resp.Code)
+	})
+
+	t.Run("GetStatsDisabled", func(t *testing.T) {
+		router := gin.Default()
+		router.GET("/stats", (&Handler{}).GetStats)
+
+		req := httptest.NewRequest(http.MethodGet, "/stats?short_url=abc123", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusNotImplemented, resp.Code)
+	})
+}
+
+func TestHandler_GetShortenInfo(t *testing.T) {
+	mockService := mocks.NewMockTURLService(t)
+	h := &Handler{s: mockService, domains: testDomains}
+
+	router := gin.Default()
+	router.GET("/shorten", h.GetShortenInfo)
+
+	t.Run("GetShortenInfoSuccess", func(t *testing.T) {
+		mockService.EXPECT().ListByLong(mock.Anything, "", []byte("https://www.example.com")).Return([]*model.TinyURL{
+			{ShortURL: "abcefg", LongURL: "https://www.example.com"},
+			{ShortURL: "abcefh", LongURL: "https://www.example.com"},
+		}, nil).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/shorten?long_url=https://www.example.com", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		var got model.ShortenInfoResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+		require.Equal(t, "https://www.example.com/abcefg", got.ShortURL)
+		require.Len(t, got.Links, 2)
+		require.Equal(t, "https://www.example.com/abcefg", got.Links[0].ShortURL)
+		require.Equal(t, "https://www.example.com/abcefh", got.Links[1].ShortURL)
+	})
+
+	t.Run("GetShortenInfoFailed", func(t *testing.T) {
+		mockService.EXPECT().ListByLong(mock.Anything, "", []byte("https://www.example.com/not-found")).
+			Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		req := httptest.NewRequest(http.MethodGet, "/shorten?long_url=https://www.example.com/not-found", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusInternalServerError, resp.Code)
+	})
+}
+
 func TestHandler_Delete(t *testing.T) {
 	mockService := mocks.NewMockTURLService(t)
-	h := &Handler{s: mockService}
+	h := &Handler{s: mockService, domains: testDomains}
 
 	router := gin.Default()
 	router.DELETE("/delete", h.Delete)
 
 	t.Run("DeleteSuccess", func(t *testing.T) {
-		mockService.EXPECT().Delete(mock.Anything, []byte("abc123")).Return(nil).Times(1)
+		mockService.EXPECT().Delete(mock.Anything, "", []byte("abc123")).Return(nil).Times(1)
 
 		req := httptest.NewRequest(http.MethodDelete, "/delete", bytes.NewBuffer([]byte(`{"short_url":"abc123"}`)))
 		req.Header.Set("Content-Type", "application/json")
@@ -132,7 +513,7 @@ func TestHandler_Delete(t *testing.T) {
 
 	t.Run("DeleteFailed", func(t *testing.T) {
 		testErr := errors.New("test error")
-		mockService.EXPECT().Delete(mock.Anything, []byte("abc123")).Return(testErr).Times(1)
+		mockService.EXPECT().Delete(mock.Anything, "", []byte("abc123")).Return(testErr).Times(1)
 
 		req := httptest.NewRequest(http.MethodDelete, "/delete", bytes.NewBuffer([]byte(`{"short_url":"abc123"}`)))
 		req.Header.Set("Content-Type", "application/json")
@@ -142,7 +523,7 @@ func TestHandler_Delete(t *testing.T) {
 	})
 
 	t.Run("DeleteFailedToDecodeShortURL", func(t *testing.T) {
-		mockService.EXPECT().Delete(mock.Anything, []byte("invalid_short_url")).Return(mapping.ErrorInvalidCharacter).Times(1)
+		mockService.EXPECT().Delete(mock.Anything, "", []byte("invalid_short_url")).Return(mapping.ErrorInvalidCharacter).Times(1)
 		req := httptest.NewRequest(http.MethodDelete, "/delete", bytes.NewBuffer([]byte(`{"short_url":"invalid_short_url"}`)))
 		req.Header.Set("Content-Type", "application/json")
 		resp := httptest.NewRecorder()
@@ -151,7 +532,7 @@ func TestHandler_Delete(t *testing.T) {
 	})
 
 	t.Run("DeleteFailedRecordNotFound", func(t *testing.T) {
-		mockService.EXPECT().Delete(mock.Anything, []byte("abc321")).Return(gorm.ErrRecordNotFound).Times(1)
+		mockService.EXPECT().Delete(mock.Anything, "", []byte("abc321")).Return(gorm.ErrRecordNotFound).Times(1)
 
 		req := httptest.NewRequest(http.MethodDelete, "/delete", bytes.NewBuffer([]byte(`{"short_url":"abc321"}`)))
 		req.Header.Set("Content-Type", "application/json")
@@ -162,7 +543,7 @@ func TestHandler_Delete(t *testing.T) {
 
 	testError := errors.New("test error")
 	t.Run("DeleteFailedToDeleteFromStorage", func(t *testing.T) {
-		mockService.EXPECT().Delete(mock.Anything, []byte("abc123")).Return(testError).Times(1)
+		mockService.EXPECT().Delete(mock.Anything, "", []byte("abc123")).Return(testError).Times(1)
 
 		req := httptest.NewRequest(http.MethodDelete, "/delete", bytes.NewBuffer([]byte(`{"short_url":"abc123"}`)))
 		req.Header.Set("Content-Type", "application/json")
@@ -3,6 +3,8 @@ package turl
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/pprof"
@@ -15,6 +17,8 @@ import (
 	"github.com/beihai0xff/turl/api"
 	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/docs/swagger"
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/breaker"
 	"github.com/beihai0xff/turl/pkg/db/redis"
 	"github.com/beihai0xff/turl/pkg/middleware"
 	"github.com/beihai0xff/turl/pkg/workqueue"
@@ -28,10 +32,17 @@ const HealthCheckPath = "/healthcheck"
 //	@version		1.0
 //	@description	This is a tiny URL service API.
 
+//	@securityDefinitions.apikey	BearerAuth
+//	@in							header
+//	@name						Authorization
+//	@description				API key in the format of "Bearer {key}"
+
 // NewServer creates a new HTTP server.
 func NewServer(h *Handler, c *configs.ServerConfig) (*http.Server, error) {
 	router := gin.New()
 	router.UseH2C = true
+	// the handlers pass gin.Context to the service, fallback to the request context to carry the caller's API key
+	router.ContextWithFallback = true
 
 	gin.SetMode(gin.ReleaseMode)
 
@@ -41,7 +52,12 @@ func NewServer(h *Handler, c *configs.ServerConfig) (*http.Server, error) {
 		gin.SetMode(gin.DebugMode)
 	}
 
-	router.Use(middleware.Logger(), middleware.HealthCheck(HealthCheckPath))
+	var breakers []*breaker.Breaker
+	if h.breaker != nil {
+		breakers = append(breakers, h.breaker)
+	}
+
+	router.Use(middleware.Logger(), middleware.HealthCheck(HealthCheckPath, breakers...))
 
 	router.Use(gin.Recovery()) // recover from any panics, should be the last middleware
 	router.GET("/:short", h.Redirect).Use(middleware.RateLimiter(
@@ -51,14 +67,26 @@ func NewServer(h *Handler, c *configs.ServerConfig) (*http.Server, error) {
 		prefix := fmt.Sprintf("%s%s", api.VersionV1, api.DefaultAPIPrefix)
 		swagger.SwaggerInfo.BasePath = prefix
 
-		rdb := redis.Client(c.Cache.Redis)
-		management := router.Group(prefix).Use(middleware.RateLimiter(
-			workqueue.NewItemRedisTokenRateLimiter[any](rdb, c.GlobalRateLimitKey, c.GlobalWriteRate, c.GlobalWriteBurst, time.Second)))
+		reserver, err := newWriteRateLimiter(c, h.breaker)
+		if err != nil {
+			return nil, err
+		}
+
+		rateLimiter := middleware.KeyedRateLimiter(reserver, rateLimitKey(c))
+
+		management := router.Group(prefix)
+		// the API document is public, the other management APIs require the API key
+		management.GET("/swagger/*any", rateLimiter, ginSwagger.WrapHandler(swaggerfiles.Handler))
+
+		// limit the requests by the client ip before authentication, so that the requests with invalid API keys
+		// are limited too, then authenticate, so that the requests can be limited by the caller's API key or tenant
+		management = management.Group("", middleware.KeyedRateLimiter(reserver, authRateLimitKey(c)),
+			middleware.Auth(h.keys), rateLimiter)
 		management.POST("/shorten", h.Create)
+		management.POST("/shorten/batch", h.BatchCreate)
 		management.GET("/shorten", h.GetShortenInfo)
 		management.DELETE("/shorten", h.Delete)
-
-		management.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
+		management.GET("/shorten/stats", h.GetStats)
 	}
 
 	return &http.Server{
@@ -69,3 +97,61 @@ func NewServer(h *Handler, c *configs.ServerConfig) (*http.Server, error) {
 		WriteTimeout:      c.RequestTimeout,
 	}, nil
 }
+
+// newWriteRateLimiter creates the token bucket rate limiter of the write api, the token buckets are shared
+// by the instances in redis, or in-process without redis, e.g. in the embedded mode.
+func newWriteRateLimiter(c *configs.ServerConfig, b *breaker.Breaker) (middleware.Reserver, error) {
+	if c.Cache.Redis == nil {
+		return workqueue.NewItemTokenRateLimiter[string](c.GlobalWriteRate, c.GlobalWriteBurst), nil
+	}
+
+	rdb, err := redis.Client(c.Cache.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	return workqueue.NewItemRedisTokenRateLimiter[string](rdb, c.GlobalRateLimitKey, c.GlobalWriteRate, c.GlobalWriteBurst, time.Second).
+		WithBreaker(b), nil
+}
+
+// authRateLimitKey returns the function which resolves the rate limit key and limit of the write api request
+// before authentication, the requests are limited by the client ip with the largest limit of the callers,
+// so that the authenticated callers are not limited by it before their own limits.
+func authRateLimitKey(c *configs.ServerConfig) func(ctx *gin.Context) (string, workqueue.Limit) {
+	limit := workqueue.Limit{Rate: c.GlobalWriteRate, Burst: c.GlobalWriteBurst}
+	for _, l := range c.TenantRateLimits {
+		limit.Rate, limit.Burst = max(limit.Rate, l.Rate), max(limit.Burst, l.Burst)
+	}
+
+	return func(ctx *gin.Context) (string, workqueue.Limit) {
+		return "auth:ip:" + ctx.ClientIP(), limit
+	}
+}
+
+// rateLimitKey returns the function which resolves the rate limit key and limit of the write api request,
+// the requests without API key are limited by the client ip, and the tenants may have their own limit.
+func rateLimitKey(c *configs.ServerConfig) func(ctx *gin.Context) (string, workqueue.Limit) {
+	def := workqueue.Limit{Rate: c.GlobalWriteRate, Burst: c.GlobalWriteBurst}
+
+	return func(ctx *gin.Context) (string, workqueue.Limit) {
+		k, ok := apikey.FromContext(ctx)
+		if !ok {
+			return "ip:" + ctx.ClientIP(), def
+		}
+
+		limit := def
+		// the map keys of config file are lower case
+		if l, found := c.TenantRateLimits[strings.ToLower(k.Tenant)]; found {
+			limit = workqueue.Limit{Rate: l.Rate, Burst: l.Burst}
+		}
+
+		switch c.RateLimitBy {
+		case configs.RateLimitByIP:
+			return "ip:" + ctx.ClientIP(), limit
+		case configs.RateLimitByTenant:
+			return "tenant:" + k.Tenant, limit
+		default:
+			return "key:" + strconv.FormatUint(uint64(k.ID), 10), limit
+		}
+	}
+}
@@ -1,11 +1,24 @@
 package turl
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 
+	"github.com/beihai0xff/turl/app/turl/model"
+	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/workqueue"
 )
 
 func TestNewServer(t *testing.T) {
@@ -15,3 +28,110 @@ func TestNewServer(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, got)
 }
+
+func TestNewServer_embedded(t *testing.T) {
+	c := tests.EmbeddedConfig(t.TempDir())
+	// the analytics shares the connection of the sqlite file with the service
+	c.Analytics = &configs.AnalyticsConfig{Enable: true, QueueSize: 100, BatchSize: 10, FlushInterval: 10 * time.Millisecond}
+	require.NoError(t, c.Validate())
+
+	h, err := NewHandler(c)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, h.Close()) })
+
+	srv, err := NewServer(h, c)
+	require.NoError(t, err)
+
+	key, _, err := h.keys.Create(context.Background(), "test", "tenant-a", false)
+	require.NoError(t, err)
+
+	long := "https://www.example.com/embedded"
+	req := httptest.NewRequest(http.MethodPost, "/v1/management/shorten", strings.NewReader(`{"long_url":"`+long+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key)
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var rsp model.ShortenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+
+	u, err := url.Parse(rsp.ShortURL)
+	require.NoError(t, err)
+
+	// twice, the second one is served by the local cache
+	for range 2 {
+		w = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.Path, nil))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, long, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HealthCheckPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_rateLimitKey(t *testing.T) {
+	c := &configs.ServerConfig{
+		GlobalWriteRate:  10,
+		GlobalWriteBurst: 20,
+		TenantRateLimits: map[string]*configs.RateLimitConfig{"tenant-a": {Rate: 100, Burst: 200}},
+	}
+
+	newContext := func(k *apikey.APIKey) *gin.Context {
+		ctx, engine := gin.CreateTestContext(httptest.NewRecorder())
+		engine.ContextWithFallback = true
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx.Request.RemoteAddr = "192.0.2.1:1234"
+
+		if k != nil {
+			ctx.Request = ctx.Request.WithContext(apikey.NewContext(ctx.Request.Context(), k))
+		}
+
+		return ctx
+	}
+
+	def, override := workqueue.Limit{Rate: 10, Burst: 20}, workqueue.Limit{Rate: 100, Burst: 200}
+	keyA := &apikey.APIKey{Model: gorm.Model{ID: 1}, Tenant: "tenant-a"}
+	keyB := &apikey.APIKey{Model: gorm.Model{ID: 2}, Tenant: "tenant-b"}
+
+	for _, tc := range []struct {
+		by        string
+		k         *apikey.APIKey
+		wantKey   string
+		wantLimit workqueue.Limit
+	}{
+		{"", keyA, "key:1", override},
+		{configs.RateLimitByAPIKey, keyB, "key:2", def},
+		{configs.RateLimitByTenant, keyA, "tenant:tenant-a", override},
+		{configs.RateLimitByIP, keyB, "ip:192.0.2.1", def},
+		{configs.RateLimitByAPIKey, nil, "ip:192.0.2.1", def},
+	} {
+		c.RateLimitBy = tc.by
+
+		key, limit := rateLimitKey(c)(newContext(tc.k))
+		require.Equal(t, tc.wantKey, key)
+		require.Equal(t, tc.wantLimit, limit)
+	}
+}
+
+func Test_authRateLimitKey(t *testing.T) {
+	c := &configs.ServerConfig{
+		GlobalWriteRate:  10,
+		GlobalWriteBurst: 20,
+		TenantRateLimits: map[string]*configs.RateLimitConfig{
+			"tenant-a": {Rate: 100, Burst: 5},
+			"tenant-b": {Rate: 1, Burst: 200},
+		},
+	}
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	ctx.Request.RemoteAddr = "192.0.2.1:1234"
+
+	key, limit := authRateLimitKey(c)(ctx)
+	require.Equal(t, "auth:ip:192.0.2.1", key)
+	require.Equal(t, workqueue.Limit{Rate: 100, Burst: 200}, limit)
+}
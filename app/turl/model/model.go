@@ -7,16 +7,98 @@ import (
 	"gorm.io/gorm"
 )
 
+// MaxAliasLength is the max length of the custom alias
+const MaxAliasLength = 64
+
+// MaxBatchSize is the max number of long URLs in a batch shorten request
+const MaxBatchSize = 1000
+
+// RedirectMode is the redirect mode of the short URL, the HTTP status code of the redirect,
+// or the HTML interstitial page which strips the referrer.
+type RedirectMode string
+
+const (
+	// RedirectDefault uses the redirect status code of the short domain
+	RedirectDefault RedirectMode = ""
+	// RedirectMovedPermanently redirects with 301 Moved Permanently
+	RedirectMovedPermanently RedirectMode = "301"
+	// RedirectFound redirects with 302 Found
+	RedirectFound RedirectMode = "302"
+	// RedirectTemporary redirects with 307 Temporary Redirect
+	RedirectTemporary RedirectMode = "307"
+	// RedirectPermanent redirects with 308 Permanent Redirect
+	RedirectPermanent RedirectMode = "308"
+	// RedirectInterstitial responds an HTML page which redirects by meta refresh without the referrer
+	RedirectInterstitial RedirectMode = "interstitial"
+)
+
 // CreateRequest is the request of create API
 type CreateRequest struct {
 	// LongURL is the original long URL
 	LongURL string `binding:"required,http_url" json:"long_url" form:"long_url" xml:"long_url"`
+	CreateOption
+}
+
+// CreateOption is the optional parameters of create API
+type CreateOption struct {
+	// Alias is the custom short code of the short URL, use the generated short code if empty, the alias is case-sensitive
+	Alias string `binding:"omitempty,max=64" json:"alias,omitempty" form:"alias" xml:"alias"`
+	// ExpiresAt is the expiration time of the short URL, never expire if empty
+	ExpiresAt *time.Time `json:"expires_at,omitempty" form:"expires_at" xml:"expires_at"`
+	// Domain is the target short domain of the short URL, use the default domain if empty
+	Domain string `binding:"omitempty,max=255" json:"domain,omitempty" form:"domain" xml:"domain"`
+	// RedirectMode is the redirect mode of the short URL, use the default mode of the domain if empty
+	RedirectMode RedirectMode `binding:"omitempty,oneof=301 302 307 308 interstitial" json:"redirect_mode,omitempty" form:"redirect_mode" xml:"redirect_mode"`
+	// AllowDuplicate creates a new short URL even if the long URL has been shortened, use the server default if empty.
+	// Otherwise the existing short URL is returned, and the request fails with 409 if the existing one has
+	// another expires_at or redirect_mode
+	AllowDuplicate *bool `json:"allow_duplicate,omitempty" form:"allow_duplicate" xml:"allow_duplicate"`
 }
 
 // ShortenRequest is the request of shorten API with short URL
 type ShortenRequest struct {
 	// ShortURL is the shortened URL
 	ShortURL string `binding:"required" json:"short_url" form:"short_url" xml:"short_url"`
+	// Domain is the short domain of the short URL, use the default domain if empty
+	Domain string `binding:"omitempty,max=255" json:"domain,omitempty" form:"domain" xml:"domain"`
+}
+
+// BatchShortenResponse is the response of batch shorten API
+type BatchShortenResponse struct {
+	// Results are the per-item results, in the same order as the request items
+	Results []ShortenResponse `json:"results"`
+	// Error is the error message if the whole request fails
+	Error string `json:"error,omitempty"`
+}
+
+// StatsRequest is the request of click statistics API
+type StatsRequest struct {
+	// ShortURL is the shortened URL
+	ShortURL string `binding:"required" json:"short_url" form:"short_url" xml:"short_url"`
+	// Days is the number of recent days of the daily clicks, default is 30
+	Days int `binding:"omitempty,min=1,max=366" json:"days" form:"days" xml:"days"`
+	// Domain is the short domain of the short URL, use the default domain if empty
+	Domain string `binding:"omitempty,max=255" json:"domain,omitempty" form:"domain" xml:"domain"`
+}
+
+// StatsResponse is the response of click statistics API
+type StatsResponse struct {
+	// ShortURL is the shortened URL
+	ShortURL string `json:"short_url"`
+	// Total is the total clicks of the short URL
+	Total int64 `json:"total"`
+	// Daily is the daily clicks of the short URL in recent days
+	Daily []DailyClicks `json:"daily"`
+	// Error is the error message if any error occurs
+	Error string `json:"error,omitempty"`
+}
+
+// DailyClicks is the click count of a day
+type DailyClicks struct {
+	// Date is the day of the clicks, formatted as 2006-01-02
+	Date string `json:"date"`
+	// Clicks is the click count of the day
+	Clicks int64 `json:"clicks"`
 }
 
 // ShortenResponse is the response of shorten API
@@ -26,6 +108,14 @@ type ShortenResponse struct {
 	Error string `json:"error"`
 }
 
+// ShortenInfoResponse is the response of shorten info API
+type ShortenInfoResponse struct {
+	// ShortenResponse is the first short URL of the long URL
+	ShortenResponse
+	// Links are all the short URLs of the long URL in creation order
+	Links []TinyURL `json:"links"`
+}
+
 // TinyURL is the tiny URL model, which is used to store the short URL and its original long URL
 type TinyURL struct {
 	// ShortURL is the shortened URL
@@ -34,6 +124,20 @@ type TinyURL struct {
 	LongURL string `json:"long_url"`
 	// CreatedAt is the creation time of the short URL
 	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the expiration time of the short URL, never expire if empty
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectMode is the redirect mode of the short URL, empty for the default mode of the domain
+	RedirectMode RedirectMode `json:"redirect_mode,omitempty"`
 	// DeletedAt is the deletion time of the short URL
 	DeletedAt gorm.DeletedAt `json:"deleted_at"`
 }
+
+// Redirect is the redirect target of the short URL
+type Redirect struct {
+	// LongURL is the original long URL
+	LongURL []byte
+	// Mode is the redirect mode of the short URL, empty for the default mode of the domain
+	Mode RedirectMode
+	// Short is the canonical short code of the short URL, the alias if set, otherwise the generated short code
+	Short []byte
+}
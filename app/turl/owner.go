@@ -0,0 +1,24 @@
+package turl
+
+import (
+	"context"
+
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+// caller returns the tenant of the caller and whether the caller is an admin,
+// the caller without API key is treated as the default tenant, which owns the links created before tenants exist.
+func caller(ctx context.Context) (string, bool) {
+	if k, ok := apikey.FromContext(ctx); ok {
+		return k.Tenant, k.Admin
+	}
+
+	return "", false
+}
+
+// accessible reports whether the caller can operate on the record, admins can act across tenants
+func accessible(ctx context.Context, record *storage.TinyURL) bool {
+	tenant, admin := caller(ctx)
+	return admin || record.Tenant == tenant
+}
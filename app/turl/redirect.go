@@ -0,0 +1,72 @@
+package turl
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/beihai0xff/turl/app/turl/model"
+	"github.com/beihai0xff/turl/pkg/cache"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+// interstitialPage is the HTML page of the interstitial redirect mode, which redirects by meta refresh,
+// the referrer is stripped by both the meta tag and the Referrer-Policy header.
+var interstitialPage = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<meta http-equiv="refresh" content="0; url={{.}}">
+<title>Redirecting...</title>
+</head>
+<body>
+<p>Redirecting to <a href="{{.}}" rel="noreferrer noopener">{{.}}</a></p>
+</body>
+</html>
+`))
+
+// redirectStatus returns the stored redirect mode of the record, see storage.TinyURL.RedirectMode
+func redirectStatus(mode model.RedirectMode) uint16 {
+	if mode == model.RedirectInterstitial {
+		return http.StatusOK
+	}
+
+	// the mode is validated to be one of the redirect status codes or empty
+	status, _ := strconv.ParseUint(string(mode), 10, 16)
+
+	return uint16(status)
+}
+
+// redirectMode returns the redirect mode of the stored redirect status
+func redirectMode(status uint16) model.RedirectMode {
+	switch status {
+	case 0:
+		return model.RedirectDefault
+	case http.StatusOK:
+		return model.RedirectInterstitial
+	default:
+		return model.RedirectMode(strconv.FormatUint(uint64(status), 10))
+	}
+}
+
+// cacheEntry returns the cache entry of the record
+func cacheEntry(record *storage.TinyURL) *cache.Entry {
+	e := cache.Entry{LongURL: record.LongURL, RedirectMode: record.RedirectMode, Tenant: record.Tenant, Short: shortCode(record)}
+	if record.ExpiresAt != nil {
+		e.ExpiresAt = *record.ExpiresAt
+	}
+
+	return &e
+}
+
+// renderInterstitial renders the interstitial page of the long URL
+func renderInterstitial(long []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := interstitialPage.Execute(&buf, string(long)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,32 @@
+package turl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/app/turl/model"
+	"github.com/beihai0xff/turl/pkg/cache"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+func Test_redirectMode(t *testing.T) {
+	for _, mode := range []model.RedirectMode{model.RedirectDefault, model.RedirectMovedPermanently, model.RedirectFound,
+		model.RedirectTemporary, model.RedirectPermanent, model.RedirectInterstitial} {
+		require.Equal(t, mode, redirectMode(redirectStatus(mode)))
+	}
+
+	require.Equal(t, uint16(0), redirectStatus(model.RedirectDefault))
+	require.Equal(t, uint16(301), redirectStatus(model.RedirectMovedPermanently))
+	require.Equal(t, uint16(200), redirectStatus(model.RedirectInterstitial))
+}
+
+func Test_cacheEntry(t *testing.T) {
+	long, expiresAt := []byte("https://www.example.com"), time.Now().Add(time.Hour)
+
+	e := cacheEntry(&storage.TinyURL{Short: 10000, LongURL: long, Tenant: "tenant-a", RedirectMode: 307, ExpiresAt: &expiresAt})
+	require.Equal(t, &cache.Entry{LongURL: long, RedirectMode: 307, ExpiresAt: expiresAt, Tenant: "tenant-a", Short: []byte("3yR")}, e)
+	require.Equal(t, &cache.Entry{LongURL: long, Short: []byte("spring-sale")},
+		cacheEntry(&storage.TinyURL{Short: 10000, Alias: []byte("spring-sale"), LongURL: long}))
+}
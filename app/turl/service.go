@@ -2,30 +2,59 @@
 package turl
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 
 	"github.com/beihai0xff/turl/app/turl/model"
 	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/breaker"
 	"github.com/beihai0xff/turl/pkg/cache"
-	"github.com/beihai0xff/turl/pkg/db/mysql"
+	"github.com/beihai0xff/turl/pkg/canonical"
+	"github.com/beihai0xff/turl/pkg/db"
 	"github.com/beihai0xff/turl/pkg/mapping"
 	"github.com/beihai0xff/turl/pkg/storage"
 	"github.com/beihai0xff/turl/pkg/tddl"
 	"github.com/beihai0xff/turl/pkg/validate"
 )
 
+const (
+	// maxCollisionRetries is the max retry times when the generated short ID collides with an existing one
+	maxCollisionRetries = 5
+)
+
+var (
+	// ErrLinkExpired is returned when the short URL has expired
+	ErrLinkExpired = errors.New("short URL has expired")
+	// ErrInvalidExpiry is returned when the expiration time of the short URL is not in the future
+	ErrInvalidExpiry = fmt.Errorf("%w: expiration time should be in the future", mapping.ErrInvalidInput)
+	// ErrInvalidRedirectMode is returned when the redirect mode of the short URL is not supported
+	ErrInvalidRedirectMode = fmt.Errorf("%w: redirect mode should be one of 301, 302, 307, 308 or interstitial", mapping.ErrInvalidInput)
+	// ErrOptionsConflict is returned when the long URL has been shortened with another expiration time or redirect mode
+	ErrOptionsConflict = errors.New("the long URL has been shortened with another expires_at or redirect_mode, " +
+		"set allow_duplicate to create a new short URL")
+)
+
+// expiryTolerance is the max difference of the expiration times regarded as the same,
+// since the database may store the times in lower precision
+const expiryTolerance = time.Second
+
 // Service represents the tiny URL service interface.
 type Service interface {
-	Create(ctx context.Context, long []byte) (*model.TinyURL, error)
-	GetByLong(ctx context.Context, long []byte) (*model.TinyURL, error)
-	Retrieve(ctx context.Context, short []byte) ([]byte, error)
-	Delete(ctx context.Context, short []byte) error
+	Create(ctx context.Context, long []byte, opt *model.CreateOption) (*model.TinyURL, error)
+	BatchCreate(ctx context.Context, reqs []model.CreateRequest) ([]model.ShortenResponse, error)
+	ListByLong(ctx context.Context, domain string, long []byte) ([]*model.TinyURL, error)
+	Retrieve(ctx context.Context, domain string, short []byte) (*model.Redirect, error)
+	Delete(ctx context.Context, domain string, short []byte) error
+	Authorize(ctx context.Context, domain string, short []byte) ([]byte, error)
 	Close() error
 }
 
@@ -34,10 +63,17 @@ var _ Service = (*service)(nil)
 type service struct {
 	*commandService
 	*queryService
+	filter *codeFilter
+	// breaker is the circuit breaker of redis shared by the caches
+	breaker *breaker.Breaker
+	// db is the database connection pool, shared with the API key store
+	db *gorm.DB
+	// bus is the cache invalidation bus shared by the caches, nil if disabled
+	bus *cache.Bus
 }
 
 func getDB(c *configs.ServerConfig) (*gorm.DB, error) {
-	db, err := mysql.New(c.MySQL)
+	db, err := db.New(c.DB)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +84,7 @@ func getDB(c *configs.ServerConfig) (*gorm.DB, error) {
 	} else if c.Debug {
 		go func() {
 			for range time.NewTicker(time.Second).C {
-				slog.Info(fmt.Sprintf("mysql db stats %+v", sqlDB.Stats()))
+				slog.Info(fmt.Sprintf("database stats %+v", sqlDB.Stats()))
 			}
 		}()
 	}
@@ -63,22 +99,55 @@ func newService(c *configs.ServerConfig) (*service, error) {
 		return nil, err
 	}
 
-	cacheProxy, err := cache.NewProxy(c.Cache)
+	b := newRedisBreaker(c.Cache.Redis)
+
+	// the caches of the instance share one subscription of the invalidations
+	bus, err := cache.NewInvalidationBus(c.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheProxy, err := cache.NewProxy(c.Cache, b, bus)
 	if err != nil {
 		return nil, err
 	}
 
+	filter, err := newCodeFilter(storage.New(db), c.Cache.Bloom)
+	if err != nil {
+		return nil, err
+	}
+
+	canon := canonical.New(c.Canonical)
+
+	q := &queryService{
+		ttl:       entryTTL(c.Cache),
+		db:        storage.New(db),
+		cache:     cacheProxy,
+		filter:    filter,
+		canonical: canon,
+	}
+
+	if c.Debug {
+		go func() {
+			for range time.NewTicker(time.Second).C {
+				slog.Info(fmt.Sprintf("retrieve stats %+v", q.Stats()))
+
+				if s, ok := cacheProxy.(cache.StatsGetter); ok {
+					slog.Info(fmt.Sprintf("local cache stats %+v", s.Stats()))
+				}
+			}
+		}()
+	}
+
 	if c.Readonly {
-		return &service{
-			queryService: &queryService{
-				ttl:   c.Cache.Redis.TTL,
-				db:    storage.New(db),
-				cache: cacheProxy,
-			},
-		}, nil
+		return &service{queryService: q, filter: filter, breaker: b, db: db, bus: bus}, nil
 	}
 
-	if db.AutoMigrate(tddl.Sequence{}, storage.TinyURL{}) != nil {
+	if err = db.AutoMigrate(tddl.Sequence{}); err != nil {
+		return nil, err
+	}
+
+	if err = storage.Migrate(db); err != nil {
 		return nil, err
 	}
 
@@ -87,28 +156,77 @@ func newService(c *configs.ServerConfig) (*service, error) {
 		return nil, err
 	}
 
-	writeCacheProxy, err := cache.NewProxy(c.Cache)
+	seqs := tddl.NewManager(db, sequenceConfigs(c))
+
+	if c.Debug {
+		go func() {
+			for range time.NewTicker(time.Second).C {
+				slog.Info(fmt.Sprintf("tddl stats %+v, named sequences stats %+v", t.Stats(), seqs.Stats()))
+			}
+		}()
+	}
+
+	gen, err := mapping.NewGenerator(c.Generator)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCacheProxy, err := cache.NewProxy(c.Cache, b, bus)
 	if err != nil {
 		return nil, err
 	}
 
 	return &service{
 		commandService: &commandService{
-			ttl:   c.Cache.Redis.TTL,
-			db:    storage.New(db),
-			cache: writeCacheProxy,
-			seq:   t,
-		},
-		queryService: &queryService{
-			ttl:   c.Cache.Redis.TTL,
-			db:    storage.New(db),
-			cache: cacheProxy,
+			ttl:             entryTTL(c.Cache),
+			db:              storage.New(db),
+			cache:           writeCacheProxy,
+			seq:             t,
+			seqs:            seqs,
+			gen:             gen,
+			reserved:        newReservedAliases(c.ReservedAliases),
+			codeRanges:      codeRanges(c, gen),
+			allowDuplicates: c.AllowDuplicates,
+			filter:          filter,
+			canonical:       canon,
 		},
+		queryService: q,
+		filter:       filter,
+		breaker:      b,
+		db:           db,
+		bus:          bus,
 	}, nil
 }
 
+// newRedisBreaker creates the circuit breaker of redis, the redirects fall back to the local cache and db,
+// and the rate limiters fall back to the in-process limiters while the circuit is open.
+func newRedisBreaker(c *configs.RedisConfig) *breaker.Breaker {
+	if c == nil { // no redis in the embedded mode
+		return nil
+	}
+
+	if c.Breaker == nil {
+		return breaker.New("redis", 0, 0)
+	}
+
+	return breaker.New("redis", c.Breaker.FailureThreshold, c.Breaker.OpenTimeout)
+}
+
+// entryTTL returns the ttl of the cache entries, the redis ttl, or the local cache ttl without redis
+func entryTTL(c *configs.CacheConfig) time.Duration {
+	if c.Redis == nil {
+		return c.LocalCache.TTL
+	}
+
+	return c.Redis.TTL
+}
+
 // Close closes the command service.
 func (s *service) Close() error {
+	if s.filter != nil {
+		s.filter.Close()
+	}
+
 	if s.commandService != nil {
 		if err := s.commandService.Close(); err != nil {
 			return err
@@ -116,7 +234,13 @@ func (s *service) Close() error {
 	}
 
 	if s.queryService != nil {
-		return s.queryService.Close()
+		if err := s.queryService.Close(); err != nil {
+			return err
+		}
+	}
+
+	if s.bus != nil {
+		return s.bus.Close()
 	}
 
 	return nil
@@ -128,68 +252,451 @@ type commandService struct {
 	db    storage.Storage
 	cache cache.Interface
 	seq   tddl.TDDL
+	// seqs is the named sequences of the tenants, nil if the tenants share the default sequence
+	seqs *tddl.Manager
+	// gen generates the short IDs from the sequence numbers
+	gen mapping.Generator
+	// reserved is the set of reserved words which can not be used as custom alias
+	reserved map[string]struct{}
+	// codeRanges are the ranges of the short IDs generated in the short domains, which can not be used as custom alias
+	codeRanges map[string]codeRange
+	// allowDuplicates is the default of creating a new short code for the long URL which has been shortened
+	allowDuplicates bool
+	// filter is the bloom filter of the short codes shared with the query service, nil if disabled
+	filter *codeFilter
+	// canonical canonicalizes the long URLs before deduplication, nil if disabled
+	canonical *canonical.Canonicalizer
 }
 
 // Create creates a new tiny URL.
-func (c *commandService) Create(ctx context.Context, long []byte) (*model.TinyURL, error) {
-	if err := validate.Instance().VarCtx(ctx, string(long), "required,http_url"); err != nil {
+func (c *commandService) Create(ctx context.Context, long []byte, opt *model.CreateOption) (*model.TinyURL, error) {
+	if opt == nil {
+		opt = &model.CreateOption{}
+	}
+
+	if err := c.validate(ctx, long, opt); err != nil {
 		return nil, err
 	}
 
-	seq, err := c.seq.Next(ctx)
+	long, err := canonicalize(c.canonical, long)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := c.sequence(ctx, opt.Domain).Next(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate sequence: %w", err)
 	}
 
-	record, err := c.db.Insert(ctx, seq, long)
+	record, err := c.insert(ctx, seq, long, opt)
 	if err != nil {
-		if errors.Is(err, gorm.ErrDuplicatedKey) {
-			slog.Error(fmt.Sprintf("failed to insert into db: %v, try to get from db", err),
-				slog.Any("long url", long), slog.Int64("seq", int64(seq)))
+		return nil, err
+	}
 
-			record, err = c.db.GetByLongURL(ctx, long)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get from db: %w", err)
+	return c.setCache(ctx, record), nil
+}
+
+// BatchCreate creates tiny URLs in bulk, the sequence numbers are reserved in bulk
+// and the records are inserted in a single statement. The results are in the same order as the requests,
+// the failed items have the error message set, and the other items are still created.
+func (c *commandService) BatchCreate(ctx context.Context, reqs []model.CreateRequest) ([]model.ShortenResponse, error) {
+	results := make([]model.ShortenResponse, len(reqs))
+	longs := make([][]byte, len(reqs))   // the canonical long URLs of the requests
+	pending := make([]int, 0, len(reqs)) // the indexes of the valid requests
+
+	for i := range reqs {
+		results[i].LongURL = reqs[i].LongURL
+
+		err := c.validate(ctx, []byte(reqs[i].LongURL), &reqs[i].CreateOption)
+		if err == nil {
+			longs[i], err = canonicalize(c.canonical, []byte(reqs[i].LongURL))
+		}
+
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		pending = append(pending, i)
+	}
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	// each domain reserves the sequence numbers from its own sequence
+	counts := make(map[string]int)
+	for _, i := range pending {
+		counts[reqs[i].Domain]++
+	}
+
+	var err error
+
+	seqs := make(map[string][]uint64, len(counts))
+	for domain, n := range counts {
+		if seqs[domain], err = c.sequence(ctx, domain).NextN(ctx, n); err != nil {
+			return nil, fmt.Errorf("failed to generate sequence: %w", err)
+		}
+	}
+
+	shorts := make([]uint64, len(pending))
+	records := make([]*storage.TinyURL, 0, len(pending))
+
+	for j, i := range pending {
+		domain := reqs[i].Domain
+		if shorts[j], err = c.gen.Generate(seqs[domain][0]); err != nil {
+			return nil, fmt.Errorf("failed to generate short ID: %w", err)
+		}
+
+		seqs[domain] = seqs[domain][1:]
+
+		record := &storage.TinyURL{Short: shorts[j], LongURL: longs[i]}
+		for _, o := range c.insertOptions(ctx, &reqs[i].CreateOption) {
+			o(record)
+		}
+
+		records = append(records, record)
+	}
+
+	inserted, err := c.db.BatchInsert(ctx, records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert into db: %w", err)
+	}
+
+	// the short IDs are unique in each domain
+	type key struct {
+		domain string
+		short  uint64
+	}
+
+	created := make(map[key]*storage.TinyURL, len(inserted))
+	for _, record := range inserted {
+		created[key{record.Domain, record.Short}] = record
+	}
+
+	for j, i := range pending {
+		k := key{reqs[i].Domain, shorts[j]}
+
+		// the random short IDs may also collide in the same batch, each inserted record is claimed once
+		record, ok := created[k]
+		delete(created, k)
+
+		if !ok { // conflict with an existing record, fall back to it as Create does, or retry with a new short ID
+			if record, err = c.batchConflict(ctx, longs[i], &reqs[i].CreateOption); err != nil {
+				results[i].Error = err.Error()
+				continue
 			}
+		}
 
-			seq = record.Short
-		} else {
-			return nil, fmt.Errorf("failed to insert into db: %w", err)
+		results[i].TinyURL = *c.setCache(ctx, record)
+	}
+
+	return results, nil
+}
+
+// batchConflict returns the existing record which conflicts with the item of BatchCreate,
+// if only the short ID collides, the item is created again with a new sequence number.
+func (c *commandService) batchConflict(ctx context.Context, long []byte, opt *model.CreateOption) (*storage.TinyURL, error) {
+	record, err := c.getDuplicated(ctx, long, opt)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return record, err
+	}
+
+	seq, err := c.sequence(ctx, opt.Domain).Next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sequence: %w", err)
+	}
+
+	return c.insert(ctx, seq, long, opt)
+}
+
+// sequence returns the sequence of the short domain, each short domain other than the default one
+// has its own sequence named after the domain. In the default domain, the tenants with a named sequence configured
+// use their own sequences, and the other tenants share the default sequence.
+func (c *commandService) sequence(ctx context.Context, domain string) tddl.TDDL {
+	if c.seqs == nil {
+		return c.seq
+	}
+
+	if domain != "" {
+		return c.seqs.Sequence(domain)
+	}
+
+	// the config keys are case-insensitive
+	if tenant, _ := caller(ctx); tenant != "" && c.seqs.Configured(strings.ToLower(tenant)) {
+		return c.seqs.Sequence(strings.ToLower(tenant))
+	}
+
+	return c.seq
+}
+
+// validate validates the long URL and the optional parameters of creating a tiny URL.
+func (c *commandService) validate(ctx context.Context, long []byte, opt *model.CreateOption) error {
+	if err := validate.Instance().VarCtx(ctx, string(long), "required,http_url"); err != nil {
+		return err
+	}
+
+	if opt.Alias != "" {
+		if err := validateAlias([]byte(opt.Alias), c.reserved, c.codeRange(opt.Domain)); err != nil {
+			return err
 		}
 	}
 
-	short := mapping.Base58Encode(seq)
-	// set local cache and distributed cache, if failed, just log the error, not return err
-	if err = c.cache.Set(ctx, string(short), long, c.ttl); err != nil {
-		slog.ErrorContext(ctx, "failed to set cache", slog.Any("error", err))
+	if opt.ExpiresAt != nil && !opt.ExpiresAt.After(time.Now()) {
+		return ErrInvalidExpiry
 	}
 
+	switch opt.RedirectMode {
+	case model.RedirectDefault, model.RedirectMovedPermanently, model.RedirectFound,
+		model.RedirectTemporary, model.RedirectPermanent, model.RedirectInterstitial:
+	default:
+		return ErrInvalidRedirectMode
+	}
+
+	return nil
+}
+
+// codeRange returns the range of the short IDs generated in the short domain, the full range if unknown
+func (c *commandService) codeRange(domain string) codeRange {
+	if r, ok := c.codeRanges[domain]; ok {
+		return r
+	}
+
+	return fullCodeRange
+}
+
+// setCache sets the record into local cache and distributed cache, which overwrites the negative cache entries,
+// and returns the tiny URL of the record, if failed to set cache, just log the error, not return err.
+func (c *commandService) setCache(ctx context.Context, record *storage.TinyURL) *model.TinyURL {
+	if c.filter != nil {
+		c.filter.add(record)
+	}
+
+	short := shortCode(record)
+	if ttl := cacheTTL(c.ttl, record); ttl > 0 {
+		if err := cache.SetEntry(ctx, c.cache, domainKey(record.Domain, short), cacheEntry(record), ttl); err != nil {
+			slog.ErrorContext(ctx, "failed to set cache", slog.Any("error", err))
+		}
+	}
+
+	return toModel(short, record)
+}
+
+// toModel converts the record to the tiny URL model with the short code
+func toModel(short []byte, record *storage.TinyURL) *model.TinyURL {
 	return &model.TinyURL{
-		ShortURL:  string(short),
-		LongURL:   string(long),
-		CreatedAt: record.CreatedAt,
-		DeletedAt: record.DeletedAt,
-	}, nil
+		ShortURL:     string(short),
+		LongURL:      string(record.LongURL),
+		CreatedAt:    record.CreatedAt,
+		ExpiresAt:    record.ExpiresAt,
+		RedirectMode: redirectMode(record.RedirectMode),
+		DeletedAt:    record.DeletedAt,
+	}
 }
 
-func (c *commandService) Delete(ctx context.Context, short []byte) error {
-	// decode and validate short URI
-	seq, err := mapping.Base58Decode(short)
+// allowDuplicate reports whether a new short code is created for the long URL which has been shortened
+func (c *commandService) allowDuplicate(opt *model.CreateOption) bool {
+	if opt.AllowDuplicate != nil {
+		return *opt.AllowDuplicate
+	}
+
+	return c.allowDuplicates
+}
+
+// insertOptions converts the optional parameters to the storage insert options,
+// the record is owned by the tenant of the caller.
+func (c *commandService) insertOptions(ctx context.Context, opt *model.CreateOption) []storage.InsertOption {
+	var opts []storage.InsertOption
+
+	if tenant, _ := caller(ctx); tenant != "" {
+		opts = append(opts, storage.WithTenant(tenant))
+	}
+
+	if opt.Domain != "" {
+		opts = append(opts, storage.WithDomain(opt.Domain))
+	}
+
+	if opt.Alias != "" {
+		opts = append(opts, storage.WithAlias([]byte(opt.Alias)))
+	}
+
+	if opt.ExpiresAt != nil {
+		opts = append(opts, storage.WithExpiresAt(*opt.ExpiresAt))
+	}
+
+	if opt.RedirectMode != model.RedirectDefault {
+		opts = append(opts, storage.WithRedirectMode(redirectStatus(opt.RedirectMode)))
+	}
+
+	if c.allowDuplicate(opt) {
+		opts = append(opts, storage.WithDuplicate())
+	}
+
+	return opts
+}
+
+// insert inserts a new record, if the long URL already exists and the duplicates are not allowed,
+// the existing record is returned.
+// If neither the long URL nor the alias conflicts, the generated short ID collides with an existing record,
+// which may be a deleted one, then it retries with a new sequence number.
+// It returns ErrAliasConflict if the alias is taken by a deleted record.
+func (c *commandService) insert(ctx context.Context, seq uint64, long []byte, opt *model.CreateOption) (*storage.TinyURL, error) {
+	for retries := 0; ; retries++ {
+		short, err := c.gen.Generate(seq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate short ID: %w", err)
+		}
+
+		record, err := c.db.Insert(ctx, short, long, c.insertOptions(ctx, opt)...)
+		if err == nil {
+			return record, nil
+		}
+
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, fmt.Errorf("failed to insert into db: %w", err)
+		}
+
+		slog.Error(fmt.Sprintf("failed to insert into db: %v, try to get from db", err),
+			slog.Any("long url", long), slog.Int64("seq", int64(seq)), slog.Int64("short", int64(short)))
+
+		record, err = c.getDuplicated(ctx, long, opt)
+		if !errors.Is(err, gorm.ErrRecordNotFound) || retries >= maxCollisionRetries {
+			return record, err
+		}
+
+		if opt.Alias != "" {
+			// the alias is kept by the deleted record, unless the short ID collides with an existing record
+			if _, err = c.db.GetByShortID(ctx, opt.Domain, short); errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrAliasConflict
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to get from db: %w", err)
+			}
+		}
+
+		slog.WarnContext(ctx, "short ID collides with an existing record, retry with a new sequence",
+			slog.Int64("short", int64(short)), slog.Int("retries", retries))
+
+		if seq, err = c.sequence(ctx, opt.Domain).Next(ctx); err != nil {
+			return nil, fmt.Errorf("failed to generate sequence: %w", err)
+		}
+	}
+}
+
+// getDuplicated returns the existing record of the caller's tenant which conflicts with the new one,
+// the existing record is renewed if it has expired,
+// and the custom alias is attached to the existing record if it has no alias yet.
+// It returns ErrOptionsConflict if the existing record has another expiration time or redirect mode.
+// If the duplicates are allowed, only the record of the same alias conflicts with the new one.
+func (c *commandService) getDuplicated(ctx context.Context, long []byte, opt *model.CreateOption) (*storage.TinyURL, error) {
+	alias := []byte(opt.Alias)
+	tenant, _ := caller(ctx)
+
+	if len(alias) > 0 { // the alias may be taken by another record
+		if taken, gerr := c.db.GetByAlias(ctx, opt.Domain, alias); gerr == nil {
+			if !bytes.Equal(taken.LongURL, long) || taken.Tenant != tenant {
+				return nil, ErrAliasConflict
+			}
+
+			if !sameOptions(taken, opt) {
+				return nil, ErrOptionsConflict
+			}
+
+			return taken, nil
+		}
+	}
+
+	if c.allowDuplicate(opt) { // the long URL never conflicts
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	record, err := c.db.GetByLongURL(ctx, long, storage.InDomain(opt.Domain), storage.OwnedBy(tenant))
 	if err != nil {
+		return nil, fmt.Errorf("failed to get from db: %w", err)
+	}
+
+	if record.Expired() {
+		if err = c.db.SetExpiresAt(ctx, record.Domain, record.Short, opt.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to renew the expired record: %w", err)
+		}
+
+		record.ExpiresAt = opt.ExpiresAt
+	}
+
+	if !sameOptions(record, opt) { // never return a link which behaves differently from the requested one
+		return nil, ErrOptionsConflict
+	}
+
+	if len(alias) == 0 || bytes.Equal(record.Alias, alias) {
+		return record, nil
+	}
+
+	if len(record.Alias) > 0 {
+		return nil, fmt.Errorf("%w: the long URL already has alias %q", ErrAliasConflict, record.Alias)
+	}
+
+	if err = c.db.SetAlias(ctx, record.Domain, record.Short, alias); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAliasConflict
+		}
+
+		return nil, fmt.Errorf("failed to set alias: %w", err)
+	}
+
+	record.Alias = alias
+
+	return record, nil
+}
+
+// sameOptions reports whether the record has the expiration time and redirect mode of the create options
+func sameOptions(record *storage.TinyURL, opt *model.CreateOption) bool {
+	if record.RedirectMode != redirectStatus(opt.RedirectMode) {
+		return false
+	}
+
+	if record.ExpiresAt == nil || opt.ExpiresAt == nil {
+		return record.ExpiresAt == nil && opt.ExpiresAt == nil
+	}
+
+	return record.ExpiresAt.Sub(*opt.ExpiresAt).Abs() < expiryTolerance
+}
+
+// Delete deletes the tiny URL of the short domain by the generated short code or the custom alias,
+// only the links owned by the caller's tenant can be deleted unless the caller is an admin.
+func (c *commandService) Delete(ctx context.Context, domain string, short []byte) error {
+	if err := validateCode(short); err != nil {
 		return err
 	}
 
-	if err = c.db.Delete(ctx, seq); err != nil {
+	record, err := getRecord(ctx, c.db, domain, short)
+	if err != nil {
 		return err
 	}
 
-	return c.cache.Del(ctx, string(short))
+	if !accessible(ctx, record) { // not leak the existence of the links owned by other tenants
+		return gorm.ErrRecordNotFound
+	}
+
+	if err = c.db.Delete(ctx, domain, record.Short); err != nil {
+		return err
+	}
+
+	if len(record.Alias) > 0 {
+		if err = c.cache.Del(ctx, domainKey(domain, record.Alias)); err != nil {
+			return err
+		}
+	}
+
+	return c.cache.Del(ctx, domainKey(domain, mapping.Base58Encode(record.Short)))
 }
 
 // Close closes the command service.
 func (c *commandService) Close() error {
 	c.seq.Close()
 
+	if c.seqs != nil {
+		c.seqs.Close()
+	}
+
 	if err := c.db.Close(); err != nil {
 		return err
 	}
@@ -202,63 +709,192 @@ type queryService struct {
 	ttl   time.Duration
 	db    storage.Storage
 	cache cache.Interface
+	// filter rejects the short codes not exist without db lookup, nil if the bloom filter is disabled
+	filter *codeFilter
+	// canonical canonicalizes the long URLs to look up, nil if disabled
+	canonical *canonical.Canonicalizer
+
+	// group coalesces the concurrent loads of the same short code on cache miss
+	group singleflight.Group
+	// loads is the number of the loads from db, coalesced is the number of the calls waiting for another load
+	loads, coalesced atomic.Uint64
 }
 
-// Retrieve a tiny URL.
-func (q *queryService) Retrieve(ctx context.Context, short []byte) ([]byte, error) {
-	// decode and validate short URI
-	seq, err := mapping.Base58Decode(short)
-	if err != nil {
+// RetrieveStats is the statistics of retrieving the short codes missed in cache
+type RetrieveStats struct {
+	// Loads is the number of the loads from db
+	Loads uint64
+	// Coalesced is the number of the calls which share the result of another load instead of loading from db
+	Coalesced uint64
+}
+
+// Retrieve the redirect target of a tiny URL of the short domain,
+// the metadata of the link is cached alongside the long URL, so the cache hits need no db lookup.
+func (q *queryService) Retrieve(ctx context.Context, domain string, short []byte) (*model.Redirect, error) {
+	// validate short code, both the generated short code and the custom alias are accepted
+	if err := validateCode(short); err != nil {
 		return nil, err
 	}
 
-	// try to get from cache
-	long, err := q.cache.Get(ctx, string(short))
-	if err == nil {
-		return long, nil
+	// reject the short codes not exist before any I/O
+	if q.filter != nil && !q.filter.mayExist(domain, short) {
+		return nil, gorm.ErrRecordNotFound
 	}
 
-	if !errors.Is(err, cache.ErrCacheMiss) {
+	// try to get from cache, the entries which can not be decoded or are cached without the canonical short code
+	// are reloaded from db
+	e, err := cache.GetEntry(ctx, q.cache, domainKey(domain, short))
+	switch {
+	case err == nil && e.Expired():
+		return nil, ErrLinkExpired
+	case err == nil && len(e.Short) > 0:
+		return &model.Redirect{LongURL: e.LongURL, Mode: redirectMode(e.RedirectMode), Short: e.Short}, nil
+	case err == nil:
+		// cached by the previous versions
+	case errors.Is(err, cache.ErrNotFound):
+		return nil, gorm.ErrRecordNotFound
+	case errors.Is(err, cache.ErrInvalidEntry), errors.Is(err, cache.ErrUnsupportedEntry):
+		slog.WarnContext(ctx, "failed to decode cache entry", slog.String("short", string(short)), slog.Any("error", err))
+	case !errors.Is(err, cache.ErrCacheMiss):
 		return nil, err
 	}
 
-	defer func() {
-		if len(long) > 0 {
-			// set local cache and distributed cache, if failed, just log the error, not return err
-			if cerr := q.cache.Set(ctx, string(short), long, q.ttl); cerr != nil {
-				slog.ErrorContext(ctx, "failed to set cache", slog.Any("error", err))
-			}
+	// only one caller loads the short code from db, the others wait for its result
+	var leader bool
+
+	v, err, shared := q.group.Do(domainKey(domain, short), func() (any, error) {
+		leader = true
+		// the load is shared with the other callers, so it is not canceled with the leader's context
+		return q.load(context.WithoutCancel(ctx), domain, short)
+	})
+
+	if shared && !leader {
+		q.coalesced.Add(1)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := *v.(*model.Redirect)
+
+	return &r, nil
+}
+
+// load loads the redirect target of the short code from db, and sets it into cache
+func (q *queryService) load(ctx context.Context, domain string, short []byte) (*model.Redirect, error) {
+	q.loads.Add(1)
+
+	res, err := getRecord(ctx, q.db, domain, short)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			q.setNotFound(ctx, domainKey(domain, short))
+		}
+
+		return nil, err
+	}
+
+	if res.Expired() {
+		return nil, ErrLinkExpired
+	}
+
+	// set local cache and distributed cache, if failed, just log the error, not return err
+	if ttl := cacheTTL(q.ttl, res); ttl > 0 {
+		if err = cache.SetEntry(ctx, q.cache, domainKey(domain, short), cacheEntry(res), ttl); err != nil {
+			slog.ErrorContext(ctx, "failed to set cache", slog.Any("error", err))
 		}
-	}()
+	}
+
+	return &model.Redirect{LongURL: res.LongURL, Mode: redirectMode(res.RedirectMode), Short: shortCode(res)}, nil
+}
+
+// Stats returns the statistics of retrieving the short codes missed in cache
+func (q *queryService) Stats() RetrieveStats {
+	return RetrieveStats{Loads: q.loads.Load(), Coalesced: q.coalesced.Load()}
+}
+
+// setNotFound caches the short code as not found if the cache supports negative caching,
+// if failed, just log the error.
+func (q *queryService) setNotFound(ctx context.Context, key string) {
+	c, ok := q.cache.(cache.NegativeCache)
+	if !ok {
+		return
+	}
+
+	if err := c.SetNotFound(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "failed to set negative cache", slog.Any("error", err))
+	}
+}
+
+// Authorize checks whether the caller can manage the tiny URL of the short code, and returns its canonical
+// short code, returns gorm.ErrRecordNotFound if the tiny URL does not exist or is owned by another tenant.
+func (q *queryService) Authorize(ctx context.Context, domain string, short []byte) ([]byte, error) {
+	if err := validateCode(short); err != nil {
+		return nil, err
+	}
 
-	// try to get from db
-	res, err := q.db.GetByShortID(ctx, seq)
+	record, err := getRecord(ctx, q.db, domain, short)
 	if err != nil {
 		return nil, err
 	}
 
-	long = res.LongURL
+	if !accessible(ctx, record) {
+		return nil, gorm.ErrRecordNotFound
+	}
 
-	return long, nil
+	return shortCode(record), nil
 }
 
-// GetByLong returns the tiny URL by the long URL.
-func (q *queryService) GetByLong(ctx context.Context, long []byte) (*model.TinyURL, error) {
+// ListByLong returns all the tiny URLs of the short domain owned by the caller's tenant by the long URL
+// in creation order, admins can list any tenant's. It returns gorm.ErrRecordNotFound if there is none.
+func (q *queryService) ListByLong(ctx context.Context, domain string, long []byte) ([]*model.TinyURL, error) {
 	if err := validate.Instance().VarCtx(ctx, string(long), "required,http_url"); err != nil {
 		return nil, err
 	}
 
-	record, err := q.db.GetByLongURL(ctx, long)
+	long, err := canonicalize(q.canonical, long)
 	if err != nil {
 		return nil, err
 	}
 
-	return &model.TinyURL{
-		ShortURL:  string(mapping.Base58Encode(record.Short)),
-		LongURL:   string(record.LongURL),
-		CreatedAt: record.CreatedAt,
-		DeletedAt: record.DeletedAt,
-	}, nil
+	opts := []storage.QueryOption{storage.InDomain(domain)}
+	if tenant, admin := caller(ctx); !admin {
+		opts = append(opts, storage.OwnedBy(tenant))
+	}
+
+	records, err := q.db.ListByLongURL(ctx, long, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	urls := make([]*model.TinyURL, 0, len(records))
+	for _, record := range records {
+		urls = append(urls, toModel(shortCode(record), record))
+	}
+
+	return urls, nil
+}
+
+// canonicalize returns the canonical form of the long URL, or the long URL itself if the canonicalization is disabled
+func canonicalize(c *canonical.Canonicalizer, long []byte) ([]byte, error) {
+	if c == nil {
+		return long, nil
+	}
+
+	return c.Canonicalize(long)
+}
+
+// cacheTTL returns the cache ttl of the record, the cache entry never outlives the record.
+func cacheTTL(ttl time.Duration, record *storage.TinyURL) time.Duration {
+	if record.ExpiresAt != nil {
+		return min(ttl, time.Until(*record.ExpiresAt))
+	}
+
+	return ttl
 }
 
 // Close closes the command service.
@@ -1,20 +1,27 @@
 package turl
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 
+	"github.com/beihai0xff/turl/app/turl/model"
 	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
 	"github.com/beihai0xff/turl/internal/tests/mocks"
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/bloom"
 	"github.com/beihai0xff/turl/pkg/cache"
+	"github.com/beihai0xff/turl/pkg/canonical"
 	"github.com/beihai0xff/turl/pkg/mapping"
 	"github.com/beihai0xff/turl/pkg/storage"
 	"github.com/beihai0xff/turl/pkg/tddl"
@@ -35,8 +42,15 @@ func Test_getDB(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("GetDBUnknownDriver", func(t *testing.T) {
+		c := *c.DB
+		c.Driver = "sqlserver"
+		_, err := getDB(&configs.ServerConfig{DB: &c})
+		require.ErrorContains(t, err, `unknown database driver "sqlserver"`)
+	})
+
 	t.Run("GetDBFailed", func(t *testing.T) {
-		c.MySQL.DSN = "invalid_dsn"
+		c.DB.DSN = "invalid_dsn"
 		_, err := getDB(&c)
 		require.Error(t, err)
 	})
@@ -47,27 +61,40 @@ func TestService_Create(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("CreateNewURL", func(t *testing.T) {
-		short, err := turl.Create(context.Background(), []byte("https://www.example.com"))
+		short, err := turl.Create(context.Background(), []byte("https://www.example.com"), nil)
 		require.NoError(t, err)
 		require.NotNil(t, short)
 	})
 
 	t.Run("CreateInvalidURL", func(t *testing.T) {
-		short, err := turl.Create(context.Background(), []byte("invalid_url"))
+		short, err := turl.Create(context.Background(), []byte("invalid_url"), nil)
 		require.Error(t, err)
 		require.Nil(t, short)
 	})
 
 	t.Run("CreateExistingURL", func(t *testing.T) {
-		short, err := turl.Create(context.Background(), []byte("https://www.CreateExistingURL.com"))
+		short, err := turl.Create(context.Background(), []byte("https://www.CreateExistingURL.com"), nil)
 		require.NoError(t, err)
 		require.NotNil(t, short)
 
-		short2, err := turl.Create(context.Background(), []byte("https://www.CreateExistingURL.com"))
+		short2, err := turl.Create(context.Background(), []byte("https://www.CreateExistingURL.com"), nil)
 		require.NoError(t, err)
 		require.NotNil(t, short2)
 		require.Equal(t, short, short2)
 	})
+
+	t.Run("CreateWithAlias", func(t *testing.T) {
+		short, err := turl.Create(context.Background(), []byte("https://www.CreateWithAlias.com"), &model.CreateOption{Alias: "create-with-alias"})
+		require.NoError(t, err)
+		require.Equal(t, "create-with-alias", short.ShortURL)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("create-with-alias"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("https://www.CreateWithAlias.com"), got.LongURL)
+
+		_, err = turl.Create(context.Background(), []byte("https://www.CreateWithAlias2.com"), &model.CreateOption{Alias: "create-with-alias"})
+		require.ErrorIs(t, err, ErrAliasConflict)
+	})
 }
 
 func TestService_Retrieve(t *testing.T) {
@@ -78,15 +105,15 @@ func TestService_Retrieve(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("RetrieveExistingURL", func(t *testing.T) {
-		record, err := turl.Create(context.Background(), []byte("https://www.example.com"))
+		record, err := turl.Create(context.Background(), []byte("https://www.example.com"), nil)
 		require.NoError(t, err)
-		got, err := turl.Retrieve(context.Background(), []byte(record.ShortURL))
+		got, err := turl.Retrieve(context.Background(), "", []byte(record.ShortURL))
 		require.NoError(t, err)
-		require.Equal(t, []byte("https://www.example.com"), got)
+		require.Equal(t, []byte("https://www.example.com"), got.LongURL)
 	})
 
 	t.Run("RetrieveNonExistingURL", func(t *testing.T) {
-		got, err := turl.Retrieve(context.Background(), []byte("zzzzzz"))
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
 		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
 		require.Nil(t, got)
 	})
@@ -110,20 +137,21 @@ func TestService_Create_failed(t *testing.T) {
 		db:    mockStorage,
 		cache: mockCache,
 		seq:   mockTDDL,
+		gen:   mapping.Sequential{},
 	}
 
 	testErr := errors.New("test error")
 
 	t.Run("CreateFailedToGenerateSequence", func(t *testing.T) {
 		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(0), testErr).Times(1)
-		_, err := turl.Create(context.Background(), []byte("https://www.example.com"))
+		_, err := turl.Create(context.Background(), []byte("https://www.example.com"), nil)
 		require.ErrorIs(t, err, testErr)
 	})
 
 	t.Run("CreateFailedToInsertIntoDB", func(t *testing.T) {
 		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
 		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), []byte("https://www.example.com")).Return(nil, testErr).Times(1)
-		_, err := turl.Create(context.Background(), []byte("https://www.example.com"))
+		_, err := turl.Create(context.Background(), []byte("https://www.example.com"), nil)
 		require.ErrorIs(t, err, testErr)
 	})
 
@@ -138,11 +166,641 @@ func TestService_Create_failed(t *testing.T) {
 			},
 		}, nil)
 		mockCache.EXPECT().Set(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(testErr).Times(1)
-		_, err := turl.Create(context.Background(), []byte("https://www.example.com"))
+		_, err := turl.Create(context.Background(), []byte("https://www.example.com"), nil)
+		require.NoError(t, err)
+	})
+}
+
+func TestService_Create_alias(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &commandService{
+		ttl:      time.Second,
+		db:       mockStorage,
+		cache:    mockCache,
+		seq:      mockTDDL,
+		gen:      mapping.Sequential{},
+		reserved: newReservedAliases([]string{"login"}),
+	}
+
+	long, alias := []byte("https://www.example.com"), []byte("spring-sale")
+
+	t.Run("CreateInvalidAlias", func(t *testing.T) {
+		for _, v := range []string{"abcdef", "spring sale", "Login", "swagger"} {
+			_, err := turl.Create(context.Background(), long, &model.CreateOption{Alias: v})
+			require.ErrorIs(t, err, ErrInvalidAlias, v)
+		}
+	})
+
+	t.Run("CreateWithAlias", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, Alias: alias}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, string(alias), encodeEntry(string(alias), long, 0, nil), mock.Anything).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, &model.CreateOption{Alias: string(alias)})
+		require.NoError(t, err)
+		require.Equal(t, string(alias), got.ShortURL)
+	})
+
+	t.Run("CreateAliasTaken", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(2), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(2), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", alias).
+			Return(&storage.TinyURL{Short: 1, LongURL: []byte("https://www.another.com"), Alias: alias}, nil).Times(1)
+
+		_, err := turl.Create(context.Background(), long, &model.CreateOption{Alias: string(alias)})
+		require.ErrorIs(t, err, ErrAliasConflict)
+	})
+
+	t.Run("CreateAliasTakenByAnotherTenant", func(t *testing.T) {
+		ctx := apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "tenant-b"})
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(5), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(5), long, mock.Anything, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", alias).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, Alias: alias, Tenant: "tenant-a"}, nil).Times(1)
+
+		_, err := turl.Create(ctx, long, &model.CreateOption{Alias: string(alias)})
+		require.ErrorIs(t, err, ErrAliasConflict)
+	})
+
+	t.Run("CreateAliasForExistingLongURL", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(3), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(3), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", alias).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).Return(&storage.TinyURL{Short: 1, LongURL: long}, nil).Times(1)
+		mockStorage.EXPECT().SetAlias(mock.Anything, "", uint64(1), alias).Return(nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, string(alias), encodeEntry(string(alias), long, 0, nil), mock.Anything).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, &model.CreateOption{Alias: string(alias)})
+		require.NoError(t, err)
+		require.Equal(t, string(alias), got.ShortURL)
+	})
+
+	t.Run("CreateAliasForAliasedLongURL", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(4), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(4), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", alias).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, Alias: []byte("summer-sale")}, nil).Times(1)
+
+		_, err := turl.Create(context.Background(), long, &model.CreateOption{Alias: string(alias)})
+		require.ErrorIs(t, err, ErrAliasConflict)
+	})
+}
+
+func TestService_Create_expiry(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &commandService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+		seq:   mockTDDL,
+		gen:   mapping.Sequential{},
+	}
+
+	long := []byte("https://www.example.com")
+
+	t.Run("CreateExpired", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		_, err := turl.Create(context.Background(), long, &model.CreateOption{ExpiresAt: &past})
+		require.ErrorIs(t, err, ErrInvalidExpiry)
+	})
+
+	t.Run("CreateWithExpiry", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Minute)
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, ExpiresAt: &expiresAt}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", long, 0, &expiresAt), mock.MatchedBy(func(ttl time.Duration) bool {
+			return ttl > 0 && ttl <= time.Minute
+		})).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, &model.CreateOption{ExpiresAt: &expiresAt})
+		require.NoError(t, err)
+		require.Equal(t, &expiresAt, got.ExpiresAt)
+	})
+
+	t.Run("CreateRenewExpiredRecord", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(2), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(2), long).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, ExpiresAt: &past}, nil).Times(1)
+		mockStorage.EXPECT().SetExpiresAt(mock.Anything, "", uint64(1), (*time.Time)(nil)).Return(nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", long, 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, nil)
+		require.NoError(t, err)
+		require.Nil(t, got.ExpiresAt)
+	})
+
+	t.Run("CreateDeduplicatedOptionsConflict", func(t *testing.T) {
+		expiresAt := time.Now().Add(7 * 24 * time.Hour)
+		// the existing record never expires, so it is not returned for the request expiring in 7 days
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(3), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(3), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long}, nil).Times(1)
+
+		_, err := turl.Create(context.Background(), long, &model.CreateOption{ExpiresAt: &expiresAt})
+		require.ErrorIs(t, err, ErrOptionsConflict)
+
+		// the redirect mode differs
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(4), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(4), long).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, RedirectMode: 301}, nil).Times(1)
+
+		_, err = turl.Create(context.Background(), long, nil)
+		require.ErrorIs(t, err, ErrOptionsConflict)
+
+		// the expiration time stored in lower precision is the same
+		stored := expiresAt.Truncate(time.Millisecond)
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(5), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(5), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, ExpiresAt: &stored}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", long, 0, &stored), mock.Anything).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, &model.CreateOption{ExpiresAt: &expiresAt})
+		require.NoError(t, err)
+		require.Equal(t, "2", got.ShortURL)
+	})
+}
+
+func TestService_Create_duplicate(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &commandService{
+		ttl:             time.Hour,
+		db:              mockStorage,
+		cache:           mockCache,
+		seq:             mockTDDL,
+		gen:             mapping.Sequential{},
+		allowDuplicates: true,
+	}
+
+	long := []byte("https://www.example.com")
+
+	t.Run("CreateDuplicate", func(t *testing.T) {
+		// the duplicates are allowed by default, the record is inserted with the duplicate option
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, DedupKey: 1}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", long, 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, nil)
+		require.NoError(t, err)
+		require.Equal(t, "2", got.ShortURL)
+	})
+
+	t.Run("CreateDuplicateShortCollision", func(t *testing.T) {
+		// the long URL never conflicts, so the conflict is the short ID collision
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(2), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(2), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(3), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(3), long, mock.Anything).
+			Return(&storage.TinyURL{Short: 3, LongURL: long, DedupKey: 3}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "4", encodeEntry("4", long, 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, nil)
+		require.NoError(t, err)
+		require.Equal(t, "4", got.ShortURL)
+	})
+
+	t.Run("CreateDeduplicated", func(t *testing.T) {
+		// the request option overrides the default
+		deny := false
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(4), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(4), long).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", long, 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, &model.CreateOption{AllowDuplicate: &deny})
+		require.NoError(t, err)
+		require.Equal(t, "2", got.ShortURL)
+	})
+}
+
+func TestService_Create_redirectMode(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &commandService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+		seq:   mockTDDL,
+		gen:   mapping.Sequential{},
+	}
+
+	long := []byte("https://www.example.com")
+
+	t.Run("CreateInvalidMode", func(t *testing.T) {
+		_, err := turl.Create(context.Background(), long, &model.CreateOption{RedirectMode: "303"})
+		require.ErrorIs(t, err, ErrInvalidRedirectMode)
+	})
+
+	t.Run("CreateWithMode", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long, mock.Anything).
+			Return(&storage.TinyURL{Short: 1, LongURL: long, RedirectMode: 308}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", long, 308, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, &model.CreateOption{RedirectMode: model.RedirectPermanent})
+		require.NoError(t, err)
+		require.Equal(t, model.RedirectPermanent, got.RedirectMode)
+	})
+}
+
+func TestService_Create_collision(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &commandService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+		seq:   mockTDDL,
+		gen:   mapping.Sequential{},
+	}
+
+	long := []byte("https://www.example.com")
+
+	t.Run("CreateRetryCollidedShortID", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(2), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(2), long).Return(&storage.TinyURL{Short: 2, LongURL: long}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "3", encodeEntry("3", long, 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Create(context.Background(), long, nil)
 		require.NoError(t, err)
+		require.Equal(t, "3", got.ShortURL)
+	})
+
+	t.Run("CreateTooManyCollisions", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(maxCollisionRetries + 1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long).Return(nil, gorm.ErrDuplicatedKey).Times(maxCollisionRetries + 1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(nil, gorm.ErrRecordNotFound).Times(maxCollisionRetries + 1)
+
+		_, err := turl.Create(context.Background(), long, nil)
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("CreateAliasOfDeleted", func(t *testing.T) {
+		// the alias is kept by a deleted record, retrying with a new short ID does not help
+		turl := *turl
+		turl.reserved, turl.codeRanges = newReservedAliases(nil), map[string]codeRange{"": {lo: 1, hi: 100}}
+
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), long, mock.Anything).Return(nil, gorm.ErrDuplicatedKey).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("spring-sale")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(1)).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		_, err := turl.Create(context.Background(), long, &model.CreateOption{Alias: "spring-sale"})
+		require.ErrorIs(t, err, ErrAliasConflict)
+	})
+
+	t.Run("CreateFailedToGenerateShortID", func(t *testing.T) {
+		turl := *turl
+		turl.gen, _ = mapping.NewFeistel([]byte("secret"))
+
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1)<<40, nil).Times(1)
+
+		_, err := turl.Create(context.Background(), long, nil)
+		require.ErrorIs(t, err, mapping.ErrSequenceOverflow)
 	})
 }
 
+func TestService_BatchCreate(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &commandService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+		seq:   mockTDDL,
+		gen:   mapping.Sequential{},
+	}
+
+	reqs := []model.CreateRequest{
+		{LongURL: "https://www.example.com/1"},
+		{LongURL: "invalid url"},
+		{LongURL: "https://www.example.com/existing"},
+		{LongURL: "https://www.example.com/2", CreateOption: model.CreateOption{Alias: "taken-alias"}},
+	}
+
+	t.Run("BatchCreate", func(t *testing.T) {
+		mockTDDL.EXPECT().NextN(mock.Anything, 3).Return([]uint64{1, 2, 3}, nil).Times(1)
+		mockStorage.EXPECT().BatchInsert(mock.Anything, mock.MatchedBy(func(records []*storage.TinyURL) bool {
+			return len(records) == 3 && records[0].Short == 1 && records[1].Short == 2 &&
+				records[2].Short == 3 && string(records[2].Alias) == "taken-alias"
+		})).Return([]*storage.TinyURL{{Short: 1, LongURL: []byte(reqs[0].LongURL)}}, nil).Times(1)
+		// fall back to the existing records
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, []byte(reqs[2].LongURL), mock.Anything, mock.Anything).
+			Return(&storage.TinyURL{Short: 100, LongURL: []byte(reqs[2].LongURL)}, nil).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("taken-alias")).
+			Return(&storage.TinyURL{Short: 101, LongURL: []byte("https://www.another.com"), Alias: []byte("taken-alias")}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2", encodeEntry("2", []byte(reqs[0].LongURL), 0, nil), time.Hour).Return(nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "2j", encodeEntry("2j", []byte(reqs[2].LongURL), 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.BatchCreate(context.Background(), reqs)
+		require.NoError(t, err)
+		require.Len(t, got, 4)
+
+		require.Equal(t, "2", got[0].ShortURL)
+		require.Empty(t, got[0].Error)
+		require.Equal(t, "invalid url", got[1].LongURL)
+		require.NotEmpty(t, got[1].Error)
+		require.Equal(t, "2j", got[2].ShortURL)
+		require.Empty(t, got[2].Error)
+		require.Equal(t, ErrAliasConflict.Error(), got[3].Error)
+	})
+
+	t.Run("BatchCreateShortCollision", func(t *testing.T) {
+		long := []byte("https://www.example.com/collision")
+		mockTDDL.EXPECT().NextN(mock.Anything, 1).Return([]uint64{4}, nil).Times(1)
+		// the short ID collides with the record of another long URL, which is not reported as inserted
+		mockStorage.EXPECT().BatchInsert(mock.Anything, mock.Anything).Return([]*storage.TinyURL{}, nil).Times(1)
+		mockStorage.EXPECT().GetByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return(nil, gorm.ErrRecordNotFound).Times(1)
+		// retry with a new short ID
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(5), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(5), long).Return(&storage.TinyURL{Short: 5, LongURL: long}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "6", encodeEntry("6", long, 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.BatchCreate(context.Background(), []model.CreateRequest{{LongURL: string(long)}})
+		require.NoError(t, err)
+		require.Empty(t, got[0].Error)
+		require.Equal(t, "6", got[0].ShortURL)
+	})
+
+	t.Run("BatchCreateAllInvalid", func(t *testing.T) {
+		got, err := turl.BatchCreate(context.Background(), []model.CreateRequest{{LongURL: "invalid url"}})
+		require.NoError(t, err)
+		require.NotEmpty(t, got[0].Error)
+	})
+
+	t.Run("BatchCreateFailed", func(t *testing.T) {
+		testErr := errors.New("test error")
+		mockTDDL.EXPECT().NextN(mock.Anything, 1).Return(nil, testErr).Times(1)
+
+		_, err := turl.BatchCreate(context.Background(), reqs[:1])
+		require.ErrorIs(t, err, testErr)
+
+		mockTDDL.EXPECT().NextN(mock.Anything, 1).Return([]uint64{1}, nil).Times(1)
+		mockStorage.EXPECT().BatchInsert(mock.Anything, mock.Anything).Return(nil, testErr).Times(1)
+
+		_, err = turl.BatchCreate(context.Background(), reqs[:1])
+		require.ErrorIs(t, err, testErr)
+	})
+}
+
+func TestService_Retrieve_expired(t *testing.T) {
+	mockCache, mockStorage := mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &queryService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+	}
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+
+	t.Run("RetrieveExpired", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(nil, cache.ErrCacheMiss).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).
+			Return(&storage.TinyURL{LongURL: []byte("https://www.example.com"), ExpiresAt: &past}, nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.ErrorIs(t, err, ErrLinkExpired)
+		require.Nil(t, got)
+	})
+
+	t.Run("RetrieveCacheTTLCapped", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(nil, cache.ErrCacheMiss).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).
+			Return(&storage.TinyURL{LongURL: []byte("https://www.example.com"), ExpiresAt: &future}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "zzzzzz", mock.Anything, mock.MatchedBy(func(ttl time.Duration) bool {
+			return ttl > 0 && ttl <= time.Minute
+		})).Return(nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("https://www.example.com"), got.LongURL)
+	})
+}
+
+func TestService_Retrieve_redirectMode(t *testing.T) {
+	mockCache, mockStorage := mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &queryService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+	}
+
+	t.Run("RetrieveFromDB", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(nil, cache.ErrCacheMiss).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).
+			Return(&storage.TinyURL{Short: 38068692543, LongURL: []byte("https://www.example.com"), RedirectMode: 301}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "zzzzzz", encodeEntry("zzzzzz", []byte("https://www.example.com"), 301, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.NoError(t, err)
+		require.Equal(t, &model.Redirect{LongURL: []byte("https://www.example.com"), Mode: model.RedirectMovedPermanently, Short: []byte("zzzzzz")}, got)
+	})
+
+	t.Run("RetrieveFromCache", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(encodeEntry("zzzzzz", []byte("https://www.example.com"), 308, nil), nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.NoError(t, err)
+		require.Equal(t, &model.Redirect{LongURL: []byte("https://www.example.com"), Mode: model.RedirectPermanent, Short: []byte("zzzzzz")}, got)
+	})
+
+	t.Run("RetrieveExpiredFromCache", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(encodeEntry("zzzzzz", []byte("https://www.example.com"), 0, &past), nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.ErrorIs(t, err, ErrLinkExpired)
+		require.Nil(t, got)
+	})
+
+	t.Run("RetrieveUnsupportedEntry", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return([]byte{cache.EntryVersion + 1}, nil).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).
+			Return(&storage.TinyURL{Short: 38068692543, LongURL: []byte("https://www.example.com")}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "zzzzzz", encodeEntry("zzzzzz", []byte("https://www.example.com"), 0, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.NoError(t, err)
+		require.Equal(t, &model.Redirect{LongURL: []byte("https://www.example.com"), Short: []byte("zzzzzz")}, got)
+	})
+
+	t.Run("RetrieveLegacyFromCache", func(t *testing.T) {
+		// the entries cached without the canonical short code are reloaded, the alias is the canonical short code
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return([]byte("200 https://www.example.com"), nil).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).
+			Return(&storage.TinyURL{Short: 38068692543, Alias: []byte("spring-sale"), LongURL: []byte("https://www.example.com"), RedirectMode: 200}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, "zzzzzz", encodeEntry("spring-sale", []byte("https://www.example.com"), 200, nil), time.Hour).Return(nil).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.NoError(t, err)
+		require.Equal(t, &model.Redirect{LongURL: []byte("https://www.example.com"), Mode: model.RedirectInterstitial, Short: []byte("spring-sale")}, got)
+	})
+}
+
+// encodeEntry returns the encoded cache entry of the link
+func encodeEntry(short string, long []byte, mode uint16, expiresAt *time.Time) []byte {
+	e := cache.Entry{LongURL: long, RedirectMode: mode, Short: []byte(short)}
+	if expiresAt != nil {
+		e.ExpiresAt = *expiresAt
+	}
+
+	return e.Encode()
+}
+
+// negativeCache is the mock cache which supports negative caching
+type negativeCache struct {
+	*mocks.MockCache
+	notFound []string
+}
+
+func (c *negativeCache) SetNotFound(_ context.Context, k string) error {
+	c.notFound = append(c.notFound, k)
+	return nil
+}
+
+func TestService_Retrieve_notFound(t *testing.T) {
+	mockCache, mockStorage := &negativeCache{MockCache: mocks.NewMockCache(t)}, mocks.NewMockStorage(t)
+
+	turl := &queryService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+	}
+
+	t.Run("RetrieveSetNotFound", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "go.example.com/zzzzzz").Return(nil, cache.ErrCacheMiss).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "go.example.com", uint64(38068692543)).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		// the Base58 code may also be an alias
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "go.example.com", []byte("zzzzzz")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "go.example.com", []byte("zzzzzz"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Nil(t, got)
+		require.Equal(t, []string{"go.example.com/zzzzzz"}, mockCache.notFound)
+	})
+
+	t.Run("RetrieveNonCanonical", func(t *testing.T) {
+		// the codes with leading '1's are aliases, not the generated short code of the same short ID
+		mockCache.EXPECT().Get(mock.Anything, "1zzzzzz").Return(nil, cache.ErrCacheMiss).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("1zzzzzz")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		_, err := turl.Retrieve(context.Background(), "", []byte("1zzzzzz"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		// the aliases matched case-insensitively by the database collation are not resolved
+		mockCache.EXPECT().Get(mock.Anything, "Spring-Sale").Return(nil, cache.ErrCacheMiss).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("Spring-Sale")).
+			Return(&storage.TinyURL{Short: 1, LongURL: []byte("https://www.example.com"), Alias: []byte("spring-sale")}, nil).Times(1)
+
+		_, err = turl.Retrieve(context.Background(), "", []byte("Spring-Sale"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Equal(t, []string{"go.example.com/zzzzzz", "1zzzzzz", "Spring-Sale"}, mockCache.notFound)
+	})
+
+	t.Run("RetrieveNegativeHit", func(t *testing.T) {
+		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(nil, cache.ErrNotFound).Times(1)
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Nil(t, got)
+	})
+
+	t.Run("RetrieveRejectedByFilter", func(t *testing.T) {
+		filter, err := bloom.New(100, 0.01)
+		require.NoError(t, err)
+
+		turl.filter = &codeFilter{filter: filter}
+		turl.filter.ready.Store(true)
+		t.Cleanup(func() { turl.filter = nil })
+
+		// rejected without any cache or db lookup
+
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Nil(t, got)
+	})
+}
+
+func TestService_Retrieve_coalesced(t *testing.T) {
+	mockCache, mockStorage := mocks.NewMockCache(t), mocks.NewMockStorage(t)
+
+	turl := &queryService{
+		ttl:   time.Hour,
+		db:    mockStorage,
+		cache: mockCache,
+	}
+
+	const n = 10
+
+	var misses sync.WaitGroup
+	misses.Add(n)
+
+	release := make(chan struct{})
+
+	mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Run(func(mock.Arguments) { misses.Done() }).
+		Return(nil, cache.ErrCacheMiss).Times(n)
+	mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).
+		RunAndReturn(func(ctx context.Context, _ string, _ uint64) (*storage.TinyURL, error) {
+			<-release
+			assert.NoError(t, ctx.Err())
+
+			return &storage.TinyURL{LongURL: []byte("https://www.example.com")}, nil
+		}).Times(1)
+	mockCache.EXPECT().Set(mock.Anything, "zzzzzz", mock.Anything, time.Hour).Return(nil).Times(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			got, err := turl.Retrieve(ctx, "", []byte("zzzzzz"))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("https://www.example.com"), got.LongURL)
+		}()
+	}
+
+	misses.Wait()
+	time.Sleep(50 * time.Millisecond) // wait for the callers to join the load
+	cancel()                          // the shared load is not canceled with the leader's context
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, RetrieveStats{Loads: 1, Coalesced: n - 1}, turl.Stats())
+}
+
+func Test_cacheTTL(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+
+	require.Equal(t, time.Hour, cacheTTL(time.Hour, &storage.TinyURL{}))
+	require.LessOrEqual(t, cacheTTL(time.Hour, &storage.TinyURL{ExpiresAt: &future}), time.Minute)
+	require.Equal(t, time.Second, cacheTTL(time.Second, &storage.TinyURL{ExpiresAt: &future}))
+	require.Less(t, cacheTTL(time.Hour, &storage.TinyURL{ExpiresAt: &past}), time.Duration(0))
+}
+
 func TestService_Retrieve_failed(t *testing.T) {
 	mockCache, mockStorage := mocks.NewMockCache(t), mocks.NewMockStorage(t)
 
@@ -155,7 +813,7 @@ func TestService_Retrieve_failed(t *testing.T) {
 	testErr := errors.New("test error")
 
 	t.Run("RetrieveFailedToDecodeShortURL", func(t *testing.T) {
-		got, err := turl.Retrieve(context.Background(), []byte("invalid_short_url"))
+		got, err := turl.Retrieve(context.Background(), "", []byte("invalid short url"))
 		require.ErrorIs(t, err, mapping.ErrInvalidInput)
 		require.Nil(t, got)
 	})
@@ -163,55 +821,188 @@ func TestService_Retrieve_failed(t *testing.T) {
 	t.Run("RetrieveFailedToGetFromCache", func(t *testing.T) {
 		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(nil, testErr).Times(1)
 
-		got, err := turl.Retrieve(context.Background(), []byte("zzzzzz"))
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
 		require.ErrorIs(t, err, testErr)
 		require.Nil(t, got)
 	})
 
 	t.Run("GetFailedToGetFromStorage", func(t *testing.T) {
 		mockCache.EXPECT().Get(mock.Anything, mock.Anything).Return(nil, cache.ErrCacheMiss).Times(1)
-		mockStorage.EXPECT().GetByShortID(mock.Anything, uint64(38068692543)).Return(nil, testErr).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(nil, testErr).Times(1)
 
-		got, err := turl.Retrieve(context.Background(), []byte("zzzzzz"))
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
 		require.ErrorIs(t, err, testErr)
 		require.Nil(t, got)
 	})
 
 	t.Run("RetrieveFailedToSetCache", func(t *testing.T) {
 		mockCache.EXPECT().Get(mock.Anything, "zzzzzz").Return(nil, cache.ErrCacheMiss).Times(1)
-		mockStorage.EXPECT().GetByShortID(mock.Anything, uint64(38068692543)).Return(&storage.TinyURL{LongURL: []byte("https://www.example.com")}, nil).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(&storage.TinyURL{LongURL: []byte("https://www.example.com")}, nil).Times(1)
 		mockCache.EXPECT().Set(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(testErr).Times(1)
 
-		got, err := turl.Retrieve(context.Background(), []byte("zzzzzz"))
+		got, err := turl.Retrieve(context.Background(), "", []byte("zzzzzz"))
 		require.NoError(t, err)
-		require.Equal(t, []byte("https://www.example.com"), got)
+		require.Equal(t, []byte("https://www.example.com"), got.LongURL)
 	})
 }
 
-func Test_queryService_GetByLong(t *testing.T) {
+func Test_queryService_tenant(t *testing.T) {
+	mockStorage := mocks.NewMockStorage(t)
+	q := &queryService{db: mockStorage}
+
+	long := []byte("https://www.example.com")
+	tenantA := apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "tenant-a"})
+	admin := apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "tenant-b", Admin: true})
+
+	t.Run("ListByLongOwned", func(t *testing.T) {
+		// the query is limited to the caller's tenant
+		mockStorage.EXPECT().ListByLongURL(mock.Anything, long, mock.Anything, mock.Anything).
+			Return([]*storage.TinyURL{{Short: 10000, LongURL: long, Tenant: "tenant-a"}}, nil).Times(1)
+
+		got, err := q.ListByLong(tenantA, "", long)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, "3yR", got[0].ShortURL)
+	})
+
+	t.Run("ListByLongAdmin", func(t *testing.T) {
+		// admins query across tenants without the owner condition
+		mockStorage.EXPECT().ListByLongURL(mock.Anything, long, mock.Anything).
+			Return([]*storage.TinyURL{{Short: 10000, LongURL: long, Tenant: "tenant-a"}}, nil).Times(1)
+
+		got, err := q.ListByLong(admin, "", long)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, "3yR", got[0].ShortURL)
+	})
+
+	t.Run("ListByLongNotFound", func(t *testing.T) {
+		mockStorage.EXPECT().ListByLongURL(mock.Anything, long, mock.Anything).Return(nil, nil).Times(1)
+
+		_, err := q.ListByLong(admin, "", long)
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Authorize", func(t *testing.T) {
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(10000)).
+			Return(&storage.TinyURL{Short: 10000, LongURL: long, Tenant: "tenant-a"}, nil).Times(3)
+
+		got, err := q.Authorize(tenantA, "", []byte("3yR"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("3yR"), got)
+
+		_, err = q.Authorize(admin, "", []byte("3yR"))
+		require.NoError(t, err)
+
+		_, err = q.Authorize(context.Background(), "", []byte("3yR"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		_, err = q.Authorize(tenantA, "", []byte("invalid short url"))
+		require.ErrorIs(t, err, mapping.ErrInvalidInput)
+	})
+}
+
+func Test_queryService_ListByLong(t *testing.T) {
 	s, err := newService(tests.GlobalConfig)
 	require.NoError(t, err)
 
-	t.Run("GetByLongSuccess", func(t *testing.T) {
-		record, err := s.Create(context.Background(), []byte("https://www.queryService_GetByLong.com"))
+	t.Run("ListByLongSuccess", func(t *testing.T) {
+		record, err := s.Create(context.Background(), []byte("https://www.queryService_ListByLong.com"), nil)
+		require.NoError(t, err)
+
+		got, err := s.ListByLong(context.Background(), "", []byte("https://www.queryService_ListByLong.com"))
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, record.ShortURL, got[0].ShortURL)
+	})
+
+	t.Run("ListByLongDuplicates", func(t *testing.T) {
+		long, allow := []byte("https://www.queryService_ListByLong.com/duplicates"), true
+		first, err := s.Create(context.Background(), long, nil)
+		require.NoError(t, err)
+		second, err := s.Create(context.Background(), long, &model.CreateOption{AllowDuplicate: &allow})
+		require.NoError(t, err)
+		require.NotEqual(t, first.ShortURL, second.ShortURL)
+
+		// the deduplicated creation still returns the first one
+		got, err := s.Create(context.Background(), long, nil)
+		require.NoError(t, err)
+		require.Equal(t, first.ShortURL, got.ShortURL)
+
+		list, err := s.ListByLong(context.Background(), "", long)
 		require.NoError(t, err)
+		require.Len(t, list, 2)
+		require.Equal(t, first.ShortURL, list[0].ShortURL)
+		require.Equal(t, second.ShortURL, list[1].ShortURL)
 
-		got, err := s.GetByLong(context.Background(), []byte("https://www.queryService_GetByLong.com"))
+		// only the specified short URL is deleted
+		require.NoError(t, s.Delete(context.Background(), "", []byte(first.ShortURL)))
+		list, err = s.ListByLong(context.Background(), "", long)
 		require.NoError(t, err)
-		require.Equal(t, record.ShortURL, got.ShortURL)
+		require.Len(t, list, 1)
+		require.Equal(t, second.ShortURL, list[0].ShortURL)
 	})
 
-	t.Run("GetByLongNon-Existed", func(t *testing.T) {
-		got, err := s.GetByLong(context.Background(), nil)
+	t.Run("ListByLongNon-Existed", func(t *testing.T) {
+		got, err := s.ListByLong(context.Background(), "", nil)
 		require.Error(t, err)
-		got, err = s.GetByLong(context.Background(), []byte("example.com"))
+		got, err = s.ListByLong(context.Background(), "", []byte("example.com"))
 		require.Error(t, err)
-		got, err = s.GetByLong(context.Background(), []byte("https://www.Non-Existed.com"))
+		got, err = s.ListByLong(context.Background(), "", []byte("https://www.Non-Existed.com"))
 		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
 		require.Nil(t, got)
 	})
 }
 
+func TestService_canonical(t *testing.T) {
+	mockTDDL, mockCache, mockStorage := mocks.NewMockTDDL(t), mocks.NewMockCache(t), mocks.NewMockStorage(t)
+	canon := canonical.New(&configs.CanonicalConfig{Enable: true, SortQuery: true, DropParams: []string{"utm_*"}})
+
+	c := &commandService{
+		ttl:       time.Hour,
+		db:        mockStorage,
+		cache:     mockCache,
+		seq:       mockTDDL,
+		gen:       mapping.Sequential{},
+		canonical: canon,
+	}
+	q := &queryService{ttl: time.Hour, db: mockStorage, cache: mockCache, canonical: canon}
+
+	long, want := []byte("HTTPS://WWW.Example.com:443/a?b=1&utm_source=x&a=2"), []byte("https://www.example.com/a?a=2&b=1")
+	record := &storage.TinyURL{Short: 1, LongURL: want}
+
+	t.Run("Create", func(t *testing.T) {
+		mockTDDL.EXPECT().Next(mock.Anything).Return(uint64(1), nil).Times(1)
+		mockStorage.EXPECT().Insert(mock.Anything, uint64(1), want).Return(record, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Times(1)
+
+		got, err := c.Create(context.Background(), long, nil)
+		require.NoError(t, err)
+		require.Equal(t, string(want), got.LongURL)
+	})
+
+	t.Run("BatchCreate", func(t *testing.T) {
+		mockTDDL.EXPECT().NextN(mock.Anything, 1).Return([]uint64{1}, nil).Times(1)
+		mockStorage.EXPECT().BatchInsert(mock.Anything, mock.MatchedBy(func(records []*storage.TinyURL) bool {
+			return len(records) == 1 && bytes.Equal(records[0].LongURL, want)
+		})).Return([]*storage.TinyURL{record}, nil).Times(1)
+		mockCache.EXPECT().Set(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Times(1)
+
+		got, err := c.BatchCreate(context.Background(), []model.CreateRequest{{LongURL: string(long)}})
+		require.NoError(t, err)
+		require.Empty(t, got[0].Error)
+		require.Equal(t, string(want), got[0].TinyURL.LongURL)
+	})
+
+	t.Run("ListByLong", func(t *testing.T) {
+		mockStorage.EXPECT().ListByLongURL(mock.Anything, want, mock.Anything, mock.Anything).Return([]*storage.TinyURL{record}, nil).Times(1)
+
+		got, err := q.ListByLong(context.Background(), "", long)
+		require.NoError(t, err)
+		require.Equal(t, string(want), got[0].LongURL)
+	})
+}
+
 func Test_commandService_Delete(t *testing.T) {
 	mockCache, mockStorage := mocks.NewMockCache(t), mocks.NewMockStorage(t)
 
@@ -223,30 +1014,95 @@ func Test_commandService_Delete(t *testing.T) {
 
 	testErr := errors.New("test error")
 
+	record := &storage.TinyURL{Short: 38068692543, LongURL: []byte("https://www.example.com")}
+
 	t.Run("DeleteSuccess", func(t *testing.T) {
-		mockStorage.EXPECT().Delete(mock.Anything, uint64(38068692543)).Return(nil).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(record, nil).Times(1)
+		mockStorage.EXPECT().Delete(mock.Anything, "", uint64(38068692543)).Return(nil).Times(1)
 		mockCache.EXPECT().Del(mock.Anything, "zzzzzz").Return(nil).Times(1)
 
-		require.NoError(t, s.Delete(context.Background(), []byte("zzzzzz")))
+		require.NoError(t, s.Delete(context.Background(), "", []byte("zzzzzz")))
+	})
+
+	t.Run("DeleteAliasSuccess", func(t *testing.T) {
+		aliased := &storage.TinyURL{Short: 38068692543, LongURL: []byte("https://www.example.com"), Alias: []byte("spring-sale")}
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("spring-sale")).Return(aliased, nil).Times(1)
+		mockStorage.EXPECT().Delete(mock.Anything, "", uint64(38068692543)).Return(nil).Times(1)
+		mockCache.EXPECT().Del(mock.Anything, "spring-sale").Return(nil).Times(1)
+		mockCache.EXPECT().Del(mock.Anything, "zzzzzz").Return(nil).Times(1)
+
+		require.NoError(t, s.Delete(context.Background(), "", []byte("spring-sale")))
+	})
+
+	t.Run("DeleteOwnedByAnotherTenant", func(t *testing.T) {
+		owned := &storage.TinyURL{Short: 38068692543, LongURL: []byte("https://www.example.com"), Tenant: "tenant-a"}
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(owned, nil).Times(2)
+
+		ctx := apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "tenant-b"})
+		require.ErrorIs(t, s.Delete(ctx, "", []byte("zzzzzz")), gorm.ErrRecordNotFound)
+		// the caller without API key is the default tenant
+		require.ErrorIs(t, s.Delete(context.Background(), "", []byte("zzzzzz")), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("DeleteByAdmin", func(t *testing.T) {
+		owned := &storage.TinyURL{Short: 38068692543, LongURL: []byte("https://www.example.com"), Tenant: "tenant-a"}
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(owned, nil).Times(1)
+		mockStorage.EXPECT().Delete(mock.Anything, "", uint64(38068692543)).Return(nil).Times(1)
+		mockCache.EXPECT().Del(mock.Anything, "zzzzzz").Return(nil).Times(1)
+
+		ctx := apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "tenant-b", Admin: true})
+		require.NoError(t, s.Delete(ctx, "", []byte("zzzzzz")))
 	})
 
 	t.Run("DeleteFailedToDecodeShortURL", func(t *testing.T) {
-		err := s.Delete(context.Background(), []byte("invalid_short_url"))
+		err := s.Delete(context.Background(), "", []byte("invalid short url"))
 		require.ErrorIs(t, err, mapping.ErrInvalidInput)
 	})
 
+	t.Run("DeleteFailedRecordNotFound", func(t *testing.T) {
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(nil, gorm.ErrRecordNotFound).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("zzzzzz")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		err := s.Delete(context.Background(), "", []byte("zzzzzz"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
 	t.Run("DeleteFailedToDeleteFromStorage", func(t *testing.T) {
-		mockStorage.EXPECT().Delete(mock.Anything, uint64(38068692543)).Return(testErr).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(record, nil).Times(1)
+		mockStorage.EXPECT().Delete(mock.Anything, "", uint64(38068692543)).Return(testErr).Times(1)
 
-		err := s.Delete(context.Background(), []byte("zzzzzz"))
+		err := s.Delete(context.Background(), "", []byte("zzzzzz"))
 		require.ErrorIs(t, err, testErr)
 	})
 
 	t.Run("DeleteFailedToDeleteFromCache", func(t *testing.T) {
-		mockStorage.EXPECT().Delete(mock.Anything, uint64(38068692543)).Return(nil).Times(1)
+		mockStorage.EXPECT().GetByShortID(mock.Anything, "", uint64(38068692543)).Return(record, nil).Times(1)
+		mockStorage.EXPECT().Delete(mock.Anything, "", uint64(38068692543)).Return(nil).Times(1)
 		mockCache.EXPECT().Del(mock.Anything, "zzzzzz").Return(testErr).Times(1)
 
-		err := s.Delete(context.Background(), []byte("zzzzzz"))
+		err := s.Delete(context.Background(), "", []byte("zzzzzz"))
 		require.ErrorIs(t, err, testErr)
 	})
 }
+
+func TestService_sequence(t *testing.T) {
+	mockTDDL := mocks.NewMockTDDL(t)
+	turl := &commandService{seq: mockTDDL}
+
+	tenantA := apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "Tenant-A"})
+	require.Equal(t, mockTDDL, turl.sequence(tenantA, ""))
+
+	turl.seqs = tddl.NewManager(nil, &configs.TDDLConfig{
+		SeqName:   "turl",
+		Sequences: map[string]*configs.SequenceConfig{"tenant-a": {StartNum: 1}},
+	})
+	t.Cleanup(turl.seqs.Close)
+
+	// the tenant with a named sequence uses its own sequence, the others share the default one
+	require.NotEqual(t, mockTDDL, turl.sequence(tenantA, ""))
+	require.Equal(t, mockTDDL, turl.sequence(apikey.NewContext(context.Background(), &apikey.APIKey{Tenant: "tenant-b"}), ""))
+	require.Equal(t, mockTDDL, turl.sequence(context.Background(), ""))
+
+	// the short domains other than the default one have their own sequences
+	require.NotEqual(t, mockTDDL, turl.sequence(context.Background(), "go.example.com"))
+}
@@ -0,0 +1,158 @@
+package turl
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/analytics"
+	"github.com/beihai0xff/turl/pkg/cache"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+const (
+	// defaultWarmUpTimeout is the default time budget of the cache warm-up
+	defaultWarmUpTimeout = 30 * time.Second
+	// defaultWarmUpWindow is the default time window to count the clicks
+	defaultWarmUpWindow = 24 * time.Hour
+	// warmUpBatchSize is the number of the links loaded between the progress logs
+	warmUpBatchSize = 1000
+)
+
+// warmUp loads the links into the local cache before the server starts, until the count of the links are loaded
+// or the time budget is exhausted, the server starts anyway after the budget. The most clicked links are loaded
+// from the analytics, and the most recently created links are loaded if the analytics is disabled.
+func (q *queryService) warmUp(ctx context.Context, c *configs.WarmUpConfig, a analytics.Analytics) {
+	if c == nil || !c.Enable {
+		return
+	}
+
+	timeout, window := c.Timeout, c.Window
+	if timeout <= 0 {
+		timeout = defaultWarmUpTimeout
+	}
+
+	if window <= 0 {
+		window = defaultWarmUpWindow
+	}
+
+	source := c.Source
+	if source == configs.WarmUpClicks && a == nil {
+		slog.Warn("analytics is disabled, warm up local cache with the recent links instead")
+		source = configs.WarmUpRecent
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	slog.Info("cache warm-up started", slog.String("source", source), slog.Int("count", c.Count),
+		slog.Duration("timeout", timeout))
+
+	var (
+		loaded int
+		err    error
+	)
+
+	if source == configs.WarmUpClicks {
+		loaded, err = q.warmUpClicked(ctx, a, time.Now().Add(-window), c.Count)
+	} else {
+		loaded, err = q.warmUpRecent(ctx, c.Count)
+	}
+
+	attrs := []any{slog.Int("loaded", loaded), slog.Duration("elapsed", time.Since(start))}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("cache warm-up time budget is exhausted, start anyway", attrs...)
+	case err != nil:
+		slog.Error("cache warm-up failed, start anyway", append(attrs, slog.Any("error", err))...)
+	default:
+		slog.Info("cache warm-up finished", attrs...)
+	}
+}
+
+// warmUpRecent loads the most recently created links, returns the number of the links loaded
+func (q *queryService) warmUpRecent(ctx context.Context, count int) (int, error) {
+	var (
+		before uint
+		loaded int
+	)
+
+	for loaded < count {
+		limit := min(warmUpBatchSize, count-loaded)
+
+		records, err := q.db.ListRecent(ctx, before, limit)
+		if err != nil {
+			return loaded, err
+		}
+
+		for _, r := range records {
+			q.warm(ctx, domainKey(r.Domain, shortCode(r)), r)
+			before = r.ID
+		}
+
+		loaded += len(records)
+		slog.Info("cache warm-up progress", slog.Int("loaded", loaded), slog.Int("count", count))
+
+		if len(records) < limit {
+			break
+		}
+	}
+
+	return loaded, nil
+}
+
+// warmUpClicked loads the links most clicked since the time, returns the number of the links loaded
+func (q *queryService) warmUpClicked(ctx context.Context, a analytics.Analytics, since time.Time, count int) (int, error) {
+	keys, err := a.Top(ctx, since, count)
+	if err != nil {
+		return 0, err
+	}
+
+	var loaded int
+
+	for i, key := range keys {
+		domain, code := splitDomainKey(key)
+
+		record, err := getRecord(ctx, q.db, domain, code)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound): // deleted since clicked
+		case err != nil:
+			return loaded, err
+		case !record.Expired():
+			q.warm(ctx, key, record)
+			loaded++
+		}
+
+		if (i+1)%warmUpBatchSize == 0 {
+			slog.Info("cache warm-up progress", slog.Int("loaded", loaded), slog.Int("count", len(keys)))
+		}
+	}
+
+	return loaded, nil
+}
+
+// warm sets the record into the local cache only, both tiers are set if the cache has no local tier,
+// if failed, just log the error.
+func (q *queryService) warm(ctx context.Context, key string, record *storage.TinyURL) {
+	ttl := cacheTTL(q.ttl, record)
+	if ttl <= 0 {
+		return
+	}
+
+	var err error
+	if c, ok := q.cache.(cache.LocalSetter); ok {
+		err = c.SetLocal(ctx, key, cacheEntry(record).Encode(), ttl)
+	} else {
+		err = cache.SetEntry(ctx, q.cache, key, cacheEntry(record), ttl)
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "failed to warm up cache", slog.String("key", key), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,132 @@
+package turl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/internal/tests/mocks"
+	"github.com/beihai0xff/turl/pkg/cache"
+	"github.com/beihai0xff/turl/pkg/mapping"
+	"github.com/beihai0xff/turl/pkg/storage"
+)
+
+// localSetterCache records the keys set to the local tier only
+type localSetterCache struct {
+	*mocks.MockCache
+	local map[string][]byte
+}
+
+func (c *localSetterCache) SetLocal(_ context.Context, k string, v []byte, _ time.Duration) error {
+	c.local[k] = v
+	return nil
+}
+
+func Test_queryService_warmUp(t *testing.T) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+	records := []*storage.TinyURL{
+		{Model: gorm.Model{ID: 3}, Short: 10000, LongURL: []byte("https://www.example.com/3")},
+		{Model: gorm.Model{ID: 2}, Domain: "go.example.com", Alias: []byte("my_link"), Short: 9999, LongURL: []byte("https://www.example.com/2")},
+		{Model: gorm.Model{ID: 1}, Short: 9998, LongURL: []byte("https://www.example.com/1"), ExpiresAt: &past},
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		q := &queryService{ttl: time.Hour, db: mocks.NewMockStorage(t), cache: mocks.NewMockCache(t)}
+		q.warmUp(ctx, nil, nil)
+		q.warmUp(ctx, &configs.WarmUpConfig{Count: 10}, nil)
+	})
+
+	t.Run("recent", func(t *testing.T) {
+		mockStorage := mocks.NewMockStorage(t)
+		c, err := cache.NewLocalCache(&configs.LocalCacheConfig{Type: configs.LocalCacheLRU, TTL: time.Minute, Capacity: 100})
+		require.NoError(t, err)
+
+		q := &queryService{ttl: time.Hour, db: mockStorage, cache: c}
+		mockStorage.EXPECT().ListRecent(mock.Anything, uint(0), 5).Return(records, nil).Times(1)
+
+		// fall back to the recent links if the analytics is disabled
+		q.warmUp(ctx, &configs.WarmUpConfig{Enable: true, Source: configs.WarmUpClicks, Count: 5}, nil)
+
+		// the warmed links are served without db lookup
+		got, err := q.Retrieve(ctx, "", mapping.Base58Encode(10000))
+		require.NoError(t, err)
+		require.Equal(t, "https://www.example.com/3", string(got.LongURL))
+
+		got, err = q.Retrieve(ctx, "go.example.com", []byte("my_link"))
+		require.NoError(t, err)
+		require.Equal(t, "https://www.example.com/2", string(got.LongURL))
+
+		// the expired links are not cached
+		_, err = c.Get(ctx, string(mapping.Base58Encode(9998)))
+		require.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+
+	t.Run("recent_batches", func(t *testing.T) {
+		mockStorage := mocks.NewMockStorage(t)
+		q := &queryService{ttl: time.Hour, db: mockStorage, cache: &localSetterCache{MockCache: mocks.NewMockCache(t), local: map[string][]byte{}}}
+
+		batch := make([]*storage.TinyURL, warmUpBatchSize)
+		for i := range batch {
+			batch[i] = &storage.TinyURL{Model: gorm.Model{ID: uint(2*warmUpBatchSize - i)}, Short: uint64(i + 1), LongURL: []byte("https://www.example.com")}
+		}
+
+		mockStorage.EXPECT().ListRecent(mock.Anything, uint(0), warmUpBatchSize).Return(batch, nil).Times(1)
+		mockStorage.EXPECT().ListRecent(mock.Anything, uint(warmUpBatchSize+1), 500).Return(batch[:500], nil).Times(1)
+
+		loaded, err := q.warmUpRecent(ctx, warmUpBatchSize+500)
+		require.NoError(t, err)
+		require.Equal(t, warmUpBatchSize+500, loaded)
+	})
+
+	t.Run("clicks", func(t *testing.T) {
+		mockStorage, mockAnalytics := mocks.NewMockStorage(t), mocks.NewMockAnalytics(t)
+		c := &localSetterCache{MockCache: mocks.NewMockCache(t), local: map[string][]byte{}}
+		q := &queryService{ttl: time.Hour, db: mockStorage, cache: c}
+
+		mockAnalytics.EXPECT().Top(mock.Anything, mock.Anything, 3).Return([]string{"go.example.com/my_link", "gone_link"}, nil).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "go.example.com", []byte("my_link")).Return(records[1], nil).Times(1)
+		mockStorage.EXPECT().GetByAlias(mock.Anything, "", []byte("gone_link")).Return(nil, gorm.ErrRecordNotFound).Times(1)
+
+		q.warmUp(ctx, &configs.WarmUpConfig{Enable: true, Source: configs.WarmUpClicks, Count: 3}, mockAnalytics)
+
+		require.Len(t, c.local, 1)
+		e, err := cache.DecodeEntry(c.local["go.example.com/my_link"])
+		require.NoError(t, err)
+		require.Equal(t, "https://www.example.com/2", string(e.LongURL))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		mockStorage := mocks.NewMockStorage(t)
+		q := &queryService{ttl: time.Hour, db: mockStorage, cache: mocks.NewMockCache(t)}
+
+		mockStorage.EXPECT().ListRecent(mock.Anything, uint(0), 10).RunAndReturn(
+			func(ctx context.Context, _ uint, _ int) ([]*storage.TinyURL, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}).Times(1)
+
+		start := time.Now()
+		q.warmUp(ctx, &configs.WarmUpConfig{Enable: true, Count: 10, Timeout: 50 * time.Millisecond}, nil)
+		require.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func Test_splitDomainKey(t *testing.T) {
+	for _, tt := range []struct {
+		domain string
+		code   []byte
+	}{
+		{"", []byte("abc")},
+		{"go.example.com", []byte("my_link")},
+	} {
+		domain, code := splitDomainKey(domainKey(tt.domain, tt.code))
+		require.Equal(t, tt.domain, domain)
+		require.Equal(t, tt.code, code)
+	}
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/db"
+)
+
+var (
+	tenantFlag = &cli.StringFlag{
+		Name:     "tenant",
+		Usage:    "Tenant Of The API Key",
+		Required: true,
+	}
+	nameFlag = &cli.StringFlag{
+		Name:  "name",
+		Usage: "Description Of The API Key",
+	}
+	adminFlag = &cli.BoolFlag{
+		Name:  "admin",
+		Usage: "Whether The API Key Can Act Across Tenants",
+	}
+	idFlag = &cli.UintFlag{
+		Name:     "id",
+		Usage:    "ID Of The API Key",
+		Required: true,
+	}
+)
+
+type apiKeyCLI struct{}
+
+func (c *apiKeyCLI) getCreateFlags() []cli.Flag {
+	return []cli.Flag{configPathFlag, tenantFlag, nameFlag, adminFlag}
+}
+
+func (c *apiKeyCLI) getRevokeFlags() []cli.Flag {
+	return []cli.Flag{configPathFlag, idFlag}
+}
+
+func (c *apiKeyCLI) store(ctx *cli.Context) (apikey.Store, error) {
+	conf, err := configs.ReadFile(ctx.String(configPathFlag.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := db.New(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.AutoMigrate(&apikey.APIKey{}); err != nil {
+		return nil, err
+	}
+
+	return apikey.New(db), nil
+}
+
+// create creates a new API key, the plaintext key is only printed once
+func (c *apiKeyCLI) create(ctx *cli.Context) error {
+	s, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, k, err := s.Create(ctx.Context, ctx.String(nameFlag.Name), ctx.String(tenantFlag.Name), ctx.Bool(adminFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(ctx.App.Writer, "API key %d of tenant %q is created, please keep it safe, it will not be shown again:\n%s\n",
+		k.ID, k.Tenant, key)
+
+	return err
+}
+
+// revoke revokes the API key by id
+func (c *apiKeyCLI) revoke(ctx *cli.Context) error {
+	s, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Revoke(ctx.Context, ctx.Uint(idFlag.Name)); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(ctx.App.Writer, "API key %d is revoked\n", ctx.Uint(idFlag.Name))
+
+	return err
+}
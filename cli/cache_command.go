@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/cache"
+	"github.com/beihai0xff/turl/pkg/db/redis"
+)
+
+// errNoRedis is returned when the invalidations can not be published without redis, e.g. in the embedded mode
+var errNoRedis = errors.New("the local cache flush is published by redis, but redis is not configured")
+
+type cacheCLI struct{}
+
+func (c *cacheCLI) getFlushFlags() []cli.Flag {
+	return []cli.Flag{configPathFlag}
+}
+
+// flush publishes the invalidation which flushes the local caches of all the instances
+func (c *cacheCLI) flush(ctx *cli.Context) error {
+	conf, err := configs.ReadFile(ctx.String(configPathFlag.Name), nil)
+	if err != nil {
+		return err
+	}
+
+	if conf.Cache.Redis == nil {
+		return errNoRedis
+	}
+
+	var channel string
+	if conf.Cache.Invalidation != nil {
+		channel = conf.Cache.Invalidation.Channel
+	}
+
+	rdb, err := redis.Client(conf.Cache.Redis)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	if err = cache.NewBus(rdb, channel).PublishFlush(ctx.Context); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(ctx.App.Writer, "local cache flush is published")
+
+	return err
+}
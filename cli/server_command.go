@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -16,6 +17,7 @@ import (
 	"github.com/beihai0xff/turl/app/turl"
 	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/pkg/log"
+	"github.com/beihai0xff/turl/pkg/middleware"
 	"github.com/beihai0xff/turl/pkg/shutdown"
 )
 
@@ -63,6 +65,17 @@ func (c *serverCLI) serverHealth(ctx *cli.Context) error {
 		return fmt.Errorf("health check failed, status: %s", rsp.Status)
 	}
 
+	var health struct {
+		Status   string            `json:"status"`
+		Breakers map[string]string `json:"breakers"`
+	}
+
+	if err = json.NewDecoder(rsp.Body).Decode(&health); err == nil && health.Status == middleware.HealthDegraded {
+		// the server still serves with fallbacks while degraded, so it is not a failure
+		slog.Warn("health check success, but the server is degraded", slog.Any("breakers", health.Breakers))
+		return nil
+	}
+
 	slog.Info("health check success")
 
 	return nil
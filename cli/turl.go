@@ -24,7 +24,7 @@ var (
 
 // New returns a new cli app
 func New() *cli.App {
-	c := serverCLI{}
+	c, k, ca := serverCLI{}, apiKeyCLI{}, cacheCLI{}
 
 	app := cli.App{
 		Name:                 "turl",
@@ -49,6 +49,36 @@ func New() *cli.App {
 				Usage:  "Server Healthcheck",
 				Action: c.serverHealth,
 			},
+			{
+				Name:  "apikey",
+				Usage: "Manage The API Keys Of Management API",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "create",
+						Usage:  "Create API Key",
+						Action: k.create,
+						Flags:  k.getCreateFlags(),
+					},
+					{
+						Name:   "revoke",
+						Usage:  "Revoke API Key",
+						Action: k.revoke,
+						Flags:  k.getRevokeFlags(),
+					},
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage The Local Caches Of All Instances",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "flush",
+						Usage:  "Flush Local Caches Of All Instances",
+						Action: ca.flush,
+						Flags:  ca.getFlushFlags(),
+					},
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -0,0 +1,15 @@
+package configs
+
+import "time"
+
+// AnalyticsConfig is the click analytics config of turl server
+type AnalyticsConfig struct {
+	// Enable is whether to record the click events of short URLs
+	Enable bool `json:"enable" yaml:"enable" mapstructure:"enable"`
+	// QueueSize is the capacity of the in-process click event queue, events are dropped when the queue is full
+	QueueSize int `validate:"required,min=1" json:"queue_size" yaml:"queue_size" mapstructure:"queue_size"`
+	// BatchSize is the max number of click events inserted into the database in one statement
+	BatchSize int `validate:"required,min=1" json:"batch_size" yaml:"batch_size" mapstructure:"batch_size"`
+	// FlushInterval is the max interval to flush the pending click events into the database
+	FlushInterval time.Duration `validate:"required" json:"flush_interval" yaml:"flush_interval" mapstructure:"flush_interval"`
+}
@@ -2,10 +2,36 @@ package configs
 
 import "time"
 
+const (
+	// RedisStandalone connects to a single redis server
+	RedisStandalone = "standalone"
+	// RedisSentinel connects to the master discovered by the redis sentinels
+	RedisSentinel = "sentinel"
+	// RedisCluster connects to a redis cluster
+	RedisCluster = "cluster"
+)
+
 // RedisConfig Redis config
 type RedisConfig struct {
-	// Addr is the redis address
+	// Mode is the redis deployment mode, one of standalone, sentinel and cluster. Default chooses by the config:
+	// sentinel if MasterName is set, cluster if multiple addresses are given, standalone otherwise
+	Mode string `validate:"omitempty,oneof=standalone sentinel cluster" json:"mode" yaml:"mode" mapstructure:"mode"`
+	// Addr is the redis address, the sentinel addresses in sentinel mode and the seed nodes in cluster mode
 	Addr []string `validate:"required" json:"addr" yaml:"addr" mapstructure:"addr"`
+	// MasterName is the master name monitored by the sentinels
+	MasterName string `validate:"required_if=Mode sentinel" json:"master_name" yaml:"master_name" mapstructure:"master_name"`
+	// Username and Password are the ACL user and password of redis, the Username is empty for the legacy AUTH
+	Username string `json:"username" yaml:"username" mapstructure:"username"`
+	Password string `json:"password" yaml:"password" mapstructure:"password"`
+	// SentinelUsername and SentinelPassword are the ACL user and password of the sentinels
+	SentinelUsername string `json:"sentinel_username" yaml:"sentinel_username" mapstructure:"sentinel_username"`
+	SentinelPassword string `json:"sentinel_password" yaml:"sentinel_password" mapstructure:"sentinel_password"`
+	// DB is the database index, must be 0 in cluster mode
+	DB int `validate:"min=0,excluded_if=Mode cluster" json:"db" yaml:"db" mapstructure:"db"`
+	// TLS is the TLS config of the redis connections, nil disables TLS
+	TLS *TLSConfig `json:"tls" yaml:"tls" mapstructure:"tls"`
+	// Breaker is the circuit breaker config of redis, the defaults are used if nil
+	Breaker *BreakerConfig `json:"breaker" yaml:"breaker" mapstructure:"breaker"`
 	// DialTimeout Dial timeout for establishing new connections.
 	// Default is 5 seconds.
 	DialTimeout time.Duration `validate:"required" json:"dial_timeout" yaml:"dial_timeout" mapstructure:"dial_timeout"`
@@ -15,8 +41,25 @@ type RedisConfig struct {
 	TTL time.Duration `validate:"required" json:"ttl" yaml:"ttl" mapstructure:"ttl"`
 }
 
+// BreakerConfig is the circuit breaker config, the callers fall back while the circuit is open
+type BreakerConfig struct {
+	// FailureThreshold is the number of the consecutive failures to open the circuit, default is 5
+	FailureThreshold int `validate:"omitempty,min=1" json:"failure_threshold" yaml:"failure_threshold" mapstructure:"failure_threshold"`
+	// OpenTimeout is the duration the circuit stays open before probing, default is 10 seconds
+	OpenTimeout time.Duration `validate:"omitempty,min=0" json:"open_timeout" yaml:"open_timeout" mapstructure:"open_timeout"`
+}
+
+const (
+	// LocalCacheBigCache is the bigcache local cache, the entries expire at the earlier of their ttl and the global ttl
+	LocalCacheBigCache = "bigcache"
+	// LocalCacheLRU is the in-process LRU local cache with per-entry expiry
+	LocalCacheLRU = "lru"
+)
+
 // LocalCacheConfig is the local cache config of turl server
 type LocalCacheConfig struct {
+	// Type is the local cache backend, one of bigcache and lru, default is bigcache
+	Type string `validate:"omitempty,oneof=bigcache lru" json:"type" yaml:"type" mapstructure:"type"`
 	// TTL is the local cache ttl
 	TTL time.Duration `validate:"required" json:"ttl" yaml:"ttl" mapstructure:"ttl"`
 	// Capacity is the local cache capacity
@@ -31,4 +74,54 @@ type CacheConfig struct {
 	Redis *RedisConfig `json:"redis" yaml:"redis" mapstructure:"redis"`
 	// LocalCache is the local cache config
 	LocalCache *LocalCacheConfig `validate:"required" json:"local_cache" yaml:"local_cache" mapstructure:"local_cache"`
+	// NegativeTTL is the ttl of the negative cache entries of the short URLs not found, 0 disables negative caching
+	NegativeTTL time.Duration `validate:"omitempty,min=0" json:"negative_ttl" yaml:"negative_ttl" mapstructure:"negative_ttl"`
+	// Bloom is the bloom filter config of the existing short URLs, nil disables the bloom filter
+	Bloom *BloomConfig `json:"bloom" yaml:"bloom" mapstructure:"bloom"`
+	// Invalidation is the config of the local cache invalidation across instances, nil disables the invalidation
+	Invalidation *InvalidationConfig `json:"invalidation" yaml:"invalidation" mapstructure:"invalidation"`
+	// WarmUp is the config of loading the links into the local cache on startup, nil disables the warm-up
+	WarmUp *WarmUpConfig `json:"warm_up" yaml:"warm_up" mapstructure:"warm_up"`
+}
+
+const (
+	// WarmUpRecent warms up the local cache with the most recently created links
+	WarmUpRecent = "recent"
+	// WarmUpClicks warms up the local cache with the most clicked links, requires the analytics
+	WarmUpClicks = "clicks"
+)
+
+// WarmUpConfig is the config of loading the links into the local cache before the server starts,
+// so that a fresh instance, e.g. a read-only replica, does not send all its first requests to redis and db.
+type WarmUpConfig struct {
+	// Enable is whether to warm up the local cache on startup
+	Enable bool `json:"enable" yaml:"enable" mapstructure:"enable"`
+	// Source is the links to load, one of recent and clicks, default is recent
+	Source string `validate:"omitempty,oneof=recent clicks" json:"source" yaml:"source" mapstructure:"source"`
+	// Count is the max number of the links to load
+	Count int `validate:"required_if=Enable true,omitempty,min=1" json:"count" yaml:"count" mapstructure:"count"`
+	// Window is the time window to count the clicks of the clicks source, default is 24 hours
+	Window time.Duration `validate:"omitempty,min=0" json:"window" yaml:"window" mapstructure:"window"`
+	// Timeout is the time budget of the warm-up, the server starts anyway after it, default is 30 seconds
+	Timeout time.Duration `validate:"omitempty,min=0" json:"timeout" yaml:"timeout" mapstructure:"timeout"`
+}
+
+// InvalidationConfig is the config of the local cache invalidation across instances by redis pub/sub
+type InvalidationConfig struct {
+	// Enable is whether to publish and subscribe the invalidations of the local caches
+	Enable bool `json:"enable" yaml:"enable" mapstructure:"enable"`
+	// Channel is the redis channel of the invalidations, default is turl:cache:invalidation
+	Channel string `json:"channel" yaml:"channel" mapstructure:"channel"`
+}
+
+// BloomConfig is the config of the bloom filter, which rejects the short URLs not exist without any I/O
+type BloomConfig struct {
+	// Enable is whether to enable the bloom filter
+	Enable bool `json:"enable" yaml:"enable" mapstructure:"enable"`
+	// Capacity is the expected number of the short URLs, the false positive rate grows when exceeded
+	Capacity uint64 `validate:"required_if=Enable true" json:"capacity" yaml:"capacity" mapstructure:"capacity"`
+	// FalsePositiveRate is the expected false positive rate, default is 0.01
+	FalsePositiveRate float64 `validate:"omitempty,gt=0,lt=1" json:"false_positive_rate" yaml:"false_positive_rate" mapstructure:"false_positive_rate"`
+	// RefreshInterval is the interval to load the short URLs created by the other instances, default is 10s
+	RefreshInterval time.Duration `validate:"omitempty,min=0" json:"refresh_interval" yaml:"refresh_interval" mapstructure:"refresh_interval"`
 }
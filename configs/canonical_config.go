@@ -0,0 +1,14 @@
+package configs
+
+// CanonicalConfig is the config of canonicalizing the long URLs before deduplication, so that the equivalent URLs
+// share the same short URL. The scheme and host are lower-cased, the IDNs are converted to punycode,
+// and the default ports are stripped, the query parameters are rewritten as configured.
+type CanonicalConfig struct {
+	// Enable is whether to canonicalize the long URLs
+	Enable bool `json:"enable" yaml:"enable" mapstructure:"enable"`
+	// SortQuery is whether to sort the query parameters by name, the values of the same name keep their order
+	SortQuery bool `json:"sort_query" yaml:"sort_query" mapstructure:"sort_query"`
+	// DropParams are the names of the query parameters to drop, e.g. the tracking parameters,
+	// the names are case-insensitive, and a trailing * matches the prefix, e.g. utm_*
+	DropParams []string `validate:"omitempty,dive,required" json:"drop_params" yaml:"drop_params" mapstructure:"drop_params"`
+}
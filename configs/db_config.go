@@ -0,0 +1,26 @@
+// Package configs provides config management
+package configs
+
+const (
+	// DriverMySQL is the MySQL database driver
+	DriverMySQL = "mysql"
+	// DriverPostgres is the PostgreSQL database driver
+	DriverPostgres = "postgres"
+	// DriverSQLite is the embedded SQLite database driver, the DSN is the database file path
+	DriverSQLite = "sqlite"
+)
+
+// DBConfig is the database config
+type DBConfig struct {
+	// Driver is the database driver, one of mysql, postgres and sqlite, default mysql
+	Driver string `validate:"omitempty,oneof=mysql postgres sqlite" json:"driver" yaml:"driver" mapstructure:"driver"`
+	// DSN is the data source name
+	DSN string `json:"dsn" yaml:"dsn" mapstructure:"dsn"`
+	// MaxIdleConn is the max open connections
+	MaxConn int `validate:"required,min=1" json:"max_conn" yaml:"max_conn" mapstructure:"max_conn"`
+}
+
+// MySQLConfig is the database config.
+//
+// Deprecated: use DBConfig instead, the database is not limited to MySQL.
+type MySQLConfig = DBConfig
@@ -0,0 +1,15 @@
+package configs
+
+// DomainConfig is the config of a short domain served by turl server
+type DomainConfig struct {
+	// Domain is the base URL of the short URLs of the domain, e.g. https://go.example.com
+	Domain string `validate:"required,http_url" json:"domain" yaml:"domain" mapstructure:"domain"`
+	// RedirectStatus is the default http status code of the redirects, one of 301, 302, 307 and 308, default is 302
+	RedirectStatus int `validate:"omitempty,oneof=301 302 307 308" json:"redirect_status" yaml:"redirect_status" mapstructure:"redirect_status"`
+	// FallbackURL is the page to redirect to if the short URL is invalid, not found or has expired,
+	// the error is responded if not set
+	FallbackURL string `validate:"omitempty,http_url" json:"fallback_url" yaml:"fallback_url" mapstructure:"fallback_url"`
+	// Sequence overrides the step and start number of the domain sequence, each domain generates short codes
+	// from its own sequence, so that the short codes of a new domain start from a small number
+	Sequence *SequenceConfig `json:"sequence" yaml:"sequence" mapstructure:"sequence"`
+}
@@ -0,0 +1,18 @@
+package configs
+
+const (
+	// GeneratorSequential uses the tddl sequence number as the short ID, the short codes are guessable
+	GeneratorSequential = "sequential"
+	// GeneratorFeistel permutes the tddl sequence number with a keyed feistel network
+	GeneratorFeistel = "feistel"
+	// GeneratorRandom generates random short IDs, and retries if the short ID collides with an existing one
+	GeneratorRandom = "random"
+)
+
+// GeneratorConfig is the short code generator config of turl server
+type GeneratorConfig struct {
+	// Type is the type of short code generator, one of sequential, feistel and random, default is sequential
+	Type string `validate:"omitempty,oneof=sequential feistel random" json:"type" yaml:"type" mapstructure:"type"`
+	// Key is the secret key of the feistel generator, must not be changed once the short URLs are created
+	Key string `validate:"required_if=Type feistel" json:"key" yaml:"key" mapstructure:"key"`
+}
@@ -0,0 +1,18 @@
+package configs
+
+const (
+	// RateLimitByAPIKey limits the write api requests of each API key
+	RateLimitByAPIKey = "api_key"
+	// RateLimitByTenant limits the write api requests of each tenant
+	RateLimitByTenant = "tenant"
+	// RateLimitByIP limits the write api requests of each client ip
+	RateLimitByIP = "ip"
+)
+
+// RateLimitConfig is the token bucket config of rate limiter
+type RateLimitConfig struct {
+	// Rate is the token bucket rate of rate limiter
+	Rate int `validate:"required,gt=0" json:"rate" yaml:"rate" mapstructure:"rate"`
+	// Burst is the token bucket burst of rate limiter
+	Burst int `validate:"required,min=1" json:"burst" yaml:"burst" mapstructure:"burst"`
+}
@@ -10,11 +10,45 @@ import (
 	"github.com/knadh/koanf/v2"
 )
 
-// Global koanf instance. Use "." as the key path delimiter. This can be "/" or any character.
-var k = koanf.New(".")
+const (
+	// dbKey is the key of the database config
+	dbKey = "db"
+	// legacyDBKey is the deprecated key of the database config, which is read as the db key
+	legacyDBKey = "mysql"
+)
+
+// EmbeddedDefaults are the default configs of the embedded mode, so that the embedded server starts
+// with a config file of only `embedded: true`
+var EmbeddedDefaults = map[string]any{
+	"listen":                       "0.0.0.0",
+	"port":                         8080,
+	"domain":                       "http://localhost:8080",
+	"request_timeout":              "5s",
+	"global_rate_limit_key":        "turl_rate_limit",
+	"global_write_rate":            10000,
+	"global_write_burst":           4000,
+	"stand_alone_read_rate":        20000,
+	"stand_alone_read_burst":       1000,
+	"log.writers":                  []string{OutputConsole},
+	"log.format":                   EncoderTypeText,
+	"log.level":                    InfoLevel,
+	"tddl.start_num":               10000,
+	"tddl.step":                    100,
+	"tddl.seq_name":                "turl",
+	"db.driver":                    DriverSQLite,
+	"db.dsn":                       "turl.db",
+	"db.max_conn":                  1,
+	"cache.local_cache.ttl":        "10m",
+	"cache.local_cache.capacity":   1000000,
+	"cache.local_cache.max_memory": 256,
+}
 
 // ReadFile reads a yaml file and returns a ServerConfig.
 func ReadFile(path string, mp map[string]interface{}) (*ServerConfig, error) {
+	// koanf instance of each read, so that the configs of the previous reads are not merged.
+	// Use "." as the key path delimiter. This can be "/" or any character.
+	k := koanf.New(".")
+
 	// Load Yaml config.
 	if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
 		return nil, err
@@ -24,10 +58,32 @@ func ReadFile(path string, mp map[string]interface{}) (*ServerConfig, error) {
 		return nil, err
 	}
 
-	_, _ = fmt.Fprint(os.Stdout, k.Sprint())
+	// the database config of the existing config files is under the deprecated mysql key
+	if k.Exists(legacyDBKey) && !k.Exists(dbKey) {
+		if err := k.MergeAt(k.Cut(legacyDBKey), dbKey); err != nil {
+			return nil, err
+		}
+
+		k.Delete(legacyDBKey)
+	}
+
+	c := k
+	if k.Bool("embedded") {
+		// the configs of the file override the embedded defaults
+		c = koanf.New(".")
+		if err := c.Load(confmap.Provider(EmbeddedDefaults, "."), nil); err != nil {
+			return nil, err
+		}
+
+		if err := c.Merge(k); err != nil {
+			return nil, err
+		}
+	}
+
+	_, _ = fmt.Fprint(os.Stdout, c.Sprint())
 
 	var t ServerConfig
-	if err := k.UnmarshalWithConf("", &t, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+	if err := c.UnmarshalWithConf("", &t, koanf.UnmarshalConf{Tag: "json"}); err != nil {
 		return nil, err
 	}
 
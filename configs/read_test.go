@@ -2,6 +2,8 @@ package configs
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -22,6 +24,13 @@ func TestReadFile(t *testing.T) {
 	require.Equal(t, 4000, c.GlobalWriteBurst)
 	require.Equal(t, 20000, c.StandAloneReadRate)
 	require.Equal(t, 1000, c.StandAloneReadBurst)
+	require.Equal(t, RateLimitByAPIKey, c.RateLimitBy)
+	require.Equal(t, &RateLimitConfig{Rate: 50000, Burst: 20000}, c.TenantRateLimits["bulk-importer"])
+	require.True(t, c.Analytics.Enable)
+	require.Equal(t, time.Second, c.Analytics.FlushInterval)
+	require.Equal(t, GeneratorSequential, c.Generator.Type)
+	require.False(t, c.AllowDuplicates)
+	require.Equal(t, &CanonicalConfig{Enable: true, SortQuery: true, DropParams: []string{"utm_*", "fbclid"}}, c.Canonical)
 }
 
 func TestReadFile_WithConfigMap(t *testing.T) {
@@ -40,3 +49,36 @@ func TestReadFile_WithConfigMap(t *testing.T) {
 	require.Equal(t, 20000, c.StandAloneReadRate)
 	require.Equal(t, 1000, c.StandAloneReadBurst)
 }
+
+func TestReadFile_Embedded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("embedded: true\nport: 9090\n"), 0o600))
+
+	c, err := ReadFile(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, c.Validate())
+
+	require.True(t, c.Embedded)
+	require.Equal(t, 9090, c.Port)
+	require.Equal(t, 5*time.Second, c.RequestTimeout)
+	require.Equal(t, &DBConfig{Driver: DriverSQLite, DSN: "turl.db", MaxConn: 1}, c.DB)
+	require.Nil(t, c.Cache.Redis)
+	require.Equal(t, 10*time.Minute, c.Cache.LocalCache.TTL)
+}
+
+func TestReadFile_legacyDBKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("embedded: true\nmysql:\n  dsn: legacy.db\n"), 0o600))
+
+	c, err := ReadFile(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, c.Validate())
+	require.Equal(t, &DBConfig{Driver: DriverSQLite, DSN: "legacy.db", MaxConn: 1}, c.DB)
+
+	// the db key takes precedence over the deprecated mysql key
+	require.NoError(t, os.WriteFile(path, []byte("embedded: true\ndb:\n  dsn: turl.db\nmysql:\n  dsn: legacy.db\n"), 0o600))
+
+	c, err = ReadFile(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, "turl.db", c.DB.DSN)
+}
@@ -3,6 +3,10 @@ package configs
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"golang.org/x/exp/slices"
@@ -20,35 +24,74 @@ type ServerConfig struct {
 	Debug bool `json:"debug" yaml:"debug" mapstructure:"debug"`
 	// Domain is the domain of redirect url
 	Domain string `validate:"required" json:"domain" yaml:"domain" mapstructure:"domain"`
+	// Domains are the short domains served by turl server, each domain has its own short code namespace,
+	// the Domain may also be listed to set its redirect status and fallback page
+	Domains []*DomainConfig `validate:"omitempty,dive,required" json:"domains" yaml:"domains" mapstructure:"domains"`
+	// Embedded is the embedded mode of turl server for the single instance deployments and tests, the data is
+	// stored in a local SQLite file and cached in the local cache only, so neither MySQL nor Redis is required,
+	// the missing configs are filled with the embedded defaults
+	Embedded bool `json:"embedded" yaml:"embedded" mapstructure:"embedded"`
 	// Readonly is the read-only mode of turl server
 	Readonly bool `json:"readonly" yaml:"readonly" mapstructure:"readonly"`
 	// RequestTimeout is the http server request timeout of turl server
 	RequestTimeout time.Duration `validate:"required" json:"request_timeout" yaml:"request_timeout" mapstructure:"request_timeout"`
-	// GlobalRateLimitKey is the key of global rate limiter
+	// GlobalRateLimitKey is the key prefix of write api rate limiter
 	GlobalRateLimitKey string `validate:"required" json:"global_rate_limit_key" yaml:"global_rate_limit_key" mapstructure:"global_rate_limit_key"`
-	// GlobalWriteRate is the token bucket rate of write api rate limiter
+	// GlobalWriteRate is the token bucket rate of write api rate limiter of each rate limit key
 	GlobalWriteRate int `validate:"required,gt=0" json:"global_write_rate" yaml:"global_write_rate" mapstructure:"global_write_rate"`
-	// GlobalWriteBurst is the token bucket burst of write api rate limiter
+	// GlobalWriteBurst is the token bucket burst of write api rate limiter of each rate limit key
 	GlobalWriteBurst int `validate:"required,min=1" json:"global_write_burst" yaml:"global_write_burst" mapstructure:"global_write_burst"`
+	// RateLimitBy is the rate limit key of write api, one of api_key, tenant and ip, default is api_key
+	RateLimitBy string `validate:"omitempty,oneof=api_key tenant ip" json:"rate_limit_by" yaml:"rate_limit_by" mapstructure:"rate_limit_by"`
+	// TenantRateLimits overrides the write api token bucket of the tenants
+	TenantRateLimits map[string]*RateLimitConfig `validate:"omitempty,dive,required" json:"tenant_rate_limits" yaml:"tenant_rate_limits" mapstructure:"tenant_rate_limits"`
 	// StandAloneReadRate is the token bucket rate of read api rate limiter
 	StandAloneReadRate int `validate:"required,gt=0" json:"stand_alone_read_rate" yaml:"stand_alone_read_rate" mapstructure:"stand_alone_read_rate"`
 	// StandAloneReadBurst is the token bucket burst of read api rate limiter
 	StandAloneReadBurst int `validate:"required,min=1" json:"stand_alone_read_burst" yaml:"stand_alone_read_burst" mapstructure:"stand_alone_read_burst"`
+	// ReservedAliases is the extra reserved words which can not be used as custom alias
+	ReservedAliases []string `json:"reserved_aliases" yaml:"reserved_aliases" mapstructure:"reserved_aliases"`
+	// AllowDuplicates is the default of creating a new short code for the long URL which has been shortened,
+	// instead of returning the existing one, so that the links of the same long URL are tracked and deleted separately
+	AllowDuplicates bool `json:"allow_duplicates" yaml:"allow_duplicates" mapstructure:"allow_duplicates"`
 
 	// Log is the log config of turl server
 	Log *LogConfig `validate:"required" json:"log" yaml:"log" mapstructure:"log"`
 	// TDDL is the tddl config of turl server
 	TDDL *TDDLConfig `validate:"required" json:"tddl" yaml:"tddl" mapstructure:"tddl"`
-	// MySQL is the mysql config of turl server
-	MySQL *MySQLConfig `validate:"required" json:"mysql" yaml:"mysql" mapstructure:"mysql"`
+	// DB is the database config of turl server, MySQL, PostgreSQL or SQLite by the driver,
+	// the deprecated mysql key of the existing config files is read as the db key
+	DB *DBConfig `validate:"required" json:"db" yaml:"db" mapstructure:"db"`
 	// Cache is the cache config of turl server
 	Cache *CacheConfig `validate:"required" json:"cache" yaml:"cache" mapstructure:"cache"`
+	// Analytics is the click analytics config of turl server, analytics is disabled if not set
+	Analytics *AnalyticsConfig `json:"analytics" yaml:"analytics" mapstructure:"analytics"`
+	// Generator is the short code generator config of turl server, the sequential generator is used if not set
+	Generator *GeneratorConfig `json:"generator" yaml:"generator" mapstructure:"generator"`
+	// Canonical is the config of canonicalizing the long URLs before deduplication, nil disables the canonicalization
+	Canonical *CanonicalConfig `json:"canonical" yaml:"canonical" mapstructure:"canonical"`
 }
 
 var (
 	errInvalidOutput = errors.New("log output only support console and file")
 	errNonFilePath   = errors.New("log file path is required when log output contains file")
 	errInvalidFormat = errors.New("log format only support text and json")
+	// errDuplicateDomain is returned when a short domain is listed more than once
+	errDuplicateDomain = errors.New("duplicate short domain")
+	// errFeistelSnowflake is returned since the snowflake IDs are out of the feistel permutation domain
+	errFeistelSnowflake = errors.New("feistel generator does not support snowflake tddl")
+	// errRedisRequired is returned when the redis config is missing out of the embedded mode
+	errRedisRequired = errors.New("redis config is required unless in embedded mode")
+	// errEmbeddedRedis is returned when the embedded mode is configured with redis
+	errEmbeddedRedis = errors.New("embedded mode caches in the local cache only, redis config is not allowed")
+	// errEmbeddedDriver is returned when the embedded mode is configured with a database server
+	errEmbeddedDriver = errors.New("embedded mode only supports the sqlite database driver")
+	// errSnowflakeSequences is returned since the snowflake tddl of each tenant may lease the same worker ID
+	errSnowflakeSequences = errors.New("tenant sequences are not supported by snowflake tddl")
+	// errSequenceRange is returned when a tenant sequence has no bounded range
+	errSequenceRange = errors.New("tenant sequence should have start_num and a greater max_num")
+	// errSequenceOverlap is returned when the ranges of the sequences in the default domain overlap
+	errSequenceOverlap = errors.New("tenant sequence ranges overlap")
 )
 
 // Validate validates the config
@@ -76,5 +119,107 @@ func (c *ServerConfig) Validate() error {
 		return errInvalidFormat
 	}
 
+	hosts := make(map[string]struct{}, len(c.Domains))
+	for _, d := range c.Domains {
+		host := DomainHost(d.Domain)
+		if _, ok := hosts[host]; ok {
+			return fmt.Errorf("%w: %s", errDuplicateDomain, host)
+		}
+
+		hosts[host] = struct{}{}
+	}
+
+	if err := c.validateEmbedded(); err != nil {
+		return err
+	}
+
+	if err := c.validateSequences(); err != nil {
+		return err
+	}
+
+	if c.TDDL.Type == TDDLTypeSnowflake {
+		if c.TDDL.LeaseTTL < time.Second {
+			return errors.New("tddl lease ttl should be greater than 1s")
+		}
+
+		if c.Generator != nil && c.Generator.Type == GeneratorFeistel {
+			return errFeistelSnowflake
+		}
+	}
+
 	return nil
 }
+
+// validateEmbedded validates the database and redis configs against the embedded mode
+func (c *ServerConfig) validateEmbedded() error {
+	if !c.Embedded {
+		if c.Cache.Redis == nil {
+			return errRedisRequired
+		}
+
+		return nil
+	}
+
+	if c.Cache.Redis != nil {
+		return errEmbeddedRedis
+	}
+
+	if c.DB.Driver != DriverSQLite {
+		return errEmbeddedDriver
+	}
+
+	return nil
+}
+
+// validateSequences validates the ranges of the tenant sequences, the short codes of the tenants share
+// the namespace of the default domain, so the range [start_num, max_num) of each tenant sequence should be disjoint
+// from the others and from the default sequence, which starts from TDDL.StartNum without bound.
+func (c *ServerConfig) validateSequences() error {
+	if len(c.TDDL.Sequences) == 0 {
+		return nil
+	}
+
+	if c.TDDL.Type == TDDLTypeSnowflake {
+		return errSnowflakeSequences
+	}
+
+	names := make([]string, 0, len(c.TDDL.Sequences))
+	for name, sc := range c.TDDL.Sequences {
+		if sc.StartNum == 0 || sc.MaxNum <= sc.StartNum {
+			return fmt.Errorf("%w: %s", errSequenceRange, name)
+		}
+
+		names = append(names, name)
+	}
+
+	slices.Sort(names) // report the overlaps in a stable order
+
+	for i, name := range names {
+		sc := c.TDDL.Sequences[name]
+		if sc.MaxNum > c.TDDL.StartNum {
+			return fmt.Errorf("%w: %s and the default sequence", errSequenceOverlap, name)
+		}
+
+		for _, other := range names[i+1:] {
+			if oc := c.TDDL.Sequences[other]; sc.StartNum < oc.MaxNum && oc.StartNum < sc.MaxNum {
+				return fmt.Errorf("%w: %s and %s", errSequenceOverlap, name, other)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DomainHost returns the lower case host of the domain without port,
+// the domain is either a base URL like https://go.example.com or a bare host.
+func DomainHost(domain string) string {
+	if u, err := url.Parse(domain); err == nil && u.Host != "" {
+		domain = u.Host
+	}
+
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+
+	return strings.ToLower(domain)
+}
@@ -28,7 +28,7 @@ func TestServerConfig_Validate(t *testing.T) {
 			SeqName:  "test",
 			StartNum: 10,
 		},
-		MySQL: &MySQLConfig{
+		DB: &DBConfig{
 			DSN:     "test",
 			MaxConn: 10,
 		},
@@ -68,6 +68,117 @@ func TestServerConfig_Validate(t *testing.T) {
 	c.Port = 65535
 	require.NoError(t, c.Validate())
 
+	c.Cache.Redis.Mode = RedisSentinel
+	require.Equal(t, "Key: 'ServerConfig.Cache.Redis.MasterName' Error:Field validation for 'MasterName' failed on the 'required_if' tag", c.Validate().Error())
+	c.Cache.Redis.MasterName = "master"
+	require.NoError(t, c.Validate())
+	c.Cache.Redis.Mode, c.Cache.Redis.DB = RedisCluster, 1
+	require.Equal(t, "Key: 'ServerConfig.Cache.Redis.DB' Error:Field validation for 'DB' failed on the 'excluded_if' tag", c.Validate().Error())
+	c.Cache.Redis.Mode, c.Cache.Redis.MasterName, c.Cache.Redis.DB = "", "", 0
+	c.Cache.Redis.TLS = &TLSConfig{Enable: true, CertFile: "server_config_test.go"}
+	require.Equal(t, "Key: 'ServerConfig.Cache.Redis.TLS.KeyFile' Error:Field validation for 'KeyFile' failed on the 'required_with' tag", c.Validate().Error())
+	c.Cache.Redis.TLS = nil
+	c.Cache.Redis.Breaker = &BreakerConfig{FailureThreshold: -1}
+	require.Equal(t, "Key: 'ServerConfig.Cache.Redis.Breaker.FailureThreshold' Error:Field validation for 'FailureThreshold' failed on the 'min' tag", c.Validate().Error())
+	c.Cache.Redis.Breaker = nil
+	c.Cache.WarmUp = &WarmUpConfig{Enable: true, Source: WarmUpClicks}
+	require.Equal(t, "Key: 'ServerConfig.Cache.WarmUp.Count' Error:Field validation for 'Count' failed on the 'required_if' tag", c.Validate().Error())
+	c.Cache.WarmUp.Count = 1000
+	require.NoError(t, c.Validate())
+	c.Cache.WarmUp = nil
+
+	c.Embedded = true
+	require.ErrorIs(t, c.Validate(), errEmbeddedRedis)
+	redis := c.Cache.Redis
+	c.Cache.Redis = nil
+	require.ErrorIs(t, c.Validate(), errEmbeddedDriver)
+	c.DB.Driver = DriverSQLite
+	require.NoError(t, c.Validate())
+	c.Embedded = false
+	require.ErrorIs(t, c.Validate(), errRedisRequired)
+	c.Cache.Redis = redis
+
+	c.DB.Driver = DriverPostgres
+	require.NoError(t, c.Validate())
+	c.DB.Driver = "oracle"
+	require.Equal(t, "Key: 'ServerConfig.DB.Driver' Error:Field validation for 'Driver' failed on the 'oneof' tag", c.Validate().Error())
+	c.DB.Driver = ""
+
+	c.Cache.LocalCache.Type = LocalCacheLRU
+	require.NoError(t, c.Validate())
+	c.Cache.LocalCache.Type = "ristretto"
+	require.Equal(t, "Key: 'ServerConfig.Cache.LocalCache.Type' Error:Field validation for 'Type' failed on the 'oneof' tag", c.Validate().Error())
+	c.Cache.LocalCache.Type = ""
+
+	c.Canonical = &CanonicalConfig{Enable: true, SortQuery: true, DropParams: []string{"utm_*", "fbclid"}}
+	require.NoError(t, c.Validate())
+	c.Canonical.DropParams = append(c.Canonical.DropParams, "")
+	require.Equal(t, "Key: 'ServerConfig.Canonical.DropParams[2]' Error:Field validation for 'DropParams[2]' failed on the 'required' tag", c.Validate().Error())
+	c.Canonical = nil
+
+	c.RateLimitBy = RateLimitByTenant
+	require.NoError(t, c.Validate())
+	c.RateLimitBy = "user"
+	require.Equal(t, "Key: 'ServerConfig.RateLimitBy' Error:Field validation for 'RateLimitBy' failed on the 'oneof' tag", c.Validate().Error())
+	c.RateLimitBy = ""
+
+	c.TenantRateLimits = map[string]*RateLimitConfig{"tenant": {Rate: 1, Burst: 1}}
+	require.NoError(t, c.Validate())
+	c.TenantRateLimits["tenant"].Burst = 0
+	require.Error(t, c.Validate())
+	c.TenantRateLimits = nil
+
+	c.Generator = &GeneratorConfig{Type: GeneratorRandom}
+	require.NoError(t, c.Validate())
+	c.Generator.Type = GeneratorFeistel
+	require.Equal(t, "Key: 'ServerConfig.Generator.Key' Error:Field validation for 'Key' failed on the 'required_if' tag", c.Validate().Error())
+	c.Generator.Key = "secret"
+	require.NoError(t, c.Validate())
+	c.Generator.Type = "uuid"
+	require.Error(t, c.Validate())
+	c.Generator = nil
+
+	c.TDDL.Type = TDDLTypeSnowflake
+	require.Equal(t, "Key: 'ServerConfig.TDDL.LeaseTTL' Error:Field validation for 'LeaseTTL' failed on the 'required_if' tag", c.Validate().Error())
+	c.TDDL.LeaseTTL = time.Millisecond
+	require.Error(t, c.Validate())
+	c.TDDL.LeaseTTL = 30 * time.Second
+	require.NoError(t, c.Validate())
+	c.Generator = &GeneratorConfig{Type: GeneratorFeistel, Key: "secret"}
+	require.ErrorIs(t, c.Validate(), errFeistelSnowflake)
+	c.Generator, c.TDDL.Type = nil, ""
+
+	c.TDDL.StartNum = 1000
+	c.TDDL.Sequences = map[string]*SequenceConfig{"tenant-a": {StartNum: 1}}
+	require.ErrorIs(t, c.Validate(), errSequenceRange)
+	c.TDDL.Sequences["tenant-a"].MaxNum = 100
+	require.NoError(t, c.Validate())
+	c.TDDL.Sequences["tenant-b"] = &SequenceConfig{StartNum: 50, MaxNum: 200}
+	require.ErrorIs(t, c.Validate(), errSequenceOverlap)
+	c.TDDL.Sequences["tenant-b"].StartNum = 100
+	require.NoError(t, c.Validate())
+	c.TDDL.Sequences["tenant-b"].MaxNum = 2000
+	require.ErrorIs(t, c.Validate(), errSequenceOverlap)
+	c.TDDL.Sequences["tenant-b"].MaxNum = 1000
+	require.NoError(t, c.Validate())
+	c.TDDL.Type, c.TDDL.LeaseTTL = TDDLTypeSnowflake, 30*time.Second
+	require.ErrorIs(t, c.Validate(), errSnowflakeSequences)
+	c.TDDL.Type, c.TDDL.LeaseTTL, c.TDDL.Sequences, c.TDDL.StartNum = "", 0, nil, 10
+
+	c.TDDL.PrefetchThreshold = 1.5
+	require.Equal(t, "Key: 'ServerConfig.TDDL.PrefetchThreshold' Error:Field validation for 'PrefetchThreshold' failed on the 'lte' tag", c.Validate().Error())
+	c.TDDL.PrefetchThreshold = 0.5
+	require.NoError(t, c.Validate())
+	c.TDDL.PrefetchThreshold = 0
+
+	c.Domains = []*DomainConfig{{Domain: "https://go.example.com"}, {Domain: "https://GO.example.com/"}}
+	require.ErrorIs(t, c.Validate(), errDuplicateDomain)
+	c.Domains[1].Domain = "https://s.example.com"
+	require.NoError(t, c.Validate())
+	c.Domains[1].RedirectStatus = 200
+	require.Error(t, c.Validate())
+	c.Domains = nil
+
 	c.RequestTimeout = time.Millisecond
 	require.Error(t, c.Validate())
 }
@@ -1,11 +1,53 @@
 package configs
 
+import "time"
+
+const (
+	// TDDLTypeSequence allocates the sequence numbers in segments from the sequences table
+	TDDLTypeSequence = "sequence"
+	// TDDLTypeSnowflake generates the time-based sequence numbers with a leased worker ID
+	TDDLTypeSnowflake = "snowflake"
+)
+
 // TDDLConfig is the configuration for tddl
 type TDDLConfig struct {
+	// Type is the type of tddl, one of sequence and snowflake, default is sequence
+	Type string `validate:"omitempty,oneof=sequence snowflake" json:"type" yaml:"type" mapstructure:"type"`
 	// Step is the step of the sequence
 	Step uint64 `json:"step" yaml:"step" mapstructure:"step"`
-	// SeqName is the name of the sequence
+	// SeqName is the name of the sequence, the snowflake worker ID leases are named after it
 	SeqName string `json:"seq_name" yaml:"seq_name" mapstructure:"seq_name"`
 	// StartNum is the start number of the sequence
 	StartNum uint64 `json:"start_num" yaml:"start_num" mapstructure:"start_num"`
+	// MaxStep is the max step of the sequence, the step adapts between Step and MaxStep to the consumption rate,
+	// the step does not adapt if MaxStep is not greater than Step
+	MaxStep uint64 `json:"max_step" yaml:"max_step" mapstructure:"max_step"`
+	// SegmentDuration is the expected duration to consume a segment of the sequence, default is 15m,
+	// the step doubles if a segment is consumed faster, and halves if it takes more than twice of the duration
+	SegmentDuration time.Duration `json:"segment_duration" yaml:"segment_duration" mapstructure:"segment_duration"`
+	// PrefetchThreshold is the consumed ratio of the current segment to fetch the next segment in background, default is 0.1
+	PrefetchThreshold float64 `validate:"omitempty,gt=0,lte=1" json:"prefetch_threshold" yaml:"prefetch_threshold" mapstructure:"prefetch_threshold"`
+	// LeaseTTL is the ttl of the snowflake worker ID lease, the lease is renewed every third of the ttl,
+	// the snowflake tddl keeps generating sequence numbers without the database until the lease expires
+	LeaseTTL time.Duration `validate:"required_if=Type snowflake" json:"lease_ttl" yaml:"lease_ttl" mapstructure:"lease_ttl"`
+	// Sequences overrides the step and start number of the named sequences, e.g. the sequences of the tenants,
+	// the named sequences are created on first use and closed after IdleTimeout without use.
+	// The tenants' short codes share the namespace of the default domain, so each tenant sequence should have
+	// a range [StartNum, MaxNum) disjoint from the others and below the StartNum of the default sequence
+	Sequences map[string]*SequenceConfig `validate:"omitempty,dive,required" json:"sequences" yaml:"sequences" mapstructure:"sequences"`
+	// IdleTimeout is the idle duration to close the named sequences, default is 1h
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout" mapstructure:"idle_timeout"`
+}
+
+// SequenceConfig is the configuration of a named sequence, the zero fields inherit from the TDDLConfig
+type SequenceConfig struct {
+	// Step is the step of the sequence
+	Step uint64 `json:"step" yaml:"step" mapstructure:"step"`
+	// StartNum is the start number of the sequence
+	StartNum uint64 `json:"start_num" yaml:"start_num" mapstructure:"start_num"`
+	// MaxStep is the max step of the sequence
+	MaxStep uint64 `json:"max_step" yaml:"max_step" mapstructure:"max_step"`
+	// MaxNum is the exclusive upper bound of the sequence numbers, zero means unbounded,
+	// the sequence fails to generate once the bound is reached
+	MaxNum uint64 `json:"max_num" yaml:"max_num" mapstructure:"max_num"`
 }
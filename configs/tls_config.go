@@ -0,0 +1,16 @@
+package configs
+
+// TLSConfig is the TLS config of the client connections
+type TLSConfig struct {
+	// Enable is whether to connect with TLS
+	Enable bool `json:"enable" yaml:"enable" mapstructure:"enable"`
+	// CAFile is the CA certificate file to verify the server, the system CA pool is used if empty
+	CAFile string `validate:"omitempty,file" json:"ca_file" yaml:"ca_file" mapstructure:"ca_file"`
+	// CertFile and KeyFile are the client certificate and key files, required by the servers verifying the clients
+	CertFile string `validate:"required_with=KeyFile,omitempty,file" json:"cert_file" yaml:"cert_file" mapstructure:"cert_file"`
+	KeyFile  string `validate:"required_with=CertFile,omitempty,file" json:"key_file" yaml:"key_file" mapstructure:"key_file"`
+	// ServerName is the server name to verify the server certificate, default is the host of the address
+	ServerName string `json:"server_name" yaml:"server_name" mapstructure:"server_name"`
+	// InsecureSkipVerify skips verifying the server certificate, only for testing
+	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify" mapstructure:"insecure_skip_verify"`
+}
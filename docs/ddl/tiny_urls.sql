@@ -1,17 +1,26 @@
 -- auto-generated definition
 create table tiny_urls
 (
-    id         bigint unsigned auto_increment
+    id            bigint unsigned auto_increment
         primary key,
-    created_at datetime(3)  null,
-    updated_at datetime(3)  null,
-    deleted_at datetime(3)  null,
-    long_url   varchar(500) not null,
-    short      bigint       not null,
-    constraint idx_tiny_urls_long_url
-        unique (long_url),
-    constraint idx_tiny_urls_short
-        unique (short)
+    created_at    datetime(3)             null,
+    updated_at    datetime(3)             null,
+    deleted_at    datetime(3)             null,
+    domain        varchar(128) default '' not null,
+    tenant        varchar(64)  default '' not null,
+    long_url      text                    not null,
+    long_url_hash char(64)                null,
+    dedup_key     bigint       default 0  not null,
+    short         bigint                  not null,
+    alias         varchar(64)             null,
+    expires_at    datetime(3)             null,
+    redirect_mode smallint     default 0  not null,
+    constraint idx_domain_alias
+        unique (domain, alias),
+    constraint idx_domain_short
+        unique (domain, short),
+    constraint idx_domain_tenant_long_url_dedup
+        unique (domain, tenant, long_url_hash, dedup_key)
 );
 
 create index idx_tiny_urls_deleted_at
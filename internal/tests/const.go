@@ -1,6 +1,9 @@
 package tests
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/beihai0xff/turl/configs"
@@ -9,6 +12,25 @@ import (
 // DSN is the data source name of the MySQL database
 const DSN = "root:test123@tcp(127.0.0.1:3306)/turl?charset=utf8mb4&parseTime=True&loc=Local"
 
+// PostgresDSN is the data source name of the PostgreSQL database
+const PostgresDSN = "host=127.0.0.1 port=5432 user=postgres password=test123 dbname=turl sslmode=disable"
+
+// DBConfig is the database config of the tests, the driver is set by the TURL_TEST_DB_DRIVER environment variable,
+// so that the test suite runs against MySQL, PostgreSQL or a SQLite file of each test binary
+var DBConfig = dbConfig(os.Getenv("TURL_TEST_DB_DRIVER"))
+
+func dbConfig(driver string) *configs.DBConfig {
+	switch driver {
+	case configs.DriverPostgres:
+		return &configs.DBConfig{Driver: driver, DSN: PostgresDSN, MaxConn: 25}
+	case configs.DriverSQLite:
+		dsn := filepath.Join(os.TempDir(), fmt.Sprintf("turl_test_%d.db", os.Getpid()))
+		return &configs.DBConfig{Driver: driver, DSN: dsn, MaxConn: 1}
+	}
+
+	return &configs.DBConfig{Driver: configs.DriverMySQL, DSN: DSN, MaxConn: 25}
+}
+
 // RedisAddr is the address of the redis server
 var RedisAddr = []string{"127.0.0.1:6379"}
 
@@ -20,10 +42,7 @@ var GlobalConfig = &configs.ServerConfig{
 		StartNum: 10000,
 		SeqName:  "tiny_url",
 	},
-	MySQL: &configs.MySQLConfig{
-		DSN:     DSN,
-		MaxConn: 25,
-	},
+	DB: DBConfig,
 	Cache: &configs.CacheConfig{
 		Redis: &configs.RedisConfig{
 			Addr:        RedisAddr,
@@ -38,3 +57,38 @@ var GlobalConfig = &configs.ServerConfig{
 		},
 	},
 }
+
+// EmbeddedConfig returns the config of an embedded server which stores in a SQLite file of the dir,
+// so that a full service runs without MySQL and Redis
+func EmbeddedConfig(dir string) *configs.ServerConfig {
+	return &configs.ServerConfig{
+		Listen:              "localhost",
+		Port:                8080,
+		Domain:              "http://localhost:8080",
+		Embedded:            true,
+		RequestTimeout:      5 * time.Second,
+		GlobalRateLimitKey:  "turl_rate_limit",
+		GlobalWriteRate:     10000,
+		GlobalWriteBurst:    4000,
+		StandAloneReadRate:  20000,
+		StandAloneReadBurst: 1000,
+		Log:                 &configs.LogConfig{Writers: []string{configs.OutputConsole}, Format: configs.EncoderTypeText},
+		TDDL: &configs.TDDLConfig{
+			Step:     100,
+			StartNum: 10000,
+			SeqName:  "tiny_url",
+		},
+		DB: &configs.DBConfig{
+			Driver:  configs.DriverSQLite,
+			DSN:     filepath.Join(dir, "turl.db"),
+			MaxConn: 1,
+		},
+		Cache: &configs.CacheConfig{
+			LocalCache: &configs.LocalCacheConfig{
+				TTL:       10 * time.Minute,
+				Capacity:  1000,
+				MaxMemory: 16,
+			},
+		},
+	}
+}
@@ -1,12 +1,11 @@
 package tests
 
 import (
-	"github.com/beihai0xff/turl/configs"
-	"github.com/beihai0xff/turl/pkg/db/mysql"
+	"github.com/beihai0xff/turl/pkg/db"
 )
 
 func CreateTable(t any) error {
-	db, err := mysql.New(&configs.MySQLConfig{DSN: DSN})
+	db, err := db.New(DBConfig)
 	if err != nil {
 		return err
 	}
@@ -15,7 +14,7 @@ func CreateTable(t any) error {
 }
 
 func DropTable(t any) error {
-	db, err := mysql.New(&configs.MySQLConfig{DSN: DSN})
+	db, err := db.New(DBConfig)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,277 @@
+// Package analytics provides the click analytics of short URLs,
+// click events are buffered in a bounded in-process queue and flushed into the database in batches.
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+const (
+	// maxFieldLength is the max length of the referrer and user agent stored in the database
+	maxFieldLength = 500
+	// flushTimeout is the timeout of flushing one batch of click events
+	flushTimeout = 5 * time.Second
+	// dropLogInterval log once every dropLogInterval dropped events, avoid flooding the log
+	dropLogInterval = 1000
+)
+
+// Ensuring that *analytics implements the Analytics interface
+var _ Analytics = (*analytics)(nil)
+
+// Analytics is the interface of click analytics
+type Analytics interface {
+	// Record records a click event asynchronously, it never blocks the caller,
+	// the event is dropped if the queue is full
+	Record(e *Event)
+	// Stats returns the click statistics of the short code, the daily counts start from since
+	Stats(ctx context.Context, short string, since time.Time) (*Stats, error)
+	// Top returns at most limit short codes most clicked since the time, in descending click count order
+	Top(ctx context.Context, since time.Time, limit int) ([]string, error)
+	// Close flushes the pending click events and stops the flusher
+	Close() error
+}
+
+// Event is a click event of the short URL
+type Event struct {
+	// Short is the short code, either a generated short code or a custom alias,
+	// the short codes of the domains other than the default one are prefixed by the domain host, e.g. host/code
+	Short string
+	// ClickedAt is the time of the click
+	ClickedAt time.Time
+	// Referrer is the referrer of the request
+	Referrer string
+	// UserAgent is the user agent of the client
+	UserAgent string
+	// IP is the client ip address
+	IP string
+}
+
+// Click is the table of click events
+type Click struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	Short     string    `gorm:"type:VARCHAR(255);not null;index:idx_short_clicked_at,priority:1" json:"short"`
+	ClickedAt time.Time `gorm:"not null;index:idx_short_clicked_at,priority:2" json:"clicked_at"`
+	Referrer  string    `gorm:"type:VARCHAR(500)" json:"referrer"`
+	UserAgent string    `gorm:"type:VARCHAR(500)" json:"user_agent"`
+	IP        string    `gorm:"type:VARCHAR(45)" json:"ip"`
+}
+
+// TableName returns the table name of the Click model
+func (Click) TableName() string {
+	return "clicks"
+}
+
+// Stats is the click statistics of a short code
+type Stats struct {
+	// Total is the total clicks of the short code
+	Total int64
+	// Daily is the daily clicks of the short code, ordered by date
+	Daily []DailyClicks
+}
+
+// DailyClicks is the click count of a day
+type DailyClicks struct {
+	// Date is the day of the clicks, formatted as 2006-01-02
+	Date string
+	// Clicks is the click count of the day
+	Clicks int64
+}
+
+type analytics struct {
+	db *gorm.DB
+
+	batchSize     int
+	flushInterval time.Duration
+
+	queue   chan *Event
+	dropped atomic.Uint64
+
+	wg        sync.WaitGroup
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a new Analytics, and starts the background flusher
+func New(db *gorm.DB, c *configs.AnalyticsConfig) (Analytics, error) {
+	return newAnalytics(db, c)
+}
+
+// NewReadOnly returns a new Analytics which only queries the click statistics, e.g. for the read-only instances,
+// the click events are dropped, and neither the table is migrated nor the flusher is started.
+func NewReadOnly(db *gorm.DB) Analytics {
+	return &readOnly{analytics: &analytics{db: db}}
+}
+
+func newAnalytics(db *gorm.DB, c *configs.AnalyticsConfig) (*analytics, error) {
+	if err := db.AutoMigrate(&Click{}); err != nil {
+		return nil, err
+	}
+
+	a := &analytics{
+		db:            db,
+		batchSize:     c.BatchSize,
+		flushInterval: c.FlushInterval,
+		queue:         make(chan *Event, c.QueueSize),
+		stop:          make(chan struct{}),
+	}
+
+	a.wg.Add(1)
+
+	go a.flusher()
+
+	return a, nil
+}
+
+// Record records a click event asynchronously, the event is dropped if the queue is full
+func (a *analytics) Record(e *Event) {
+	select {
+	case a.queue <- e:
+	default:
+		if dropped := a.dropped.Add(1); dropped%dropLogInterval == 1 {
+			slog.Warn("analytics queue is full, drop click events", slog.Uint64("dropped", dropped))
+		}
+	}
+}
+
+// flusher consumes the click events and flushes them into the database in batches,
+// a batch is flushed when it is full or the flush interval elapses.
+func (a *analytics) flusher() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Click, 0, a.batchSize)
+
+	for {
+		select {
+		case e := <-a.queue:
+			if batch = append(batch, newClick(e)); len(batch) >= a.batchSize {
+				batch = a.flush(batch)
+			}
+		case <-ticker.C:
+			batch = a.flush(batch)
+		case <-a.stop:
+			// drain the pending events before exit
+			for {
+				select {
+				case e := <-a.queue:
+					if batch = append(batch, newClick(e)); len(batch) >= a.batchSize {
+						batch = a.flush(batch)
+					}
+				default:
+					a.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush inserts the batch into the database with a multi-row statement,
+// and returns the emptied batch for reuse. The batch is dropped if failed to insert.
+func (a *analytics) flush(batch []*Click) []*Click {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := a.db.WithContext(ctx).Create(&batch).Error; err != nil {
+		slog.Error("failed to flush click events", slog.Int("count", len(batch)), slog.Any("error", err))
+	}
+
+	return batch[:0]
+}
+
+// Stats returns the click statistics of the short code, the daily counts start from since
+func (a *analytics) Stats(ctx context.Context, short string, since time.Time) (*Stats, error) {
+	var s Stats
+
+	if err := a.db.WithContext(ctx).Model(&Click{}).Where("short = ?", short).Count(&s.Total).Error; err != nil {
+		return nil, err
+	}
+
+	err := a.db.WithContext(ctx).Model(&Click{}).
+		Select("DATE(clicked_at) AS date, COUNT(*) AS clicks").
+		Where("short = ? AND clicked_at >= ?", short, since).
+		Group("DATE(clicked_at)").Order("date").
+		Scan(&s.Daily).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range s.Daily { // the drivers may return the date as a datetime or RFC3339 string
+		if len(s.Daily[i].Date) > len(time.DateOnly) {
+			s.Daily[i].Date = s.Daily[i].Date[:len(time.DateOnly)]
+		}
+	}
+
+	return &s, nil
+}
+
+// Top returns at most limit short codes most clicked since the time, in descending click count order
+func (a *analytics) Top(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	var shorts []string
+
+	err := a.db.WithContext(ctx).Model(&Click{}).
+		Where("clicked_at >= ?", since).
+		Group("short").Order("COUNT(*) DESC").Limit(limit).
+		Pluck("short", &shorts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return shorts, nil
+}
+
+// Close flushes the pending click events and stops the flusher, it is safe to be called more than once
+func (a *analytics) Close() error {
+	a.closeOnce.Do(func() { close(a.stop) })
+	a.wg.Wait()
+
+	return nil
+}
+
+// readOnly is the Analytics without writer
+type readOnly struct {
+	*analytics
+}
+
+// Record drops the click event
+func (*readOnly) Record(*Event) {}
+
+// Close is a no-op, since there is no flusher
+func (*readOnly) Close() error {
+	return nil
+}
+
+// newClick converts the click event to the table row
+func newClick(e *Event) *Click {
+	return &Click{
+		Short:     e.Short,
+		ClickedAt: e.ClickedAt,
+		Referrer:  truncate(e.Referrer, maxFieldLength),
+		UserAgent: truncate(e.UserAgent, maxFieldLength),
+		IP:        e.IP,
+	}
+}
+
+// truncate truncates the string to at most n bytes, and drops the broken trailing rune
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+
+	return s
+}
@@ -0,0 +1,166 @@
+package analytics
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/internal/tests"
+	"github.com/beihai0xff/turl/pkg/db"
+)
+
+func TestMain(m *testing.M) {
+	tests.CreateTable(&Click{})
+
+	code := m.Run()
+	tests.DropTable(&Click{})
+
+	os.Exit(code)
+}
+
+func newTestAnalytics(t *testing.T, c *configs.AnalyticsConfig) *analytics {
+	t.Helper()
+
+	db, err := db.New(tests.GlobalConfig.DB)
+	require.NoError(t, err)
+
+	a, err := newAnalytics(db, c)
+	require.NoError(t, err)
+
+	return a
+}
+
+func TestNew(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	a, err := New(db, &configs.AnalyticsConfig{QueueSize: 10, BatchSize: 10, FlushInterval: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, a.Close())
+	// closing again is a no-op
+	require.NoError(t, a.Close())
+}
+
+func TestNewReadOnly(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+	ctx := context.Background()
+
+	a := NewReadOnly(db)
+	a.Record(&Event{Short: "ReadOnly", ClickedAt: time.Now()})
+	require.NoError(t, a.Close())
+
+	stats, err := a.Stats(ctx, "ReadOnly", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, stats.Total)
+}
+
+func Test_analytics_Record(t *testing.T) {
+	a := newTestAnalytics(t, &configs.AnalyticsConfig{QueueSize: 100, BatchSize: 3, FlushInterval: time.Hour})
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		a.Record(&Event{Short: "Record", ClickedAt: now, Referrer: "https://example.com", UserAgent: "test", IP: "127.0.0.1"})
+	}
+
+	// the first batch is flushed when it is full
+	require.Eventually(t, func() bool {
+		s, err := a.Stats(ctx, "Record", now.Add(-time.Hour))
+		return err == nil && s.Total == 3
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the pending events are flushed when closed
+	require.NoError(t, a.Close())
+
+	s, err := a.Stats(ctx, "Record", now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), s.Total)
+	require.Len(t, s.Daily, 1)
+	require.Equal(t, now.Format(time.DateOnly), s.Daily[0].Date)
+	require.Equal(t, int64(5), s.Daily[0].Clicks)
+}
+
+func Test_analytics_Record_FlushInterval(t *testing.T) {
+	a := newTestAnalytics(t, &configs.AnalyticsConfig{QueueSize: 100, BatchSize: 100, FlushInterval: 50 * time.Millisecond})
+	t.Cleanup(func() { a.Close() })
+
+	a.Record(&Event{Short: "FlushInterval", ClickedAt: time.Now()})
+
+	require.Eventually(t, func() bool {
+		s, err := a.Stats(context.Background(), "FlushInterval", time.Now().Add(-time.Hour))
+		return err == nil && s.Total == 1
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func Test_analytics_Record_QueueFull(t *testing.T) {
+	// a stopped flusher never consumes the queue
+	a := &analytics{queue: make(chan *Event, 1), stop: make(chan struct{})}
+
+	a.Record(&Event{Short: "QueueFull"})
+	a.Record(&Event{Short: "QueueFull"})
+	a.Record(&Event{Short: "QueueFull"})
+
+	require.Len(t, a.queue, 1)
+	require.Equal(t, uint64(2), a.dropped.Load())
+}
+
+func Test_analytics_Stats(t *testing.T) {
+	a := newTestAnalytics(t, &configs.AnalyticsConfig{QueueSize: 100, BatchSize: 100, FlushInterval: time.Hour})
+	ctx := context.Background()
+	today := time.Now().Truncate(time.Hour)
+
+	a.Record(&Event{Short: "Stats", ClickedAt: today.AddDate(0, 0, -10)})
+	a.Record(&Event{Short: "Stats", ClickedAt: today.AddDate(0, 0, -1)})
+	a.Record(&Event{Short: "Stats", ClickedAt: today.AddDate(0, 0, -1)})
+	a.Record(&Event{Short: "Stats", ClickedAt: today})
+	a.Record(&Event{Short: "Other", ClickedAt: today})
+	require.NoError(t, a.Close())
+
+	s, err := a.Stats(ctx, "Stats", today.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), s.Total)
+	require.Equal(t, []DailyClicks{
+		{Date: today.AddDate(0, 0, -1).Format(time.DateOnly), Clicks: 2},
+		{Date: today.Format(time.DateOnly), Clicks: 1},
+	}, s.Daily)
+
+	s, err = a.Stats(ctx, "NotExist", today.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	require.Zero(t, s.Total)
+	require.Empty(t, s.Daily)
+}
+
+func Test_analytics_Top(t *testing.T) {
+	a := newTestAnalytics(t, &configs.AnalyticsConfig{QueueSize: 100, BatchSize: 100, FlushInterval: time.Hour})
+	ctx := context.Background()
+	// the clicks of the other tests are earlier than since
+	since := time.Now().AddDate(0, 0, 100).Truncate(time.Hour)
+
+	for i, short := range []string{"Top1", "Top2", "Top1", "Top3", "Top1", "Top2"} {
+		a.Record(&Event{Short: short, ClickedAt: since.Add(time.Duration(i) * time.Minute)})
+	}
+
+	a.Record(&Event{Short: "Top3", ClickedAt: since.Add(-time.Hour)})
+	a.Record(&Event{Short: "Top3", ClickedAt: since.Add(-time.Hour)})
+	require.NoError(t, a.Close())
+
+	got, err := a.Top(ctx, since, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Top1", "Top2"}, got)
+
+	got, err = a.Top(ctx, since, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Top1", "Top2", "Top3"}, got)
+}
+
+func Test_truncate(t *testing.T) {
+	require.Equal(t, "abc", truncate("abc", 5))
+	require.Equal(t, "abcde", truncate("abcdefg", 5))
+	// the broken trailing rune is dropped
+	require.Equal(t, "ab", truncate("ab你好", 4))
+	require.Len(t, truncate(strings.Repeat("a", 1000), maxFieldLength), maxFieldLength)
+}
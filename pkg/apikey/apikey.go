@@ -0,0 +1,144 @@
+// Package apikey provides the API keys of the management API, only the hashes of the keys are stored.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// keyPrefix is the prefix of the API keys, makes the keys easy to recognize
+	keyPrefix = "turl_"
+	// keyBytes is the number of random bytes of the API keys
+	keyBytes = 32
+	// MaxTenantLength is the max length of the tenant name
+	MaxTenantLength = 64
+)
+
+var (
+	// ErrInvalidKey is returned when the API key does not exist or has been revoked
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrInvalidTenant is returned when the tenant name is empty or too long
+	ErrInvalidTenant = errors.New("tenant should not be empty and no more than 64 characters")
+)
+
+// Ensuring that *store implements the Store interface
+var _ Store = (*store)(nil)
+
+// Authenticator authenticates the API keys
+type Authenticator interface {
+	// Authenticate returns the API key record of the key, returns ErrInvalidKey if the key is invalid
+	Authenticate(ctx context.Context, key string) (*APIKey, error)
+}
+
+// Store is the interface of API key storage
+type Store interface {
+	Authenticator
+	// Create creates a new API key of the tenant, the plaintext key is only returned here
+	Create(ctx context.Context, name, tenant string, admin bool) (string, *APIKey, error)
+	// Revoke revokes the API key by id
+	Revoke(ctx context.Context, id uint) error
+}
+
+// APIKey is the table of API keys
+type APIKey struct {
+	gorm.Model
+	// Name is the description of the API key
+	Name string `gorm:"type:VARCHAR(255)" json:"name"`
+	// Tenant is the tenant which owns the API key and the short URLs created by it
+	Tenant string `gorm:"type:VARCHAR(64);not null" json:"tenant"`
+	// Admin is whether the API key can act across tenants
+	Admin bool `gorm:"not null;default:false" json:"admin"`
+	// KeyHash is the hex encoded SHA-256 hash of the API key
+	KeyHash string `gorm:"type:CHAR(64);not null;uniqueIndex" json:"-"`
+}
+
+// TableName returns the table name of the APIKey model
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+// New returns a new API key Store
+func New(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+// Authenticate returns the API key record of the key, returns ErrInvalidKey if the key is invalid
+func (s *store) Authenticate(ctx context.Context, key string) (*APIKey, error) {
+	var k APIKey
+
+	err := s.db.WithContext(ctx).Where("key_hash = ?", hash(key)).Take(&k).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidKey
+		}
+
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// Create creates a new API key of the tenant, the plaintext key is only returned here
+func (s *store) Create(ctx context.Context, name, tenant string, admin bool) (string, *APIKey, error) {
+	if tenant == "" || len(tenant) > MaxTenantLength {
+		return "", nil, ErrInvalidTenant
+	}
+
+	b := make([]byte, keyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	key := keyPrefix + hex.EncodeToString(b)
+	k := APIKey{Name: name, Tenant: tenant, Admin: admin, KeyHash: hash(key)}
+
+	if err := s.db.WithContext(ctx).Create(&k).Error; err != nil {
+		return "", nil, err
+	}
+
+	return key, &k, nil
+}
+
+// Revoke revokes the API key by id
+func (s *store) Revoke(ctx context.Context, id uint) error {
+	res := s.db.WithContext(ctx).Delete(&APIKey{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// hash returns the hex encoded SHA-256 hash of the key, the keys are random with high entropy,
+// so a fast hash is enough and allows looking up the key by its hash.
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// NewContext returns a new context carrying the API key of the caller
+func NewContext(ctx context.Context, k *APIKey) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the API key of the caller carried by the context
+func FromContext(ctx context.Context) (*APIKey, bool) {
+	k, ok := ctx.Value(contextKey{}).(*APIKey)
+	return k, ok
+}
@@ -0,0 +1,65 @@
+package apikey
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/internal/tests"
+	"github.com/beihai0xff/turl/pkg/db"
+)
+
+func TestMain(m *testing.M) {
+	tests.CreateTable(&APIKey{})
+
+	code := m.Run()
+	tests.DropTable(&APIKey{})
+
+	os.Exit(code)
+}
+
+func Test_store(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+	s, ctx := New(db), context.Background()
+
+	key, created, err := s.Create(ctx, "test", "tenant-a", true)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key, keyPrefix))
+	require.Equal(t, hash(key), created.KeyHash)
+
+	got, err := s.Authenticate(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "tenant-a", got.Tenant)
+	require.True(t, got.Admin)
+
+	_, err = s.Authenticate(ctx, key+"x")
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	require.NoError(t, s.Revoke(ctx, created.ID))
+	_, err = s.Authenticate(ctx, key)
+	require.ErrorIs(t, err, ErrInvalidKey)
+	require.ErrorIs(t, s.Revoke(ctx, created.ID), gorm.ErrRecordNotFound)
+
+	_, _, err = s.Create(ctx, "test", "", false)
+	require.ErrorIs(t, err, ErrInvalidTenant)
+}
+
+func Test_hash(t *testing.T) {
+	require.Len(t, hash("key"), 64)
+	require.Equal(t, hash("key"), hash("key"))
+	require.NotEqual(t, hash("key"), hash("key2"))
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	require.False(t, ok)
+
+	k := &APIKey{Tenant: "tenant-a"}
+	got, ok := FromContext(NewContext(context.Background(), k))
+	require.True(t, ok)
+	require.Same(t, k, got)
+}
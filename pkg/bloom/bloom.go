@@ -0,0 +1,85 @@
+// Package bloom provides a concurrent bloom filter, which reports whether an item may exist in a set
+// without false negatives.
+package bloom
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+	"sync/atomic"
+)
+
+// ErrInvalidParams is returned when the capacity or the false positive rate of the filter is invalid
+var ErrInvalidParams = errors.New("bloom: capacity should be positive and false positive rate should be in (0, 1)")
+
+// Filter is a bloom filter, it is safe for concurrent use.
+type Filter struct {
+	bits []atomic.Uint64
+	// m is the number of bits
+	m uint64
+	// k is the number of hash functions
+	k uint64
+
+	seed  maphash.Seed
+	count atomic.Uint64
+}
+
+// New creates a bloom filter sized for n items with the false positive rate p.
+func New(n uint64, p float64) (*Filter, error) {
+	if n == 0 || p <= 0 || p >= 1 {
+		return nil, ErrInvalidParams
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &Filter{
+		bits: make([]atomic.Uint64, (m+63)/64), //nolint:mnd
+		m:    m,
+		k:    k,
+		seed: maphash.MakeSeed(),
+	}, nil
+}
+
+// Add adds the item to the filter
+func (f *Filter) Add(item []byte) {
+	h1, h2 := f.hash(item)
+	for i := range f.k {
+		pos := (h1 + i*h2) % f.m
+		word, mask := &f.bits[pos/64], uint64(1)<<(pos%64)
+
+		for {
+			old := word.Load()
+			if old&mask != 0 || word.CompareAndSwap(old, old|mask) {
+				break
+			}
+		}
+	}
+
+	f.count.Add(1)
+}
+
+// Test reports whether the item may be in the filter, false means the item is definitely not added
+func (f *Filter) Test(item []byte) bool {
+	h1, h2 := f.hash(item)
+	for i := range f.k {
+		pos := (h1 + i*h2) % f.m
+		if f.bits[pos/64].Load()&(uint64(1)<<(pos%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Count returns the number of items added, including the duplicated ones
+func (f *Filter) Count() uint64 {
+	return f.count.Load()
+}
+
+// hash returns the two hashes of the item, the k hashes are derived by double hashing
+func (f *Filter) hash(item []byte) (uint64, uint64) {
+	h := maphash.Bytes(f.seed, item)
+	// the second hash is odd, so that it never degenerates to zero
+	return h, (h>>32 | h<<32) | 1 //nolint:mnd
+}
@@ -0,0 +1,62 @@
+package bloom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	for _, v := range []struct {
+		n uint64
+		p float64
+	}{{0, 0.01}, {100, 0}, {100, 1}, {100, -0.1}} {
+		_, err := New(v.n, v.p)
+		require.ErrorIs(t, err, ErrInvalidParams)
+	}
+
+	f, err := New(1000, 0.01)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9586), f.m)
+	require.Equal(t, uint64(7), f.k)
+}
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+
+	f, err := New(n, 0.01)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := i; j < n; j += 4 {
+				f.Add([]byte(strconv.Itoa(j)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, uint64(n), f.Count())
+
+	// no false negatives
+	for i := range n {
+		require.True(t, f.Test([]byte(strconv.Itoa(i))))
+	}
+
+	// the false positive rate is around the expected one
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+
+	require.Less(t, fp, n/50)
+}
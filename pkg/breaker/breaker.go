@@ -0,0 +1,165 @@
+// Package breaker provides a circuit breaker, which stops calling an unhealthy dependency for a while,
+// so that the callers fall back immediately instead of waiting for the timeouts.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailureThreshold is the default number of the consecutive failures to open the circuit
+	DefaultFailureThreshold = 5
+	// DefaultOpenTimeout is the default duration the circuit stays open before probing the dependency
+	DefaultOpenTimeout = 10 * time.Second
+)
+
+// ErrOpen is returned by the callers when the circuit is open
+var ErrOpen = errors.New("breaker: circuit is open")
+
+// State is the state of the circuit
+type State int32
+
+const (
+	// Closed is the healthy state, all the calls are allowed
+	Closed State = iota
+	// Open is the unhealthy state, all the calls are rejected until the open timeout
+	Open
+	// HalfOpen is the probing state after the open timeout, only one call is allowed at a time
+	HalfOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is the circuit breaker of a dependency. The circuit opens after the consecutive failures reach
+// the threshold, then a probe call is allowed after the open timeout, the circuit closes if the probe succeeds,
+// and opens again otherwise.
+type Breaker struct {
+	name      string
+	threshold int
+	timeout   time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// probing reports whether a probe call is in flight in the half-open state
+	probing bool
+}
+
+// New creates a closed circuit breaker of the named dependency,
+// the defaults are used if threshold or timeout is not positive.
+func New(name string, threshold int, timeout time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = DefaultFailureThreshold
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultOpenTimeout
+	}
+
+	return &Breaker{name: name, threshold: threshold, timeout: timeout}
+}
+
+// Name returns the name of the dependency
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Allow reports whether the call is allowed, the result of the allowed call must be reported by Done
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.timeout {
+			return false
+		}
+
+		b.setState(HalfOpen, nil)
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+
+		return true
+	default:
+		return true
+	}
+}
+
+// Done reports the result of the allowed call, nil err means the dependency is healthy
+func (b *Breaker) Done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen {
+		b.probing = false
+	}
+
+	if err == nil {
+		b.failures = 0
+		if b.state != Closed {
+			b.setState(Closed, nil)
+		}
+
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(Open, err)
+	}
+}
+
+// Release releases the allowed call which is abandoned without a result, e.g. canceled by the caller
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen {
+		b.probing = false
+	}
+}
+
+// setState changes the state and logs the change, err is the failure which opens the circuit,
+// the caller must hold the lock
+func (b *Breaker) setState(s State, err error) {
+	level := slog.LevelWarn
+	if s == Closed {
+		level = slog.LevelInfo
+	}
+
+	slog.Log(context.Background(), level, "circuit breaker state changed", slog.String("name", b.name),
+		slog.String("from", b.state.String()), slog.String("to", s.String()), slog.Any("error", err))
+
+	b.state = s
+}
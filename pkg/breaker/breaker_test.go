@@ -0,0 +1,70 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("test error")
+
+func TestNew(t *testing.T) {
+	b := New("redis", 0, 0)
+	require.Equal(t, "redis", b.Name())
+	require.Equal(t, DefaultFailureThreshold, b.threshold)
+	require.Equal(t, DefaultOpenTimeout, b.timeout)
+	require.Equal(t, Closed, b.State())
+}
+
+func TestBreaker(t *testing.T) {
+	b := New("redis", 3, 50*time.Millisecond)
+
+	// the failures are reset by a success
+	for range 2 {
+		require.True(t, b.Allow())
+		b.Done(errTest)
+	}
+
+	require.True(t, b.Allow())
+	b.Done(nil)
+
+	for range 3 {
+		require.Equal(t, Closed, b.State())
+		require.True(t, b.Allow())
+		b.Done(errTest)
+	}
+
+	require.Equal(t, Open, b.State())
+	require.False(t, b.Allow())
+
+	// only one probe is allowed after the open timeout, and the circuit opens again if the probe fails
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, b.Allow())
+	require.Equal(t, HalfOpen, b.State())
+	require.False(t, b.Allow())
+	b.Done(errTest)
+	require.Equal(t, Open, b.State())
+	require.False(t, b.Allow())
+
+	// the released probe allows another probe
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, b.Allow())
+	b.Release()
+	require.Equal(t, HalfOpen, b.State())
+	require.True(t, b.Allow())
+
+	// the circuit closes if the probe succeeds
+	b.Done(nil)
+	require.Equal(t, Closed, b.State())
+	require.True(t, b.Allow())
+	require.True(t, b.Allow())
+}
+
+func TestState_String(t *testing.T) {
+	require.Equal(t, "closed", Closed.String())
+	require.Equal(t, "open", Open.String())
+	require.Equal(t, "half-open", HalfOpen.String())
+	require.Equal(t, "unknown", State(-1).String())
+}
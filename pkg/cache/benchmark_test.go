@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
 )
 
@@ -25,6 +26,24 @@ func testSet(b *testing.B, cache Interface, ttl time.Duration) {
 	})
 }
 
+// benchLocalCaches runs the benchmark against every local cache backend
+func benchLocalCaches(b *testing.B, bench func(b *testing.B, cache Interface, ttl time.Duration)) {
+	for _, typ := range []string{configs.LocalCacheBigCache, configs.LocalCacheLRU} {
+		b.Run(typ, func(b *testing.B) {
+			c := *tests.GlobalConfig.Cache.LocalCache
+			c.Type = typ
+
+			cache, err := NewLocalCache(&c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer cache.Close()
+
+			bench(b, cache, 10*time.Minute)
+		})
+	}
+}
+
 func testGet(b *testing.B, cache Interface, ttl time.Duration) {
 	v := []byte("https://abc.com/images/100040.jpg")
 
@@ -47,28 +66,28 @@ func testGet(b *testing.B, cache Interface, ttl time.Duration) {
 }
 
 func Benchmark_LocalCache_Set(b *testing.B) {
-	cache, _ := newLocalCache(tests.GlobalConfig.Cache.LocalCache)
-	defer cache.Close()
-
-	testSet(b, cache, 10*time.Minute)
+	benchLocalCaches(b, testSet)
 }
 
 func Benchmark_RedisCache_Set(b *testing.B) {
-	cache := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	cache, err := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	if err != nil {
+		b.Fatal(err)
+	}
 	defer cache.Close()
 
 	testSet(b, cache, 10*time.Minute)
 }
 
 func Benchmark_LocalCache_Get(b *testing.B) {
-	cache, _ := newLocalCache(tests.GlobalConfig.Cache.LocalCache)
-	defer cache.Close()
-
-	testGet(b, cache, 10*time.Minute)
+	benchLocalCaches(b, testGet)
 }
 
 func Benchmark_RedisCache_Get(b *testing.B) {
-	cache := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	cache, err := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	if err != nil {
+		b.Fatal(err)
+	}
 	defer cache.Close()
 
 	testGet(b, cache, 10*time.Minute)
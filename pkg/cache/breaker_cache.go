@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/beihai0xff/turl/pkg/breaker"
+)
+
+// ErrUnavailable is returned when the circuit breaker of the cache is open
+var ErrUnavailable = errors.New("cache: cache is unavailable")
+
+var (
+	_ Interface = (*breakerCache)(nil)
+	_ TTLGetter = (*breakerCache)(nil)
+)
+
+// breakerCache guards the cache with a circuit breaker, the calls fail with ErrUnavailable immediately
+// while the circuit is open. The cache misses and the calls canceled by the callers are not failures.
+type breakerCache struct {
+	Interface
+	breaker *breaker.Breaker
+}
+
+func newBreakerCache(c Interface, b *breaker.Breaker) *breakerCache {
+	return &breakerCache{Interface: c, breaker: b}
+}
+
+func (c *breakerCache) Set(ctx context.Context, k string, v []byte, ttl time.Duration) error {
+	if !c.breaker.Allow() {
+		return ErrUnavailable
+	}
+
+	err := c.Interface.Set(ctx, k, v, ttl)
+	c.done(ctx, err)
+
+	return err
+}
+
+func (c *breakerCache) Get(ctx context.Context, k string) ([]byte, error) {
+	if !c.breaker.Allow() {
+		return nil, ErrUnavailable
+	}
+
+	v, err := c.Interface.Get(ctx, k)
+	c.done(ctx, err)
+
+	return v, err
+}
+
+// GetWithTTL gets the value and its remaining ttl, the ttl is zero if the cache can not report it
+func (c *breakerCache) GetWithTTL(ctx context.Context, k string) ([]byte, time.Duration, error) {
+	g, ok := c.Interface.(TTLGetter)
+	if !ok {
+		v, err := c.Get(ctx, k)
+		return v, 0, err
+	}
+
+	if !c.breaker.Allow() {
+		return nil, 0, ErrUnavailable
+	}
+
+	v, ttl, err := g.GetWithTTL(ctx, k)
+	c.done(ctx, err)
+
+	return v, ttl, err
+}
+
+func (c *breakerCache) Del(ctx context.Context, k string) error {
+	if !c.breaker.Allow() {
+		return ErrUnavailable
+	}
+
+	err := c.Interface.Del(ctx, k)
+	c.done(ctx, err)
+
+	return err
+}
+
+// done reports the result of the call to the breaker
+func (c *breakerCache) done(ctx context.Context, err error) {
+	switch {
+	case err == nil, errors.Is(err, ErrCacheMiss):
+		c.breaker.Done(nil)
+	case errors.Is(ctx.Err(), context.Canceled):
+		// canceled by the caller, the cache is neither healthy nor unhealthy, the deadlines exceeded are failures
+		c.breaker.Release()
+	default:
+		c.breaker.Done(err)
+	}
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/beihai0xff/turl/configs"
+	redis2 "github.com/beihai0xff/turl/pkg/db/redis"
+)
+
+const (
+	// DefaultInvalidationChannel is the default redis channel of the invalidation messages
+	DefaultInvalidationChannel = "turl:cache:invalidation"
+
+	// delPrefix is the prefix of the messages which delete a key
+	delPrefix = "del "
+	// flushMessage is the message which flushes the whole local cache
+	flushMessage = "flush"
+
+	// busPingInterval is the interval to ping redis when no message is received, to detect the dead connections
+	busPingInterval = 30 * time.Second
+	// busMinBackoff and busMaxBackoff are the bounds of the backoff between the reconnections
+	busMinBackoff = 100 * time.Millisecond
+	busMaxBackoff = 10 * time.Second
+)
+
+// Invalidation is an invalidation message of the local caches
+type Invalidation struct {
+	// Key is the key to delete, empty if Flush is true
+	Key string
+	// Flush is whether to flush the whole local cache
+	Flush bool
+}
+
+// Bus is the invalidation bus of the local caches across instances, the writers publish the invalidations
+// on a redis channel, and every instance subscribes to the channel and evicts its local cache.
+// The handlers of an instance share one subscription of the bus.
+type Bus struct {
+	rdb     redis.UniversalClient
+	channel string
+
+	mu       sync.Mutex
+	handlers map[int]func(Invalidation)
+	nextID   int
+	// unsubscribe stops the subscription, nil if no handler is subscribed
+	unsubscribe func()
+}
+
+// NewInvalidationBus creates the invalidation bus of the cache config with its own redis client,
+// nil if the invalidation is disabled. The bus is shared by the cache proxies of an instance,
+// and the redis client is closed by Close.
+func NewInvalidationBus(c *configs.CacheConfig) (*Bus, error) {
+	if c.Redis == nil || c.Invalidation == nil || !c.Invalidation.Enable {
+		return nil, nil //nolint:nilnil
+	}
+
+	rdb, err := redis2.Client(c.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBus(rdb, c.Invalidation.Channel), nil
+}
+
+// NewBus creates an invalidation bus on the redis channel
+func NewBus(rdb redis.UniversalClient, channel string) *Bus {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+
+	return &Bus{rdb: rdb, channel: channel, handlers: make(map[int]func(Invalidation))}
+}
+
+// PublishDel publishes the invalidation of the key
+func (b *Bus) PublishDel(ctx context.Context, k string) error {
+	return b.rdb.Publish(ctx, b.channel, delPrefix+k).Err()
+}
+
+// PublishFlush publishes the invalidation of the whole local caches
+func (b *Bus) PublishFlush(ctx context.Context) error {
+	return b.rdb.Publish(ctx, b.channel, flushMessage).Err()
+}
+
+// Subscribe subscribes to the invalidations in background, and calls handle for each invalidation.
+// The subscription reconnects after the connection is lost, and a flush invalidation is handled after reconnected,
+// since the invalidations published while disconnected are lost. The returned function stops calling handle,
+// and the subscription is stopped after the last handler.
+func (b *Bus) Subscribe(handle func(Invalidation)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handle
+
+	if b.unsubscribe == nil {
+		b.unsubscribe = b.subscribe()
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.handlers, id)
+
+			var unsubscribe func()
+			if len(b.handlers) == 0 {
+				unsubscribe, b.unsubscribe = b.unsubscribe, nil
+			}
+			b.mu.Unlock()
+
+			if unsubscribe != nil {
+				unsubscribe()
+			}
+		})
+	}
+}
+
+// Close stops the subscription and closes the redis client
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	unsubscribe := b.unsubscribe
+	b.handlers, b.unsubscribe = make(map[int]func(Invalidation)), nil
+	b.mu.Unlock()
+
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+
+	return b.rdb.Close()
+}
+
+// subscribe subscribes to the channel in background, and dispatches the invalidations to the handlers,
+// the returned function stops the subscription
+func (b *Bus) subscribe() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := b.rdb.Subscribe(ctx, b.channel)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		b.receive(ctx, ps, b.dispatch)
+	}()
+
+	return func() {
+		cancel()
+		_ = ps.Close()
+		wg.Wait()
+	}
+}
+
+// dispatch calls the handlers with the invalidation
+func (b *Bus) dispatch(inv Invalidation) {
+	b.mu.Lock()
+	handlers := make([]func(Invalidation), 0, len(b.handlers))
+	for _, handle := range b.handlers {
+		handlers = append(handlers, handle)
+	}
+	b.mu.Unlock()
+
+	for _, handle := range handlers {
+		handle(inv)
+	}
+}
+
+// receive receives the invalidations until ctx is canceled
+func (b *Bus) receive(ctx context.Context, ps *redis.PubSub, handle func(Invalidation)) {
+	subscribed, backoff := false, busMinBackoff
+
+	for ctx.Err() == nil {
+		msg, err := ps.ReceiveTimeout(ctx, busPingInterval)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// no message for a while, ping to make sure the connection is alive, reconnected if not
+				err = ps.Ping(ctx)
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			slog.Warn("cache invalidation subscription is broken, reconnecting",
+				slog.String("channel", b.channel), slog.Any("error", err), slog.Duration("backoff", backoff))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = min(2*backoff, busMaxBackoff)
+
+			continue
+		}
+
+		backoff = busMinBackoff
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind != "subscribe" {
+				continue
+			}
+
+			if subscribed { // resubscribed after reconnected, the invalidations may be lost
+				slog.Info("cache invalidation subscription is reconnected, flush local cache", slog.String("channel", b.channel))
+				handle(Invalidation{Flush: true})
+			}
+
+			subscribed = true
+		case *redis.Message:
+			if inv, ok := parseInvalidation(m.Payload); ok {
+				handle(inv)
+			} else {
+				slog.Warn("unknown cache invalidation message", slog.String("payload", m.Payload))
+			}
+		}
+	}
+}
+
+// parseInvalidation parses the invalidation message
+func parseInvalidation(payload string) (Invalidation, bool) {
+	if payload == flushMessage {
+		return Invalidation{Flush: true}, true
+	}
+
+	if k, ok := strings.CutPrefix(payload, delPrefix); ok {
+		return Invalidation{Key: k}, true
+	}
+
+	return Invalidation{}, false
+}
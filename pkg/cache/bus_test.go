@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+// newTestBus returns a bus on a local miniredis and the channel receiving the invalidations of the subscription
+func newTestBus(t *testing.T, s *miniredis.Miniredis) (*Bus, <-chan Invalidation) {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+
+	b := NewBus(rdb, "")
+	ch := make(chan Invalidation, 10)
+	t.Cleanup(b.Subscribe(func(inv Invalidation) { ch <- inv }))
+
+	// wait for the subscription
+	require.Eventually(t, func() bool { return len(s.PubSubChannels("")) == 1 }, time.Second, 10*time.Millisecond)
+
+	return b, ch
+}
+
+// receive returns the next invalidation received
+func receive(t *testing.T, ch <-chan Invalidation) Invalidation {
+	t.Helper()
+
+	select {
+	case inv := <-ch:
+		return inv
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no invalidation received")
+		return Invalidation{}
+	}
+}
+
+func TestBus(t *testing.T) {
+	s := miniredis.RunT(t)
+	b, ch := newTestBus(t, s)
+	ctx := context.Background()
+
+	require.NoError(t, b.PublishDel(ctx, "go.example.com/abc"))
+	require.Equal(t, Invalidation{Key: "go.example.com/abc"}, receive(t, ch))
+
+	require.NoError(t, b.PublishFlush(ctx))
+	require.Equal(t, Invalidation{Flush: true}, receive(t, ch))
+
+	// the unknown messages are ignored
+	s.Publish(DefaultInvalidationChannel, "unknown")
+	require.NoError(t, b.PublishDel(ctx, "abc"))
+	require.Equal(t, Invalidation{Key: "abc"}, receive(t, ch))
+}
+
+func TestBus_sharedSubscription(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+
+	b := NewBus(redis.NewClient(&redis.Options{Addr: s.Addr()}), "")
+	ch1, ch2 := make(chan Invalidation, 10), make(chan Invalidation, 10)
+	unsubscribe1 := b.Subscribe(func(inv Invalidation) { ch1 <- inv })
+	unsubscribe2 := b.Subscribe(func(inv Invalidation) { ch2 <- inv })
+
+	// the handlers share one subscription, and each of them receives the invalidation once
+	require.Eventually(t, func() bool { return s.PubSubNumSub(DefaultInvalidationChannel)[DefaultInvalidationChannel] == 1 },
+		time.Second, 10*time.Millisecond)
+	require.NoError(t, b.PublishDel(ctx, "abc"))
+	require.Equal(t, Invalidation{Key: "abc"}, receive(t, ch1))
+	require.Equal(t, Invalidation{Key: "abc"}, receive(t, ch2))
+	require.Empty(t, ch1)
+
+	unsubscribe1()
+	unsubscribe1()
+	require.NoError(t, b.PublishDel(ctx, "def"))
+	require.Equal(t, Invalidation{Key: "def"}, receive(t, ch2))
+	require.Empty(t, ch1)
+
+	// the subscription is stopped after the last handler
+	unsubscribe2()
+	require.Eventually(t, func() bool { return s.PubSubNumSub(DefaultInvalidationChannel)[DefaultInvalidationChannel] == 0 },
+		time.Second, 10*time.Millisecond)
+
+	b.Subscribe(func(Invalidation) {})
+	require.NoError(t, b.Close())
+	require.Error(t, b.PublishDel(ctx, "ghi"))
+}
+
+func TestNewInvalidationBus(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := &configs.CacheConfig{Redis: &configs.RedisConfig{Addr: []string{s.Addr()}, DialTimeout: time.Second, MaxConn: 1}}
+
+	b, err := NewInvalidationBus(c)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	c.Invalidation = &configs.InvalidationConfig{Enable: true, Channel: "test"}
+	b, err = NewInvalidationBus(c)
+	require.NoError(t, err)
+	require.Equal(t, "test", b.channel)
+	require.NoError(t, b.Close())
+}
+
+func TestBus_reconnect(t *testing.T) {
+	s := miniredis.RunT(t)
+	b, ch := newTestBus(t, s)
+
+	s.Close()
+	require.NoError(t, s.Restart())
+
+	// flush after reconnected, since the invalidations may be lost while disconnected
+	require.Equal(t, Invalidation{Flush: true}, receive(t, ch))
+
+	require.NoError(t, b.PublishDel(context.Background(), "abc"))
+	require.Equal(t, Invalidation{Key: "abc"}, receive(t, ch))
+}
+
+func TestProxy_invalidate(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+
+	newTestProxy := func() *proxy {
+		lc, err := newLocalCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 16})
+		require.NoError(t, err)
+
+		rc, err := newRedisCache(&configs.RedisConfig{Addr: []string{s.Addr()}, DialTimeout: time.Second, MaxConn: 5})
+		require.NoError(t, err)
+
+		p := &proxy{
+			localCache:       lc,
+			distributedCache: rc,
+			remoteCacheTTL:   time.Minute,
+			localCacheTTL:    time.Minute,
+			bus:              NewBus(redis.NewClient(&redis.Options{Addr: s.Addr()}), ""),
+		}
+		p.unsubscribe = p.bus.Subscribe(p.invalidate)
+		t.Cleanup(func() { p.Close() })
+
+		return p
+	}
+
+	writer, reader := newTestProxy(), newTestProxy()
+	require.Eventually(t, func() bool { return s.PubSubNumSub(DefaultInvalidationChannel)[DefaultInvalidationChannel] == 2 },
+		time.Second, 10*time.Millisecond)
+
+	for _, k := range []string{"key1", "key2"} {
+		require.NoError(t, writer.Set(ctx, k, []byte("value"), time.Minute))
+		_, err := reader.Get(ctx, k) // set the local cache of reader
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Del(ctx, "key1"))
+	require.Eventually(t, func() bool {
+		_, err := reader.localCache.Get(ctx, "key1")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, writer.bus.PublishFlush(ctx))
+	require.Eventually(t, func() bool {
+		_, err := reader.localCache.Get(ctx, "key2")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
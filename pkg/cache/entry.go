@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// EntryVersion is the current version of the encoded cache entry
+	EntryVersion byte = 1
+
+	// entryHeaderSize is the size of the fixed fields of the version 1 entry,
+	// the redirect mode (2 bytes) and the expiration time (8 bytes)
+	entryHeaderSize = 10
+	// maxEntryShortSize is the max size of the short code in the header, the longer short codes are not cached
+	// in the entry, since the header size is a single byte
+	maxEntryShortSize = math.MaxUint8 - entryHeaderSize - 1
+	// minPlainByte is the min first byte of the plain long URL entries, the version bytes are always less than it
+	minPlainByte = 0x20
+)
+
+var (
+	// ErrInvalidEntry is returned when the cache value can not be decoded
+	ErrInvalidEntry = errors.New("cache: invalid entry")
+	// ErrUnsupportedEntry is returned when the cache value is encoded by a newer version
+	ErrUnsupportedEntry = errors.New("cache: unsupported entry version")
+)
+
+// Entry is the cached short link, carries the long URL and the metadata of the link,
+// so that the cache hits can be served without the db lookup.
+//
+// The entry is encoded as:
+//
+//	version (1 byte) | header size (1 byte) | redirect mode (2 bytes) | expires at (8 bytes) |
+//	short size (1 byte) | short | tenant size (uvarint) | tenant | long URL
+//
+// the short code is appended to the header, so the entries without it are still decoded,
+// and the fields appended to the header by the later versions are skipped by the header size.
+// The plain long URL values and the values prefixed with the redirect status of the previous versions
+// are still decoded, their first bytes are always printable.
+type Entry struct {
+	// LongURL is the original long URL
+	LongURL []byte
+	// RedirectMode is the redirect status code of the link, 0 for the domain default
+	RedirectMode uint16
+	// ExpiresAt is the expiration time of the link, the zero time means never expire
+	ExpiresAt time.Time
+	// Tenant is the owner tenant of the link
+	Tenant string
+	// Short is the canonical short code of the link, nil if the entry is encoded without it
+	Short []byte
+}
+
+// Expired reports whether the link of the entry has expired
+func (e *Entry) Expired() bool {
+	return !e.ExpiresAt.IsZero() && !time.Now().Before(e.ExpiresAt)
+}
+
+// Encode encodes the entry with the current version
+func (e *Entry) Encode() []byte {
+	short := e.Short
+	if len(short) > maxEntryShortSize {
+		short = nil
+	}
+
+	b := make([]byte, 2+entryHeaderSize, 3+entryHeaderSize+len(short)+binary.MaxVarintLen64+len(e.Tenant)+len(e.LongURL))
+	b[0], b[1] = EntryVersion, byte(entryHeaderSize+1+len(short))
+	binary.BigEndian.PutUint16(b[2:], e.RedirectMode)
+
+	if !e.ExpiresAt.IsZero() {
+		binary.BigEndian.PutUint64(b[4:], uint64(e.ExpiresAt.UnixNano()))
+	}
+
+	b = append(b, byte(len(short)))
+	b = append(b, short...)
+
+	b = binary.AppendUvarint(b, uint64(len(e.Tenant)))
+	b = append(b, e.Tenant...)
+
+	return append(b, e.LongURL...)
+}
+
+// DecodeEntry decodes the cache value to the entry, returns ErrUnsupportedEntry if the value is encoded
+// by a newer version, and ErrInvalidEntry if the value is malformed.
+func DecodeEntry(v []byte) (*Entry, error) {
+	switch {
+	case len(v) == 0:
+		return nil, ErrInvalidEntry
+	case v[0] == EntryVersion:
+		return decodeV1(v)
+	case v[0] < minPlainByte:
+		return nil, ErrUnsupportedEntry
+	case v[0] >= '0' && v[0] <= '9':
+		// the long URL prefixed with the redirect status and a space
+		prefix, long, ok := bytes.Cut(v, []byte(" "))
+		status, err := strconv.ParseUint(string(prefix), 10, 16)
+		if !ok || err != nil {
+			return nil, ErrInvalidEntry
+		}
+
+		return &Entry{LongURL: long, RedirectMode: uint16(status)}, nil
+	default:
+		return &Entry{LongURL: v}, nil
+	}
+}
+
+// decodeV1 decodes the version 1 entry
+func decodeV1(v []byte) (*Entry, error) {
+	if len(v) < 2 || v[1] < entryHeaderSize || len(v) < 2+int(v[1]) {
+		return nil, ErrInvalidEntry
+	}
+
+	e := Entry{RedirectMode: binary.BigEndian.Uint16(v[2:])}
+	if deadline := int64(binary.BigEndian.Uint64(v[4:])); deadline != 0 {
+		e.ExpiresAt = time.Unix(0, deadline)
+	}
+
+	// the entries encoded before the short code is cached end with the fixed fields
+	if header := v[2+entryHeaderSize : 2+int(v[1])]; len(header) > 0 {
+		if n := int(header[0]); n > 0 {
+			if len(header) < 1+n {
+				return nil, ErrInvalidEntry
+			}
+
+			e.Short = header[1 : 1+n]
+		}
+	}
+
+	v = v[2+int(v[1]):]
+
+	n, size := binary.Uvarint(v)
+	if size <= 0 || uint64(len(v)-size) < n {
+		return nil, ErrInvalidEntry
+	}
+
+	e.Tenant = string(v[size : size+int(n)])
+	e.LongURL = v[size+int(n):]
+
+	return &e, nil
+}
+
+// SetEntry encodes and sets the entry to the cache
+func SetEntry(ctx context.Context, c Interface, k string, e *Entry, ttl time.Duration) error {
+	return c.Set(ctx, k, e.Encode(), ttl)
+}
+
+// GetEntry gets and decodes the entry from the cache
+func GetEntry(ctx context.Context, c Interface, k string) (*Entry, error) {
+	v, err := c.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeEntry(v)
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/internal/tests"
+)
+
+func TestEntry_Encode(t *testing.T) {
+	expiresAt := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+
+	for _, e := range []*Entry{
+		{LongURL: []byte("https://www.example.com")},
+		{LongURL: []byte("https://www.example.com"), RedirectMode: 308, ExpiresAt: expiresAt, Tenant: "tenant-a"},
+		{LongURL: []byte{}, Tenant: "tenant-a"},
+		{LongURL: []byte("https://www.example.com"), Tenant: "tenant-a", Short: []byte("spring-sale")},
+	} {
+		v := e.Encode()
+		require.Equal(t, EntryVersion, v[0])
+
+		got, err := DecodeEntry(v)
+		require.NoError(t, err)
+		require.Equal(t, e, got)
+	}
+}
+
+func TestDecodeEntry(t *testing.T) {
+	// the plain long URL entries of the previous versions
+	got, err := DecodeEntry([]byte("https://www.example.com"))
+	require.NoError(t, err)
+	require.Equal(t, &Entry{LongURL: []byte("https://www.example.com")}, got)
+
+	// the long URL prefixed with the redirect status
+	got, err = DecodeEntry([]byte("301 https://www.example.com"))
+	require.NoError(t, err)
+	require.Equal(t, &Entry{LongURL: []byte("https://www.example.com"), RedirectMode: 301}, got)
+
+	// the fields appended to the header by the later versions are skipped
+	v := (&Entry{LongURL: []byte("https://www.example.com"), RedirectMode: 307, Short: []byte("3yR")}).Encode()
+	v = append(v[:2+int(v[1]):2+int(v[1])], append([]byte{0xff, 0xff}, v[2+int(v[1]):]...)...)
+	v[1] += 2
+	got, err = DecodeEntry(v)
+	require.NoError(t, err)
+	require.Equal(t, &Entry{LongURL: []byte("https://www.example.com"), RedirectMode: 307, Short: []byte("3yR")}, got)
+
+	// the entries encoded without the short code
+	v = (&Entry{LongURL: []byte("https://www.example.com"), Tenant: "tenant-a"}).Encode()
+	v = append(v[:2+entryHeaderSize:2+entryHeaderSize], v[3+entryHeaderSize:]...)
+	v[1] = entryHeaderSize
+	got, err = DecodeEntry(v)
+	require.NoError(t, err)
+	require.Equal(t, &Entry{LongURL: []byte("https://www.example.com"), Tenant: "tenant-a"}, got)
+
+	// the short codes too long for the header are not cached
+	got, err = DecodeEntry((&Entry{LongURL: []byte("https://www.example.com"), Short: make([]byte, maxEntryShortSize+1)}).Encode())
+	require.NoError(t, err)
+	require.Nil(t, got.Short)
+
+	_, err = DecodeEntry([]byte{EntryVersion + 1, 0})
+	require.ErrorIs(t, err, ErrUnsupportedEntry)
+
+	for _, v := range [][]byte{nil, {EntryVersion}, {EntryVersion, 1, 0}, {EntryVersion, entryHeaderSize},
+		append([]byte{EntryVersion, entryHeaderSize + 1}, make([]byte, entryHeaderSize)...), // no room for the short code
+		append([]byte{EntryVersion, entryHeaderSize + 1}, append(make([]byte, entryHeaderSize), 1)...), []byte("30x https://www.example.com")} {
+		_, err = DecodeEntry(v)
+		require.ErrorIs(t, err, ErrInvalidEntry)
+	}
+
+	v = (&Entry{Tenant: "tenant-a"}).Encode()
+	_, err = DecodeEntry(v[:len(v)-1])
+	require.ErrorIs(t, err, ErrInvalidEntry)
+}
+
+func TestEntry_Expired(t *testing.T) {
+	require.False(t, (&Entry{}).Expired())
+	require.False(t, (&Entry{ExpiresAt: time.Now().Add(time.Minute)}).Expired())
+	require.True(t, (&Entry{ExpiresAt: time.Now().Add(-time.Minute)}).Expired())
+}
+
+func TestEntry_roundTrip(t *testing.T) {
+	p, err := newProxy(tests.GlobalConfig.Cache, nil, nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	e := &Entry{LongURL: []byte("https://www.example.com"), RedirectMode: 301, Tenant: "tenant-a"}
+
+	for name, c := range map[string]Interface{"proxy": p, "local": p.localCache, "redis": p.distributedCache} {
+		t.Run(name, func(t *testing.T) {
+			k := "entry_" + name
+			require.NoError(t, SetEntry(ctx, c, k, e, time.Minute))
+
+			got, err := GetEntry(ctx, c, k)
+			require.NoError(t, err)
+			require.Equal(t, e, got)
+
+			require.NoError(t, c.Del(ctx, k))
+			_, err = GetEntry(ctx, c, k)
+			require.ErrorIs(t, err, ErrCacheMiss)
+		})
+	}
+}
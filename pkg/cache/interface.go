@@ -11,6 +11,9 @@ import (
 // if Get() method return this error, means key is not exist
 var ErrCacheMiss = errors.New("cache: key is missing")
 
+// ErrNotFound is returned by the NegativeCache if the key is cached as not found
+var ErrNotFound = errors.New("cache: key is known not to exist")
+
 // Interface cache interface
 type Interface interface {
 	// Set the key value to cache
@@ -22,3 +25,45 @@ type Interface interface {
 	// Close the cache
 	Close() error
 }
+
+// TTLGetter is an optional interface implemented by the cache which can report the remaining ttl of the key
+type TTLGetter interface {
+	// GetWithTTL get the key value and its remaining ttl from cache,
+	// the ttl is zero if the key never expires
+	GetWithTTL(ctx context.Context, k string) ([]byte, time.Duration, error)
+}
+
+// NegativeCache is an optional interface implemented by the cache which can cache the keys not exist,
+// Get returns ErrNotFound for the keys cached as not found, until the negative entries expire or the keys are set.
+type NegativeCache interface {
+	// SetNotFound caches the key as not found
+	SetNotFound(ctx context.Context, k string) error
+}
+
+// LocalSetter is an optional interface implemented by the multi-tier cache which can set the local tier only
+type LocalSetter interface {
+	// SetLocal sets the key value to the local cache only
+	SetLocal(ctx context.Context, k string, v []byte, ttl time.Duration) error
+}
+
+// Flusher is an optional interface implemented by the cache which can remove all the entries
+type Flusher interface {
+	// Flush removes all the entries of the cache
+	Flush() error
+}
+
+// Stats is the statistics of the local cache
+type Stats struct {
+	// Hits is the number of the keys found
+	Hits uint64
+	// Misses is the number of the keys missing or expired
+	Misses uint64
+	// Evictions is the number of the entries evicted by expiry or capacity
+	Evictions uint64
+}
+
+// StatsGetter is an optional interface implemented by the cache which can report its statistics
+type StatsGetter interface {
+	// Stats returns the statistics of the cache
+	Stats() Stats
+}
@@ -2,7 +2,10 @@ package cache
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
@@ -10,20 +13,35 @@ import (
 	"github.com/beihai0xff/turl/configs"
 )
 
+// deadlineSize is the size of the expiration deadline header of each local cache entry
+const deadlineSize = 8
+
 var (
-	_             Interface = (*localCache)(nil)
-	errInvalidCap           = errors.New("cache: invalid capacity")
+	_             Interface   = (*localCache)(nil)
+	_             Flusher     = (*localCache)(nil)
+	_             StatsGetter = (*localCache)(nil)
+	errInvalidCap             = errors.New("cache: invalid capacity")
 )
 
+// localCache is the bigcache local cache
 type localCache struct {
 	cache *bigcache.BigCache
+
+	hits, misses, evictions atomic.Uint64
 }
 
-// NewLocalCache create a local cache
+// NewLocalCache create a local cache of the backend type
 // capacity is the cache capacity
 // ttl is the time to live
 func NewLocalCache(c *configs.LocalCacheConfig) (Interface, error) {
-	return newLocalCache(c)
+	switch c.Type {
+	case "", configs.LocalCacheBigCache:
+		return newLocalCache(c)
+	case configs.LocalCacheLRU:
+		return newLRUCache(c)
+	default:
+		return nil, fmt.Errorf("unknown local cache type %q", c.Type)
+	}
 }
 
 func newLocalCache(c *configs.LocalCacheConfig) (*localCache, error) {
@@ -35,28 +53,66 @@ func newLocalCache(c *configs.LocalCacheConfig) (*localCache, error) {
 	config.MaxEntriesInWindow = c.Capacity
 	config.HardMaxCacheSize = c.MaxMemory
 
+	l := &localCache{}
+	config.OnRemoveWithReason = func(_ string, _ []byte, reason bigcache.RemoveReason) {
+		if reason != bigcache.Deleted {
+			l.evictions.Add(1)
+		}
+	}
+
 	b, err := bigcache.New(context.Background(), config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &localCache{cache: b}, err
+	l.cache = b
+
+	return l, nil
 }
 
 // Set the k v pair to the cache
-// Note that the duration is not used
-func (l *localCache) Set(_ context.Context, k string, v []byte, _ time.Duration) error {
-	return l.cache.Set(k, v)
+// bigcache only has a global life window, so the ttl is stored as a deadline header of the entry,
+// the entry expires at the earlier of the deadline and the end of the life window, zero ttl means no deadline.
+func (l *localCache) Set(_ context.Context, k string, v []byte, ttl time.Duration) error {
+	entry := make([]byte, deadlineSize+len(v))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(entry, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+
+	copy(entry[deadlineSize:], v)
+
+	return l.cache.Set(k, entry)
 }
 
 // Get the value by key
 func (l *localCache) Get(_ context.Context, k string) ([]byte, error) {
-	v, err := l.cache.Get(k)
-	if err != nil && errors.Is(err, bigcache.ErrEntryNotFound) {
+	entry, err := l.cache.Get(k)
+	if err != nil {
+		if errors.Is(err, bigcache.ErrEntryNotFound) {
+			l.misses.Add(1)
+			return nil, ErrCacheMiss
+		}
+
+		return nil, err
+	}
+
+	if len(entry) < deadlineSize {
+		l.misses.Add(1)
 		return nil, ErrCacheMiss
 	}
 
-	return v, err
+	deadline := int64(binary.BigEndian.Uint64(entry))
+	if deadline > 0 && time.Now().UnixNano() >= deadline { // the entry has expired
+		_ = l.cache.Delete(k)
+		l.evictions.Add(1)
+		l.misses.Add(1)
+
+		return nil, ErrCacheMiss
+	}
+
+	l.hits.Add(1)
+
+	return entry[deadlineSize:], nil
 }
 
 func (l *localCache) Del(_ context.Context, k string) error {
@@ -72,6 +128,16 @@ func (l *localCache) Del(_ context.Context, k string) error {
 	return nil
 }
 
+// Flush removes all the entries of the cache
+func (l *localCache) Flush() error {
+	return l.cache.Reset()
+}
+
+// Stats returns the statistics of the cache
+func (l *localCache) Stats() Stats {
+	return Stats{Hits: l.hits.Load(), Misses: l.misses.Load(), Evictions: l.evictions.Load()}
+}
+
 // Close the cache
 func (l *localCache) Close() error {
 	return l.cache.Close()
@@ -21,6 +21,20 @@ func TestNew(t *testing.T) {
 	require.NotNil(t, c)
 }
 
+func TestNewLocalCache_type(t *testing.T) {
+	c, err := NewLocalCache(&configs.LocalCacheConfig{Type: configs.LocalCacheLRU, TTL: time.Minute, Capacity: 1000})
+	require.NoError(t, err)
+	require.IsType(t, &lruCache{}, c)
+
+	c, err = NewLocalCache(&configs.LocalCacheConfig{Type: configs.LocalCacheBigCache, TTL: time.Minute, Capacity: 1000, MaxMemory: 16})
+	require.NoError(t, err)
+	require.IsType(t, &localCache{}, c)
+	require.NoError(t, c.Close())
+
+	_, err = NewLocalCache(&configs.LocalCacheConfig{Type: "unknown", TTL: time.Minute, Capacity: 1000})
+	require.Error(t, err)
+}
+
 func Test_newLocalCache_failed(t *testing.T) {
 	// invalid cap
 	c, err := newLocalCache(&configs.LocalCacheConfig{Capacity: 0})
@@ -78,6 +92,26 @@ func Test_localCache_Get(t *testing.T) {
 	require.Nil(t, got)
 }
 
+func Test_localCache_Get_Expired(t *testing.T) {
+	c, err := newLocalCache(tests.GlobalConfig.Cache.LocalCache)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	k, v := "key_expired", []byte("value")
+
+	require.NoError(t, c.Set(context.Background(), k, v, 50*time.Millisecond))
+	got, err := c.Get(context.Background(), k)
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	time.Sleep(100 * time.Millisecond)
+	got, err = c.Get(context.Background(), k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+	require.Nil(t, got)
+}
+
 func Test_localCache_Get_Large(t *testing.T) {
 	c, err := newLocalCache(tests.GlobalConfig.Cache.LocalCache)
 	require.NoError(t, err)
@@ -121,3 +155,44 @@ func Test_localCache_Del(t *testing.T) {
 		require.NoError(t, c.Del(context.Background(), "not_exist"))
 	})
 }
+
+func Test_localCache_Flush(t *testing.T) {
+	c, err := newLocalCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 16})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Set(context.Background(), strconv.Itoa(i), []byte("value"), time.Minute))
+	}
+
+	require.NoError(t, c.Flush())
+
+	for i := 0; i < 10; i++ {
+		_, err = c.Get(context.Background(), strconv.Itoa(i))
+		require.ErrorIs(t, err, ErrCacheMiss)
+	}
+}
+
+func Test_localCache_Stats(t *testing.T) {
+	c, err := newLocalCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 16})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key", []byte("value"), time.Minute))
+	require.NoError(t, c.Set(ctx, "key_expired", []byte("value"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key_expired")
+	require.ErrorIs(t, err, ErrCacheMiss)
+	_, err = c.Get(ctx, "not_exist")
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	require.Equal(t, Stats{Hits: 1, Misses: 2, Evictions: 1}, c.Stats())
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+const (
+	// lruMaxShards is the max number of the shards of the lru cache, must be a power of two
+	lruMaxShards = 64
+	// lruMinShardCapacity is the min capacity of each shard, the small caches are not sharded,
+	// so that they evict the least recently used entry exactly
+	lruMinShardCapacity = 1024
+)
+
+var (
+	_ Interface   = (*lruCache)(nil)
+	_ Flusher     = (*lruCache)(nil)
+	_ StatsGetter = (*lruCache)(nil)
+)
+
+// lruCache is the in-process local cache which evicts the least recently used entries,
+// each entry expires at its own ttl. The cache is sharded by key to reduce the lock contention.
+type lruCache struct {
+	shards []*lruShard
+	seed   maphash.Seed
+	// ttl is the ttl of the entries set with zero ttl
+	ttl time.Duration
+
+	hits, misses, evictions atomic.Uint64
+}
+
+type lruShard struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	// ll is ordered from the most recently used entry to the least one
+	ll *list.List
+	// capacity is the max number of the entries
+	capacity int
+	// size is the total size of the keys and values, and maxSize is its limit
+	size, maxSize int
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+	// deadline is the expiration time of the entry in unix nanoseconds
+	deadline int64
+}
+
+func newLRUCache(c *configs.LocalCacheConfig) (*lruCache, error) {
+	if c.Capacity <= 0 {
+		return nil, errInvalidCap
+	}
+
+	n := 1
+	for n < lruMaxShards && n*lruMinShardCapacity < c.Capacity {
+		n <<= 1
+	}
+
+	l := &lruCache{shards: make([]*lruShard, n), seed: maphash.MakeSeed(), ttl: c.TTL}
+	for i := range l.shards {
+		l.shards[i] = &lruShard{
+			items:    make(map[string]*list.Element),
+			ll:       list.New(),
+			capacity: (c.Capacity + n - 1) / n,
+			maxSize:  (c.MaxMemory << 20) / n,
+		}
+	}
+
+	return l, nil
+}
+
+func (l *lruCache) shard(k string) *lruShard {
+	return l.shards[maphash.String(l.seed, k)&uint64(len(l.shards)-1)]
+}
+
+// Set the k v pair to the cache, the entry expires after ttl, zero ttl means the ttl of the cache
+func (l *lruCache) Set(_ context.Context, k string, v []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = l.ttl
+	}
+
+	e := &lruEntry{key: k, value: append([]byte(nil), v...), deadline: time.Now().Add(ttl).UnixNano()}
+
+	s := l.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[k]; ok {
+		s.remove(el)
+	}
+
+	s.items[k] = s.ll.PushFront(e)
+	s.size += e.size()
+
+	// evict the least recently used entries, the new entry is kept even if it exceeds the max size alone
+	for s.ll.Len() > 1 && (s.ll.Len() > s.capacity || (s.maxSize > 0 && s.size > s.maxSize)) {
+		s.remove(s.ll.Back())
+		l.evictions.Add(1)
+	}
+
+	return nil
+}
+
+// Get the value by key
+func (l *lruCache) Get(_ context.Context, k string) ([]byte, error) {
+	s := l.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[k]
+	if !ok {
+		l.misses.Add(1)
+		return nil, ErrCacheMiss
+	}
+
+	e, _ := el.Value.(*lruEntry)
+	if time.Now().UnixNano() >= e.deadline { // the entry has expired
+		s.remove(el)
+		l.evictions.Add(1)
+		l.misses.Add(1)
+
+		return nil, ErrCacheMiss
+	}
+
+	s.ll.MoveToFront(el)
+	l.hits.Add(1)
+
+	return append([]byte(nil), e.value...), nil
+}
+
+// Del the key from the cache
+func (l *lruCache) Del(_ context.Context, k string) error {
+	s := l.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[k]; ok {
+		s.remove(el)
+	}
+
+	return nil
+}
+
+// Flush removes all the entries of the cache
+func (l *lruCache) Flush() error {
+	for _, s := range l.shards {
+		s.mu.Lock()
+		s.items, s.size = make(map[string]*list.Element), 0
+		s.ll.Init()
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Stats returns the statistics of the cache
+func (l *lruCache) Stats() Stats {
+	return Stats{Hits: l.hits.Load(), Misses: l.misses.Load(), Evictions: l.evictions.Load()}
+}
+
+// Close the cache
+func (l *lruCache) Close() error {
+	return nil
+}
+
+// remove removes the element from the shard, the caller must hold the lock
+func (s *lruShard) remove(el *list.Element) {
+	e, _ := s.ll.Remove(el).(*lruEntry)
+	delete(s.items, e.key)
+	s.size -= e.size()
+}
+
+// size returns the size counted against the max memory of the cache
+func (e *lruEntry) size() int {
+	return len(e.key) + len(e.value)
+}
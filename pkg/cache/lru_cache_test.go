@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+func Test_newLRUCache(t *testing.T) {
+	_, err := newLRUCache(&configs.LocalCacheConfig{Capacity: 0})
+	require.ErrorIs(t, err, errInvalidCap)
+
+	c, err := newLRUCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000})
+	require.NoError(t, err)
+	require.Len(t, c.shards, 1)
+	require.Equal(t, 1000, c.shards[0].capacity)
+
+	c, err = newLRUCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1e8, MaxMemory: 64})
+	require.NoError(t, err)
+	require.Len(t, c.shards, lruMaxShards)
+	require.Equal(t, 1<<20, c.shards[0].maxSize)
+}
+
+func Test_lruCache_SetGet(t *testing.T) {
+	c, err := newLRUCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000})
+	require.NoError(t, err)
+
+	ctx, v := context.Background(), []byte("value")
+	require.NoError(t, c.Set(ctx, "key", v, time.Minute))
+
+	got, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	got[0] = 'V' // the returned value is a copy
+	got, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	_, err = c.Get(ctx, "not_exist")
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	require.NoError(t, c.Set(ctx, "key", []byte("new"), time.Minute))
+	got, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), got)
+
+	require.NoError(t, c.Del(ctx, "key"))
+	require.NoError(t, c.Del(ctx, "not_exist"))
+	_, err = c.Get(ctx, "key")
+	require.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func Test_lruCache_ttl(t *testing.T) {
+	c, err := newLRUCache(&configs.LocalCacheConfig{TTL: 50 * time.Millisecond, Capacity: 1000})
+	require.NoError(t, err)
+
+	ctx, v := context.Background(), []byte("value")
+	require.NoError(t, c.Set(ctx, "short", v, 10*time.Millisecond))
+	require.NoError(t, c.Set(ctx, "long", v, time.Minute))
+	require.NoError(t, c.Set(ctx, "default", v, 0))
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = c.Get(ctx, "short")
+	require.ErrorIs(t, err, ErrCacheMiss)
+	_, err = c.Get(ctx, "default")
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = c.Get(ctx, "default")
+	require.ErrorIs(t, err, ErrCacheMiss)
+	_, err = c.Get(ctx, "long")
+	require.NoError(t, err)
+
+	require.Equal(t, Stats{Hits: 2, Misses: 2, Evictions: 2}, c.Stats())
+}
+
+func Test_lruCache_evict(t *testing.T) {
+	ctx, v := context.Background(), []byte("value")
+
+	t.Run("capacity", func(t *testing.T) {
+		c, err := newLRUCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 2})
+		require.NoError(t, err)
+
+		require.NoError(t, c.Set(ctx, "key1", v, 0))
+		require.NoError(t, c.Set(ctx, "key2", v, 0))
+		_, err = c.Get(ctx, "key1") // key2 becomes the least recently used
+		require.NoError(t, err)
+		require.NoError(t, c.Set(ctx, "key3", v, 0))
+
+		_, err = c.Get(ctx, "key2")
+		require.ErrorIs(t, err, ErrCacheMiss)
+		_, err = c.Get(ctx, "key1")
+		require.NoError(t, err)
+		_, err = c.Get(ctx, "key3")
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), c.Stats().Evictions)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		c, err := newLRUCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 1})
+		require.NoError(t, err)
+
+		large := make([]byte, 400<<10)
+		for i := range 3 {
+			require.NoError(t, c.Set(ctx, strconv.Itoa(i), large, 0))
+		}
+
+		_, err = c.Get(ctx, "0")
+		require.ErrorIs(t, err, ErrCacheMiss)
+		_, err = c.Get(ctx, "2")
+		require.NoError(t, err)
+		require.LessOrEqual(t, c.shards[0].size, 1<<20)
+	})
+}
+
+func Test_lruCache_Flush(t *testing.T) {
+	c, err := newLRUCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1e6})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for i := range 100 {
+		require.NoError(t, c.Set(ctx, strconv.Itoa(i), []byte("value"), 0))
+	}
+
+	require.NoError(t, c.Flush())
+
+	for i := range 100 {
+		_, err = c.Get(ctx, strconv.Itoa(i))
+		require.ErrorIs(t, err, ErrCacheMiss)
+	}
+
+	require.NoError(t, c.Close())
+}
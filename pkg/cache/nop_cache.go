@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// nopCache is the distributed cache of the proxy without redis, e.g. in the embedded mode,
+// it stores nothing and always misses, so that the proxy caches in the local cache only.
+type nopCache struct{}
+
+var _ Interface = nopCache{}
+
+func (nopCache) Set(context.Context, string, []byte, time.Duration) error {
+	return nil
+}
+
+func (nopCache) Get(context.Context, string) ([]byte, error) {
+	return nil, ErrCacheMiss
+}
+
+func (nopCache) Del(context.Context, string) error {
+	return nil
+}
+
+func (nopCache) Close() error {
+	return nil
+}
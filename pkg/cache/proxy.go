@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/breaker"
 )
 
 type proxy struct {
@@ -17,38 +19,99 @@ type proxy struct {
 	localCacheTTL time.Duration
 	// remoteCacheTTL is the remote cache ttl
 	remoteCacheTTL time.Duration
+	// negativeTTL is the ttl of the negative entries, 0 disables negative caching
+	negativeTTL time.Duration
+
+	// bus publishes the invalidations of the deleted keys to the other instances, nil if disabled
+	bus *Bus
+	// unsubscribe stops subscribing to the invalidations, nil if disabled
+	unsubscribe func()
 }
 
-var _ Interface = (*proxy)(nil)
+// tombstone is the value of the negative entries, which is never a valid entry since it is neither
+// a version byte nor a printable byte
+var tombstone = []byte{0}
+
+var (
+	_ Interface     = (*proxy)(nil)
+	_ NegativeCache = (*proxy)(nil)
+	_ StatsGetter   = (*proxy)(nil)
+	_ LocalSetter   = (*proxy)(nil)
+)
 
-// NewProxy creates a new cache proxy, which contains a distributed cache and a local cache
-func NewProxy(c *configs.CacheConfig) (Interface, error) {
-	return newProxy(c)
+// NewProxy creates a new cache proxy, which contains a distributed cache and a local cache.
+// The distributed cache is guarded by the circuit breaker b if not nil, the proxy degrades to the local cache
+// while the circuit is open: the misses of the local cache are reported as ErrCacheMiss, and the writes only
+// populate the local cache. Without the redis config, the proxy caches in the local cache only,
+// and the negative entries are never cached. The local cache is invalidated by the bus if not nil,
+// the bus is shared by the proxies of an instance, and closed by its creator.
+func NewProxy(c *configs.CacheConfig, b *breaker.Breaker, bus *Bus) (Interface, error) {
+	return newProxy(c, b, bus)
 }
 
-func newProxy(c *configs.CacheConfig) (*proxy, error) {
+func newProxy(c *configs.CacheConfig, b *breaker.Breaker, bus *Bus) (*proxy, error) {
 	lc, err := NewLocalCache(c.LocalCache)
 	if err != nil {
 		return nil, err
 	}
 
-	return &proxy{
-		distributedCache: NewRedisRemoteCache(c.Redis),
+	if c.Redis == nil {
+		return &proxy{distributedCache: nopCache{}, localCache: lc, localCacheTTL: c.LocalCache.TTL}, nil
+	}
+
+	rc, err := NewRedisRemoteCache(c.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	if b != nil {
+		rc = newBreakerCache(rc, b)
+	}
+
+	p := &proxy{
+		distributedCache: rc,
 		localCache:       lc,
 		remoteCacheTTL:   c.Redis.TTL,
 		localCacheTTL:    c.LocalCache.TTL,
-	}, nil
+		negativeTTL:      c.NegativeTTL,
+	}
+
+	if bus != nil {
+		p.bus = bus
+		p.unsubscribe = bus.Subscribe(p.invalidate)
+	}
+
+	return p, nil
 }
 
-func (p *proxy) Set(ctx context.Context, k string, v []byte, ttl time.Duration) error {
-	if err := p.distributedCache.Set(ctx, k, v, ttl); err != nil {
-		return fmt.Errorf("failed to set distributed cache: %w", err)
+// invalidate evicts the local cache by the invalidation from the other instances
+func (p *proxy) invalidate(inv Invalidation) {
+	var err error
+
+	if !inv.Flush {
+		err = p.localCache.Del(context.Background(), inv.Key)
+	} else if f, ok := p.localCache.(Flusher); ok {
+		err = f.Flush()
+	}
+
+	if err != nil {
+		slog.Error("failed to invalidate local cache", slog.Any("invalidation", inv), slog.Any("error", err))
 	}
+}
+
+// Set the k v pair to both caches, the local cache is set even if the distributed cache fails,
+// the unavailable distributed cache is not an error.
+func (p *proxy) Set(ctx context.Context, k string, v []byte, ttl time.Duration) error {
+	derr := p.distributedCache.Set(ctx, k, v, ttl)
 
-	if err := p.localCache.Set(ctx, k, v, ttl); err != nil {
+	if err := p.localCache.Set(ctx, k, v, min(ttl, p.localCacheTTL)); err != nil {
 		return fmt.Errorf("failed to set local cache: %w", err)
 	}
 
+	if derr != nil && !errors.Is(derr, ErrUnavailable) {
+		return fmt.Errorf("failed to set distributed cache: %w", derr)
+	}
+
 	return nil
 }
 
@@ -63,36 +126,113 @@ func (p *proxy) Get(ctx context.Context, k string) ([]byte, error) {
 		return nil, err
 	}
 
-	defer func() { // try to set local cache if get from distributed cache
-		if len(long) > 0 {
-			if err = p.localCache.Set(ctx, k, long, p.localCacheTTL); err != nil {
-				slog.ErrorContext(ctx, "failed to set local cache", slog.Any("error", err))
-			}
+	// second, try to get from distributed cache, fall back to the caller's source if it fails
+	long, ttl, err := p.getDistributed(ctx, k)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) && !errors.Is(err, ErrUnavailable) {
+			slog.WarnContext(ctx, "failed to get distributed cache, treat as cache miss", slog.Any("error", err))
 		}
-	}()
 
-	// second, try to get from distributed cache
-	long, err = p.distributedCache.Get(ctx, k) // need to fill the long variable
+		return nil, ErrCacheMiss
+	}
+
+	if bytes.Equal(long, tombstone) {
+		return nil, ErrNotFound
+	}
+
+	// try to set local cache, the local entry never outlives the distributed one
+	if ttl <= 0 || ttl > p.localCacheTTL {
+		ttl = p.localCacheTTL
+	}
 
-	return long, err
+	if err = p.localCache.Set(ctx, k, long, ttl); err != nil {
+		slog.ErrorContext(ctx, "failed to set local cache", slog.Any("error", err))
+	}
+
+	return long, nil
 }
 
-func (p *proxy) Del(ctx context.Context, k string) error {
-	if err := p.distributedCache.Del(ctx, k); err != nil {
-		return fmt.Errorf("failed to delete distributed cache: %w", err)
+// SetLocal sets the k v pair to the local cache only, the ttl is capped by the local cache ttl
+func (p *proxy) SetLocal(ctx context.Context, k string, v []byte, ttl time.Duration) error {
+	return p.localCache.Set(ctx, k, v, min(ttl, p.localCacheTTL))
+}
+
+// SetNotFound caches the key as not found in the distributed cache for the negative ttl,
+// the negative entries are never set to the local cache, so that they are overwritten on all instances
+// once the key is set.
+func (p *proxy) SetNotFound(ctx context.Context, k string) error {
+	if p.negativeTTL <= 0 {
+		return nil
+	}
+
+	if err := p.distributedCache.Set(ctx, k, tombstone, p.negativeTTL); err != nil && !errors.Is(err, ErrUnavailable) {
+		return fmt.Errorf("failed to set distributed cache: %w", err)
 	}
 
+	return nil
+}
+
+// getDistributed gets the value and its remaining ttl from distributed cache,
+// if the distributed cache can not report the ttl, the local cache ttl is returned.
+func (p *proxy) getDistributed(ctx context.Context, k string) ([]byte, time.Duration, error) {
+	if c, ok := p.distributedCache.(TTLGetter); ok {
+		return c.GetWithTTL(ctx, k)
+	}
+
+	v, err := p.distributedCache.Get(ctx, k)
+
+	return v, p.localCacheTTL, err
+}
+
+// Del the key from both caches, the local cache is deleted first, so that it is deleted
+// even if the distributed cache is unavailable. The invalidation is published to the other instances
+// anyway, the unavailable distributed cache is logged and not an error.
+func (p *proxy) Del(ctx context.Context, k string) error {
 	if err := p.localCache.Del(ctx, k); err != nil {
 		return fmt.Errorf("failed to delete local cache: %w", err)
 	}
 
+	derr := p.distributedCache.Del(ctx, k)
+	if derr != nil && !errors.Is(derr, ErrUnavailable) {
+		return fmt.Errorf("failed to delete distributed cache: %w", derr)
+	}
+
+	if p.bus != nil {
+		if err := p.bus.PublishDel(ctx, k); err != nil {
+			if derr == nil {
+				return fmt.Errorf("failed to publish invalidation: %w", err)
+			}
+
+			slog.ErrorContext(ctx, "failed to publish invalidation", slog.String("key", k), slog.Any("error", err))
+		}
+	}
+
+	if derr != nil {
+		slog.WarnContext(ctx, "failed to delete distributed cache, the entry expires by its ttl",
+			slog.String("key", k), slog.Any("error", derr))
+	}
+
 	return nil
 }
 
+// Close closes the caches and stops the invalidations of the local cache, the shared bus is not closed
 func (p *proxy) Close() error {
+	if p.unsubscribe != nil {
+		p.unsubscribe()
+	}
+
 	if err := p.distributedCache.Close(); err != nil {
 		return err
 	}
 
 	return p.localCache.Close()
 }
+
+// Stats returns the statistics of the local cache, the zero Stats if the local cache does not report them
+func (p *proxy) Stats() Stats {
+	if s, ok := p.localCache.(StatsGetter); ok {
+		return s.Stats()
+	}
+
+	return Stats{}
+}
@@ -6,14 +6,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/require"
 
+	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
 	"github.com/beihai0xff/turl/internal/tests/mocks"
+	"github.com/beihai0xff/turl/pkg/breaker"
 )
 
 func TestProxySet(t *testing.T) {
-	p, err := NewProxy(tests.GlobalConfig.Cache)
+	p, err := NewProxy(tests.GlobalConfig.Cache, nil, nil)
 	require.NoError(t, err)
 
 	ctx := context.Background()
@@ -22,7 +25,7 @@ func TestProxySet(t *testing.T) {
 }
 
 func TestProxyGet(t *testing.T) {
-	p, err := newProxy(tests.GlobalConfig.Cache)
+	p, err := newProxy(tests.GlobalConfig.Cache, nil, nil)
 	require.NoError(t, err)
 
 	ctx := context.Background()
@@ -47,10 +50,22 @@ func TestProxyGet(t *testing.T) {
 	got, err = p.localCache.Get(ctx, k)
 	require.NoError(t, err)
 	require.Equal(t, v, got)
+
+	// test the local entry never outlives the distributed one
+	k = "key_get3"
+	require.NoError(t, p.distributedCache.Set(ctx, k, v, 100*time.Millisecond))
+	got, err = p.Get(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	time.Sleep(200 * time.Millisecond)
+	got, err = p.localCache.Get(ctx, k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+	require.Nil(t, got)
 }
 
 func TestProxyDel(t *testing.T) {
-	c, err := newProxy(tests.GlobalConfig.Cache)
+	c, err := newProxy(tests.GlobalConfig.Cache, nil, nil)
 	require.NoError(t, err)
 
 	k, v := "key", []byte("value")
@@ -80,22 +95,144 @@ func TestProxyDel(t *testing.T) {
 
 	testError := errors.New("test error")
 	t.Run("del_remote_cache_error", func(t *testing.T) {
+		lc.EXPECT().Del(ctx, k).Return(nil).Times(1)
 		rc.EXPECT().Del(ctx, k).Return(testError).Times(1)
 
 		require.ErrorIs(t, c.Del(ctx, k), testError)
 	})
 
 	t.Run("del_local_cache_error", func(t *testing.T) {
-		rc.EXPECT().Del(ctx, k).Return(nil).Times(1)
 		lc.EXPECT().Del(ctx, k).Return(testError).Times(1)
 
 		require.ErrorIs(t, c.Del(ctx, k), testError)
 	})
 }
 
+func TestProxySetNotFound(t *testing.T) {
+	// use local caches as the distributed cache
+	c := &configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 16}
+	lc, err := newLocalCache(c)
+	require.NoError(t, err)
+	rc, err := newLocalCache(c)
+	require.NoError(t, err)
+
+	p := &proxy{
+		localCache:       lc,
+		distributedCache: rc,
+		remoteCacheTTL:   time.Minute,
+		localCacheTTL:    time.Minute,
+		negativeTTL:      100 * time.Millisecond,
+	}
+	t.Cleanup(func() { p.Close() })
+
+	ctx, k := context.Background(), "key_not_found"
+	require.NoError(t, p.SetNotFound(ctx, k))
+
+	got, err := p.Get(ctx, k)
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Nil(t, got)
+
+	// the negative entries are never set to the local cache
+	_, err = lc.Get(ctx, k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	// the negative entries are overwritten when the key is set
+	require.NoError(t, p.Set(ctx, k, []byte("value"), time.Minute))
+	got, err = p.Get(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), got)
+
+	// the negative entries expire after the negative ttl
+	k = "key_not_found2"
+	require.NoError(t, p.SetNotFound(ctx, k))
+	time.Sleep(200 * time.Millisecond)
+	_, err = p.Get(ctx, k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	// negative caching is disabled
+	p.negativeTTL = 0
+	require.NoError(t, p.SetNotFound(ctx, k))
+	_, err = p.Get(ctx, k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestProxy_localOnly(t *testing.T) {
+	p, err := newProxy(&configs.CacheConfig{
+		LocalCache:  &configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 16},
+		NegativeTTL: time.Minute,
+	}, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	ctx, k, v := context.Background(), "key_local_only", []byte("value")
+	require.NoError(t, p.Set(ctx, k, v, time.Hour))
+	got, err := p.Get(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	require.NoError(t, p.Del(ctx, k))
+	_, err = p.Get(ctx, k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	// the negative entries are never cached without the distributed cache
+	require.NoError(t, p.SetNotFound(ctx, k))
+	_, err = p.Get(ctx, k)
+	require.ErrorIs(t, err, ErrCacheMiss)
+}
+
 func TestProxyClose(t *testing.T) {
-	p, err := newProxy(tests.GlobalConfig.Cache)
+	p, err := newProxy(tests.GlobalConfig.Cache, nil, nil)
 	require.NoError(t, err)
 
 	require.NoError(t, p.Close())
 }
+
+func TestProxy_degraded(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx, v := context.Background(), []byte("value")
+
+	lc, err := newLocalCache(&configs.LocalCacheConfig{TTL: time.Minute, Capacity: 1000, MaxMemory: 16})
+	require.NoError(t, err)
+	rc, err := newRedisCache(&configs.RedisConfig{Addr: []string{s.Addr()}, DialTimeout: 100 * time.Millisecond, MaxConn: 1})
+	require.NoError(t, err)
+
+	b := breaker.New("redis cache", 2, time.Hour)
+	p := &proxy{
+		localCache:       lc,
+		distributedCache: newBreakerCache(rc, b),
+		remoteCacheTTL:   time.Minute,
+		localCacheTTL:    time.Minute,
+		negativeTTL:      time.Minute,
+	}
+	t.Cleanup(func() { p.Close() })
+
+	require.NoError(t, p.Set(ctx, "key1", v, time.Minute))
+	s.Close()
+
+	// the failures are reported as cache misses, until the circuit opens
+	for range 2 {
+		_, err = p.Get(ctx, "key2")
+		require.ErrorIs(t, err, ErrCacheMiss)
+	}
+
+	require.Equal(t, breaker.Open, b.State())
+
+	// the local cache is still served and populated while the circuit is open
+	got, err := p.Get(ctx, "key1")
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	require.NoError(t, p.Set(ctx, "key2", v, time.Minute))
+	got, err = p.Get(ctx, "key2")
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+
+	require.NoError(t, p.SetNotFound(ctx, "key3"))
+	_, err = p.Get(ctx, "key3")
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	// the unavailable distributed cache is not an error, and the local cache is deleted
+	require.NoError(t, p.Del(ctx, "key2"))
+	_, err = lc.Get(ctx, "key2")
+	require.ErrorIs(t, err, ErrCacheMiss)
+}
@@ -14,7 +14,10 @@ import (
 	redis2 "github.com/beihai0xff/turl/pkg/db/redis"
 )
 
-var _ Interface = (*redisCache)(nil)
+var (
+	_ Interface = (*redisCache)(nil)
+	_ TTLGetter = (*redisCache)(nil)
+)
 
 type redisCache struct {
 	rdb redis.UniversalClient
@@ -22,21 +25,27 @@ type redisCache struct {
 }
 
 // NewRedisRemoteCache returns a new redis cache
-func NewRedisRemoteCache(c *configs.RedisConfig) Interface {
+func NewRedisRemoteCache(c *configs.RedisConfig) (Interface, error) {
 	return newRedisCache(c)
 }
 
-func newRedisCache(c *configs.RedisConfig) *redisCache {
-	return &redisCache{
-		rdb: redis2.Client(c),
+func newRedisCache(c *configs.RedisConfig) (*redisCache, error) {
+	rdb, err := redis2.Client(c)
+	if err != nil {
+		return nil, err
 	}
+
+	return &redisCache{rdb: rdb}, nil
 }
 
 // Set the k v pair to the cache
 func (c *redisCache) Set(ctx context.Context, k string, v []byte, ttl time.Duration) error {
-	//nolint:gosec,mnd
-	ttl += time.Duration(rand.IntN(int(ttl / 10))) // add some jitter
-	return c.rdb.SetEx(ctx, k, v, ttl).Err()
+	// subtract some jitter, so that the entry never outlives the given ttl
+	if jitter := int64(ttl / 10); jitter > 0 { //nolint:mnd
+		ttl -= time.Duration(rand.Int64N(jitter)) //nolint:gosec
+	}
+
+	return c.rdb.Set(ctx, k, v, ttl).Err()
 }
 
 // Get the value by key
@@ -49,6 +58,29 @@ func (c *redisCache) Get(ctx context.Context, k string) ([]byte, error) {
 	return value, err
 }
 
+// GetWithTTL get the value and its remaining ttl by key
+func (c *redisCache) GetWithTTL(ctx context.Context, k string) ([]byte, time.Duration, error) {
+	pipe := c.rdb.Pipeline()
+	get, pttl := pipe.Get(ctx, k), pipe.PTTL(ctx, k)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, 0, ErrCacheMiss
+		}
+
+		return nil, 0, err
+	}
+
+	ttl := pttl.Val()
+	if ttl < 0 { // the key never expires
+		ttl = 0
+	}
+
+	v, err := get.Bytes()
+
+	return v, ttl, err
+}
+
 func (c *redisCache) Del(ctx context.Context, k string) error {
 	return c.rdb.Del(ctx, k).Err()
 }
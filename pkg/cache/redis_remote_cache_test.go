@@ -7,21 +7,28 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
 )
 
 func TestNewRedisCache(t *testing.T) {
-	got := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	got, err := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
 	require.NotNil(t, got)
 }
 
 func Test_newRedisCache(t *testing.T) {
-	got := NewRedisRemoteCache(tests.GlobalConfig.Cache.Redis)
+	got, err := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
 	require.NotNil(t, got)
+
+	_, err = newRedisCache(&configs.RedisConfig{Mode: configs.RedisStandalone, Addr: []string{"a:6379", "b:6379"}})
+	require.Error(t, err)
 }
 
 func Test_redisCache_Set(t *testing.T) {
-	c := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	c, err := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
 	t.Cleanup(
 		func() {
 			c.Close()
@@ -33,7 +40,8 @@ func Test_redisCache_Set(t *testing.T) {
 }
 
 func Test_redisCache_Get(t *testing.T) {
-	c := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	c, err := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
 	t.Cleanup(
 		func() {
 			c.Close()
@@ -52,8 +60,45 @@ func Test_redisCache_Get(t *testing.T) {
 	require.Nil(t, got)
 }
 
+func Test_redisCache_Set_ShortTTL(t *testing.T) {
+	c, err := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
+	t.Cleanup(
+		func() {
+			c.Close()
+		})
+
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "key_short_ttl", []byte("value"), 5*time.Nanosecond))
+}
+
+func Test_redisCache_GetWithTTL(t *testing.T) {
+	c, err := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
+	t.Cleanup(
+		func() {
+			c.Close()
+		})
+
+	ctx := context.Background()
+	k, v, ttl := "key_get_with_ttl", []byte("value"), time.Minute
+	require.NoError(t, c.Set(ctx, k, v, ttl))
+	got, remain, err := c.GetWithTTL(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+	require.LessOrEqual(t, remain, ttl)
+	require.Greater(t, remain, time.Duration(0))
+
+	// test cache miss
+	got, remain, err = c.GetWithTTL(ctx, "empty")
+	require.ErrorIs(t, err, ErrCacheMiss)
+	require.Nil(t, got)
+	require.Zero(t, remain)
+}
+
 func Test_redisCache_Del(t *testing.T) {
-	c := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	c, err := newRedisCache(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
 	t.Cleanup(
 		func() {
 			c.Close()
@@ -0,0 +1,147 @@
+// Package canonical provides the canonicalization of the long URLs, so that the equivalent URLs are deduplicated
+package canonical
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+// ErrInvalidURL is returned if the long URL is not an absolute URL
+var ErrInvalidURL = errors.New("canonical: invalid URL")
+
+// defaultPorts are the default ports of the schemes, which are stripped from the host
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Canonicalizer canonicalizes the long URLs by the rules of the config
+type Canonicalizer struct {
+	// sortQuery is whether to sort the query parameters by name
+	sortQuery bool
+	// drop is the lower-cased names of the query parameters to drop
+	drop map[string]struct{}
+	// dropPrefixes is the lower-cased name prefixes of the query parameters to drop
+	dropPrefixes []string
+}
+
+// New returns the Canonicalizer of the config, nil if c is nil or disabled
+func New(c *configs.CanonicalConfig) *Canonicalizer {
+	if c == nil || !c.Enable {
+		return nil
+	}
+
+	canon := &Canonicalizer{sortQuery: c.SortQuery, drop: make(map[string]struct{}, len(c.DropParams))}
+	for _, p := range c.DropParams {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			canon.dropPrefixes = append(canon.dropPrefixes, prefix)
+		} else {
+			canon.drop[p] = struct{}{}
+		}
+	}
+
+	return canon
+}
+
+// Canonicalize returns the canonical form of the long URL: the scheme and host are lower-cased, the IDNs are
+// converted to punycode, the default port and the empty query are stripped, the empty path is replaced by /,
+// and the query parameters are dropped and sorted as configured. The path and fragment are kept as they are.
+func (c *Canonicalizer) Canonicalize(long []byte) ([]byte, error) {
+	u, err := url.Parse(string(long))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Host, err = canonicalHost(u.Scheme, u.Hostname(), u.Port()); err != nil {
+		return nil, err
+	}
+
+	if u.Path == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+
+	u.RawQuery, u.ForceQuery = c.query(u.RawQuery), false
+
+	return []byte(u.String()), nil
+}
+
+// canonicalHost returns the lower-cased punycode host, with the port if it is not the default one of the scheme
+func canonicalHost(scheme, host, port string) (string, error) {
+	host, err := idna.Punycode.ToASCII(strings.ToLower(host))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+
+	if strings.Contains(host, ":") { // IPv6 literal
+		host = "[" + host + "]"
+	}
+
+	if port == "" || port == defaultPorts[scheme] {
+		return host, nil
+	}
+
+	return host + ":" + port, nil
+}
+
+// query drops and sorts the query parameters as configured, the kept parameters are not re-encoded
+func (c *Canonicalizer) query(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	params := strings.Split(raw, "&")
+	kept := params[:0]
+
+	for _, p := range params {
+		if p != "" && !c.dropped(paramName(p)) {
+			kept = append(kept, p)
+		}
+	}
+
+	if c.sortQuery {
+		slices.SortStableFunc(kept, func(a, b string) int {
+			return strings.Compare(paramName(a), paramName(b))
+		})
+	}
+
+	return strings.Join(kept, "&")
+}
+
+// dropped reports whether the query parameter of the name is configured to drop
+func (c *Canonicalizer) dropped(name string) bool {
+	name = strings.ToLower(name)
+	if _, ok := c.drop[name]; ok {
+		return true
+	}
+
+	for _, prefix := range c.dropPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// paramName returns the unescaped name of the query parameter
+func paramName(param string) string {
+	name, _, _ := strings.Cut(param, "=")
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+
+	return name
+}
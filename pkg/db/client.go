@@ -0,0 +1,102 @@
+// Package db provides the database connections, MySQL, PostgreSQL or the embedded SQLite
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+// New create a new gorm db of the config driver, the driver errors are translated to the gorm errors,
+// e.g. gorm.ErrDuplicatedKey, so that they are handled identically on all drivers
+func New(c *configs.DBConfig) (*gorm.DB, error) {
+	// l := logger.New(
+	// 	log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
+	// 	logger.Config{
+	// 		SlowThreshold:             time.Second, // Slow SQL threshold
+	// 		LogLevel:                  logger.Warn, // Log level
+	// 		IgnoreRecordNotFoundError: true,        // Ignore ErrRecordNotFound error for logger
+	// 		ParameterizedQueries:      false,       // Log the parameter values
+	// 		Colorful:                  true,        // Disable color
+	// 	},
+	// )
+	dialector, err := open(c)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:                 logger.Default,
+		SkipDefaultTransaction: true,
+		TranslateError:         true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Driver == configs.DriverSQLite {
+		// sqlite allows only one writer, the single connection serializes the writes instead of failing them
+		// with SQLITE_BUSY, and is never closed, so that the in-memory database lives as long as the db
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetMaxOpenConns(1)
+
+		return db, nil
+	}
+
+	sqlDB.SetMaxIdleConns(c.MaxConn)
+	sqlDB.SetMaxOpenConns(c.MaxConn)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+	sqlDB.SetConnMaxIdleTime(time.Minute)
+
+	return db, nil
+}
+
+// open returns the gorm dialector of the config driver
+func open(c *configs.DBConfig) (gorm.Dialector, error) {
+	switch c.Driver {
+	case "", configs.DriverMySQL:
+		return mysql.Open(c.DSN), nil
+	case configs.DriverPostgres:
+		return postgres.Open(c.DSN), nil
+	case configs.DriverSQLite:
+		return sqlite.Open(sqliteDSN(c.DSN)), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", c.Driver)
+	}
+}
+
+// sqlitePragmas are the default pragmas of the sqlite file: the writes of the other connection pools and processes,
+// e.g. the cli commands, wait for the lock instead of failing, and the reads are not blocked by the writes
+var sqlitePragmas = []string{"busy_timeout(5000)", "journal_mode(WAL)"}
+
+// sqliteDSN sets the default pragmas of the sqlite file which are not set in the dsn
+func sqliteDSN(dsn string) string {
+	for _, pragma := range sqlitePragmas {
+		name, _, _ := strings.Cut(pragma, "(")
+		if strings.Contains(dsn, name) {
+			continue
+		}
+
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+
+		dsn += sep + "_pragma=" + pragma
+	}
+
+	return dsn
+}
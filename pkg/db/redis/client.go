@@ -2,6 +2,12 @@
 package redis
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/redis/go-redis/v9"
 
 	"github.com/beihai0xff/turl/configs"
@@ -10,12 +16,81 @@ import (
 // Nil is the redis.Nil, used to check if a key exists
 var Nil = redis.Nil
 
-// Client returns a redis client
-func Client(c *configs.RedisConfig) redis.UniversalClient {
-	return redis.NewUniversalClient(&redis.UniversalOptions{
-		Addrs:          c.Addr,
-		DialTimeout:    c.DialTimeout,
-		MaxIdleConns:   c.MaxConn,
-		MaxActiveConns: c.MaxConn,
-	})
+// errInvalidCA is returned when no certificate is found in the CA file
+var errInvalidCA = errors.New("redis: no certificate found in CA file")
+
+// Client returns a redis client of the deployment mode
+func Client(c *configs.RedisConfig) (redis.UniversalClient, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            c.Addr,
+		MasterName:       c.MasterName,
+		Username:         c.Username,
+		Password:         c.Password,
+		SentinelUsername: c.SentinelUsername,
+		SentinelPassword: c.SentinelPassword,
+		DB:               c.DB,
+		DialTimeout:      c.DialTimeout,
+		MaxIdleConns:     c.MaxConn,
+		MaxActiveConns:   c.MaxConn,
+	}
+
+	if c.TLS != nil && c.TLS.Enable {
+		var err error
+		if opts.TLSConfig, err = tlsConfig(c.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.Mode {
+	case "":
+		return redis.NewUniversalClient(opts), nil
+	case configs.RedisStandalone:
+		if len(c.Addr) != 1 {
+			return nil, fmt.Errorf("redis: standalone mode requires exactly one address, got %d", len(c.Addr))
+		}
+
+		return redis.NewClient(opts.Simple()), nil
+	case configs.RedisSentinel:
+		if c.MasterName == "" {
+			return nil, errors.New("redis: sentinel mode requires the master name")
+		}
+
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case configs.RedisCluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("redis: unknown mode %q", c.Mode)
+	}
+}
+
+// tlsConfig returns the TLS config of the redis connections
+func tlsConfig(c *configs.TLSConfig) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errInvalidCA
+		}
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
 }
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+func TestClient_mode(t *testing.T) {
+	addr := []string{"localhost:6379"}
+
+	for _, tt := range []struct {
+		c    *configs.RedisConfig
+		want redis.UniversalClient
+	}{
+		{&configs.RedisConfig{Addr: addr}, &redis.Client{}},
+		{&configs.RedisConfig{Addr: []string{"a:6379", "b:6379"}}, &redis.ClusterClient{}},
+		{&configs.RedisConfig{Addr: addr, MasterName: "master"}, &redis.Client{}},
+		{&configs.RedisConfig{Mode: configs.RedisStandalone, Addr: addr}, &redis.Client{}},
+		{&configs.RedisConfig{Mode: configs.RedisSentinel, Addr: addr, MasterName: "master"}, &redis.Client{}},
+		{&configs.RedisConfig{Mode: configs.RedisCluster, Addr: addr}, &redis.ClusterClient{}},
+	} {
+		got, err := Client(tt.c)
+		require.NoError(t, err)
+		require.IsType(t, tt.want, got)
+		require.NoError(t, got.Close())
+	}
+
+	for _, c := range []*configs.RedisConfig{
+		{Mode: configs.RedisStandalone, Addr: []string{"a:6379", "b:6379"}},
+		{Mode: configs.RedisSentinel, Addr: addr},
+		{Mode: "unknown", Addr: addr},
+		{Addr: addr, TLS: &configs.TLSConfig{Enable: true, CAFile: "not_exist.pem"}},
+	} {
+		_, err := Client(c)
+		require.Error(t, err)
+	}
+}
+
+func TestClient_auth(t *testing.T) {
+	s := miniredis.RunT(t)
+	s.RequireUserAuth("turl", "secret")
+	require.NoError(t, s.DB(2).Set("key", "value"))
+
+	rdb, err := Client(&configs.RedisConfig{
+		Mode: configs.RedisStandalone, Addr: []string{s.Addr()}, Username: "turl", Password: "secret", DB: 2, MaxConn: 1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { rdb.Close() })
+
+	got, err := rdb.Get(context.Background(), "key").Result()
+	require.NoError(t, err)
+	require.Equal(t, "value", got)
+
+	rdb, err = Client(&configs.RedisConfig{Addr: []string{s.Addr()}, Username: "turl", Password: "wrong", MaxConn: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { rdb.Close() })
+	require.Error(t, rdb.Ping(context.Background()).Err())
+}
+
+func TestClient_tls(t *testing.T) {
+	dir := t.TempDir()
+	ca, cert := writeCertificate(t, dir)
+
+	s, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	rdb, err := Client(&configs.RedisConfig{
+		Addr: []string{s.Addr()}, MaxConn: 1, DialTimeout: time.Second,
+		TLS: &configs.TLSConfig{Enable: true, CAFile: ca, ServerName: "localhost"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { rdb.Close() })
+	require.NoError(t, rdb.Ping(context.Background()).Err())
+
+	// the server certificate is not trusted without the CA
+	rdb, err = Client(&configs.RedisConfig{
+		Addr: []string{s.Addr()}, MaxConn: 1, DialTimeout: time.Second, TLS: &configs.TLSConfig{Enable: true},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { rdb.Close() })
+	require.Error(t, rdb.Ping(context.Background()).Err())
+}
+
+func Test_tlsConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca, _ := writeCertificate(t, dir)
+
+	conf, err := tlsConfig(&configs.TLSConfig{
+		Enable: true, CAFile: ca, CertFile: ca, KeyFile: filepath.Join(dir, "key.pem"), ServerName: "redis",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, conf.RootCAs)
+	require.Len(t, conf.Certificates, 1)
+	require.Equal(t, "redis", conf.ServerName)
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("invalid"), 0o600))
+	_, err = tlsConfig(&configs.TLSConfig{Enable: true, CAFile: invalid})
+	require.ErrorIs(t, err, errInvalidCA)
+
+	_, err = tlsConfig(&configs.TLSConfig{Enable: true, CertFile: invalid, KeyFile: invalid})
+	require.Error(t, err)
+}
+
+// writeCertificate writes a self-signed certificate of localhost and its key to dir as cert.pem and key.pem
+func writeCertificate(t *testing.T, dir string) (string, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	certFile := filepath.Join(dir, "cert.pem")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0o600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	return certFile, cert
+}
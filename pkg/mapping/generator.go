@@ -0,0 +1,156 @@
+// generator.go provides generators of the short IDs, the short code is the Base58 encoded short ID
+
+package mapping
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+const (
+	// feistelBits is the bit width of the feistel permutation domain,
+	// 2^40 < 58^7, so the permuted short IDs are encoded in no more than seven characters
+	feistelBits = 40
+	// feistelHalfBits is the bit width of each half of the feistel network
+	feistelHalfBits = feistelBits / 2
+	// feistelHalfMask is the mask of each half of the feistel network
+	feistelHalfMask = 1<<feistelHalfBits - 1
+	// feistelRounds is the rounds of the feistel network,
+	// four rounds with a pseudorandom round function is enough for a pseudorandom permutation
+	feistelRounds = 4
+
+	// randomCodeLength is the length of the random short codes
+	randomCodeLength = 7
+)
+
+var (
+	// ErrSequenceOverflow is returned when the sequence number is out of the domain of the generator
+	ErrSequenceOverflow = fmt.Errorf("%w: sequence number is out of the generator domain", ErrInvalidInput)
+	// ErrEmptyKey is returned when creating a keyed generator without key
+	ErrEmptyKey = errors.New("generator key should not be empty")
+
+	// randomMin and randomMax define the random short ID range [58^6, 58^7), all of them are seven characters
+	randomMin  = uint64(pow(carry, randomCodeLength-1))
+	randomMax  = uint64(pow(carry, randomCodeLength))
+	randomSize = new(big.Int).SetUint64(randomMax - randomMin)
+
+	_ Generator = Sequential{}
+	_ Generator = (*Feistel)(nil)
+	_ Generator = Random{}
+)
+
+// Generator generates the short ID of a new short URL from the sequence number,
+// the short code is the Base58 encoded short ID.
+// The generated short ID may collide with the existing ones, e.g. the random short IDs,
+// the caller should retry with another sequence number if collided.
+type Generator interface {
+	// Generate returns the short ID of the sequence number
+	Generate(seq uint64) (uint64, error)
+	// Range returns the range [lo, hi) of the short IDs generated from the sequence numbers in [seqMin, seqMax)
+	Range(seqMin, seqMax uint64) (lo, hi uint64)
+}
+
+// NewGenerator returns the Generator of the config, the Sequential generator is returned if c is nil
+func NewGenerator(c *configs.GeneratorConfig) (Generator, error) {
+	if c == nil {
+		return Sequential{}, nil
+	}
+
+	switch c.Type {
+	case "", configs.GeneratorSequential:
+		return Sequential{}, nil
+	case configs.GeneratorFeistel:
+		return NewFeistel([]byte(c.Key))
+	case configs.GeneratorRandom:
+		return Random{}, nil
+	default:
+		return nil, fmt.Errorf("unknown generator type %q", c.Type)
+	}
+}
+
+// Sequential uses the sequence number as the short ID, the short codes are short but guessable
+type Sequential struct{}
+
+// Generate returns the sequence number itself
+func (Sequential) Generate(seq uint64) (uint64, error) {
+	return seq, nil
+}
+
+// Range returns the range of the sequence numbers
+func (Sequential) Range(seqMin, seqMax uint64) (uint64, uint64) {
+	return seqMin, seqMax
+}
+
+// Feistel permutes the sequence number with a keyed feistel network,
+// the short codes look random but never collide, since the permutation is bijective.
+type Feistel struct {
+	key []byte
+}
+
+// NewFeistel returns a new Feistel generator with the secret key,
+// the key must not be changed once the short URLs are created, otherwise the new short IDs may collide.
+func NewFeistel(key []byte) (*Feistel, error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	}
+
+	return &Feistel{key: key}, nil
+}
+
+// Generate returns the permuted sequence number
+func (f *Feistel) Generate(seq uint64) (uint64, error) {
+	if seq>>feistelBits != 0 {
+		return 0, ErrSequenceOverflow
+	}
+
+	left, right := seq>>feistelHalfBits, seq&feistelHalfMask
+	for i := range feistelRounds {
+		left, right = right, left^f.round(i, right)
+	}
+
+	return left<<feistelHalfBits | right, nil
+}
+
+// Range returns the permutation domain, the permuted short IDs spread over the whole domain
+func (f *Feistel) Range(uint64, uint64) (uint64, uint64) {
+	return 0, 1 << feistelBits
+}
+
+// round is the round function of the feistel network, the HMAC-SHA256 of the round number and the half
+func (f *Feistel) round(i int, half uint64) uint64 {
+	var b [9]byte
+
+	b[0] = byte(i)
+	binary.BigEndian.PutUint64(b[1:], half)
+
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write(b[:])
+
+	return binary.BigEndian.Uint64(mac.Sum(nil)) & feistelHalfMask
+}
+
+// Random generates the random short IDs of seven characters, the sequence number is ignored,
+// the short IDs may collide with the existing ones, the caller should retry if collided.
+type Random struct{}
+
+// Generate returns a random short ID
+func (Random) Generate(uint64) (uint64, error) {
+	n, err := rand.Int(rand.Reader, randomSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random short ID: %w", err)
+	}
+
+	return randomMin + n.Uint64(), nil
+}
+
+// Range returns the random short ID range, the sequence numbers are ignored
+func (Random) Range(uint64, uint64) (uint64, uint64) {
+	return randomMin, randomMax
+}
@@ -0,0 +1,94 @@
+package mapping
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+func TestNewGenerator(t *testing.T) {
+	g, err := NewGenerator(nil)
+	require.NoError(t, err)
+	require.IsType(t, Sequential{}, g)
+
+	g, err = NewGenerator(&configs.GeneratorConfig{Type: configs.GeneratorFeistel, Key: "secret"})
+	require.NoError(t, err)
+	require.IsType(t, &Feistel{}, g)
+
+	g, err = NewGenerator(&configs.GeneratorConfig{Type: configs.GeneratorRandom})
+	require.NoError(t, err)
+	require.IsType(t, Random{}, g)
+
+	_, err = NewGenerator(&configs.GeneratorConfig{Type: configs.GeneratorFeistel})
+	require.ErrorIs(t, err, ErrEmptyKey)
+	_, err = NewGenerator(&configs.GeneratorConfig{Type: "unknown"})
+	require.Error(t, err)
+}
+
+func TestSequential_Generate(t *testing.T) {
+	got, err := Sequential{}.Generate(10000)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10000), got)
+}
+
+func TestFeistel_Generate(t *testing.T) {
+	f, err := NewFeistel([]byte("secret"))
+	require.NoError(t, err)
+
+	seen := make(map[uint64]struct{})
+
+	for seq := uint64(10000); seq < 20000; seq++ {
+		got, err := f.Generate(seq)
+		require.NoError(t, err)
+		require.Less(t, got, uint64(1)<<feistelBits)
+		require.LessOrEqual(t, len(Base58Encode(got)), randomCodeLength)
+		require.Equal(t, seq, f.invert(got))
+
+		_, ok := seen[got]
+		require.False(t, ok, "duplicated short ID %d", got)
+		seen[got] = struct{}{}
+	}
+
+	// the adjacent sequence numbers are not adjacent after permutation
+	a, _ := f.Generate(10000)
+	b, _ := f.Generate(10001)
+	require.NotEqual(t, a+1, b)
+
+	// another key generates another permutation
+	other, _ := NewFeistel([]byte("another"))
+	c, _ := other.Generate(10000)
+	require.NotEqual(t, a, c)
+
+	_, err = f.Generate(1 << feistelBits)
+	require.ErrorIs(t, err, ErrSequenceOverflow)
+}
+
+func TestRandom_Generate(t *testing.T) {
+	seen := make(map[uint64]struct{})
+
+	for range 1000 {
+		got, err := Random{}.Generate(0)
+		require.NoError(t, err)
+		require.Len(t, Base58Encode(got), randomCodeLength)
+
+		decoded, err := Base58Decode(Base58Encode(got))
+		require.NoError(t, err)
+		require.Equal(t, got, decoded)
+
+		seen[got] = struct{}{}
+	}
+
+	require.Greater(t, len(seen), 990)
+}
+
+// invert returns the sequence number of the permuted short ID
+func (f *Feistel) invert(short uint64) uint64 {
+	left, right := short>>feistelHalfBits, short&feistelHalfMask
+	for i := feistelRounds - 1; i >= 0; i-- {
+		left, right = right^f.round(i, left), left
+	}
+
+	return left<<feistelHalfBits | right
+}
@@ -2,15 +2,34 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/breaker"
 	"github.com/beihai0xff/turl/pkg/workqueue"
 )
 
+const (
+	// HealthOK is the health status if all the dependencies are healthy
+	HealthOK = "ok"
+	// HealthDegraded is the health status if any dependency is unavailable, the server serves with fallbacks
+	HealthDegraded = "degraded"
+
+	// HeaderAPIKey is the header of the API key, used if the Authorization header is not set
+	HeaderAPIKey = "X-API-Key"
+	// bearerPrefix is the prefix of the bearer token in the Authorization header
+	bearerPrefix = "Bearer "
+)
+
 // Logger returns a middleware that logs the request.
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -53,13 +72,116 @@ func RateLimiter(limiter workqueue.RateLimiter[any]) gin.HandlerFunc {
 	}
 }
 
-// HealthCheck returns a middleware that checks the health of the server.
-func HealthCheck(healthCheckPath string) gin.HandlerFunc {
+// Reserver reserves a token from the token bucket of the key
+type Reserver interface {
+	// Reserve takes a token from the token bucket of the key with the limit, and returns the state of the token bucket
+	Reserve(ctx context.Context, key string, limit workqueue.Limit) *workqueue.Reservation
+}
+
+// KeyedRateLimiter returns a middleware that limits the requests of each key with its own token bucket,
+// the key and limit of the request are returned by keyFunc. The RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and Retry-After headers are set if the state of the token bucket is known.
+func KeyedRateLimiter(r Reserver, keyFunc func(c *gin.Context) (string, workqueue.Limit)) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.URL.Path == healthCheckPath {
-			c.AbortWithStatusJSON(http.StatusOK, gin.H{"status": "ok"})
-		} else {
+		key, limit := keyFunc(c)
+		res := r.Reserve(c, key, limit)
+
+		if res.Limit > 0 {
+			c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+			c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			c.Header("RateLimit-Reset", seconds(res.ResetAfter))
+		}
+
+		if !res.OK {
+			if res.Limit > 0 {
+				c.Header("Retry-After", seconds(max(res.RetryAfter, time.Second)))
+			}
+
+			slog.Warn("rate limit exceeded", slog.String("key", key), slog.String("ip", c.ClientIP()))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// seconds formats the duration as the number of seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Auth returns a middleware that authenticates the request by the API key,
+// the key is read from the bearer token of the Authorization header or the X-API-Key header,
+// and the API key of the caller is carried by the request context.
+func Auth(a apikey.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderAPIKey)
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			if !strings.HasPrefix(auth, bearerPrefix) {
+				unauthorized(c)
+				return
+			}
+
+			key = strings.TrimPrefix(auth, bearerPrefix)
+		}
+
+		if key == "" {
+			unauthorized(c)
+			return
+		}
+
+		k, err := a.Authenticate(c, key)
+		if err != nil {
+			if errors.Is(err, apikey.ErrInvalidKey) {
+				slog.Warn("invalid API key", slog.String("ip", c.ClientIP()))
+				unauthorized(c)
+
+				return
+			}
+
+			slog.Error("failed to authenticate API key", slog.Any("error", err))
+			c.AbortWithStatus(http.StatusInternalServerError)
+
+			return
+		}
+
+		c.Request = c.Request.WithContext(apikey.NewContext(c.Request.Context(), k))
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// HealthCheck returns a middleware that checks the health of the server, the states of the circuit breakers
+// of the dependencies are reported, and the status is degraded if any circuit is not closed.
+// The server still serves while degraded, so the status code is always 200.
+func HealthCheck(healthCheckPath string, breakers ...*breaker.Breaker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.URL.Path != healthCheckPath {
 			c.Next()
+			return
 		}
+
+		if len(breakers) == 0 {
+			c.AbortWithStatusJSON(http.StatusOK, gin.H{"status": HealthOK})
+			return
+		}
+
+		status, states := HealthOK, make(map[string]string, len(breakers))
+		for _, b := range breakers {
+			state := b.State()
+			if state != breaker.Closed {
+				status = HealthDegraded
+			}
+
+			states[b.Name()] = state.String()
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{"status": status, "breakers": states})
 	}
 }
@@ -2,16 +2,23 @@ package middleware
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 
+	"github.com/beihai0xff/turl/internal/tests"
+	"github.com/beihai0xff/turl/pkg/apikey"
+	"github.com/beihai0xff/turl/pkg/breaker"
+	"github.com/beihai0xff/turl/pkg/db/redis"
 	"github.com/beihai0xff/turl/pkg/workqueue"
 )
 
@@ -106,6 +113,111 @@ func TestRateLimiter(t *testing.T) {
 	require.Equal(t, http.StatusTooManyRequests, w.Code)
 }
 
+func TestKeyedRateLimiter(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	rdb, err := redis.Client(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
+	limiter := workqueue.NewItemRedisTokenRateLimiter[string](rdb, "test_KeyedRateLimiter", 1, 1, time.Second)
+	t.Cleanup(func() {
+		limiter.Forget(context.Background(), "one")
+		limiter.Forget(context.Background(), "two")
+	})
+
+	r := gin.New()
+	r.Use(KeyedRateLimiter(limiter, func(c *gin.Context) (string, workqueue.Limit) {
+		return c.GetHeader("X-Key"), workqueue.Limit{Rate: 1, Burst: 2}
+	}))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do("one")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "1", w.Header().Get("RateLimit-Reset"))
+	require.Empty(t, w.Header().Get("Retry-After"))
+
+	w = do("one")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+
+	w = do("one")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// the other key has its own token bucket
+	require.Equal(t, http.StatusOK, do("two").Code)
+}
+
+type testAuthenticator map[string]*apikey.APIKey
+
+func (a testAuthenticator) Authenticate(_ context.Context, key string) (*apikey.APIKey, error) {
+	if key == "error" {
+		return nil, errors.New("test error")
+	}
+
+	if k, ok := a[key]; ok {
+		return k, nil
+	}
+
+	return nil, apikey.ErrInvalidKey
+}
+
+func TestAuth(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+
+	r.Use(Auth(testAuthenticator{"valid": {Tenant: "tenant-a"}}))
+
+	r.GET("/ping", func(c *gin.Context) {
+		k, ok := apikey.FromContext(c)
+		require.True(t, ok)
+		c.String(http.StatusOK, k.Tenant)
+	})
+
+	for _, tc := range []struct {
+		name, header, value string
+		want                int
+	}{
+		{"Bearer", "Authorization", "Bearer valid", http.StatusOK},
+		{"APIKeyHeader", HeaderAPIKey, "valid", http.StatusOK},
+		{"Missing", "", "", http.StatusUnauthorized},
+		{"NotBearer", "Authorization", "Basic dmFsaWQ=", http.StatusUnauthorized},
+		{"InvalidKey", "Authorization", "Bearer invalid", http.StatusUnauthorized},
+		{"AuthenticateFailed", HeaderAPIKey, "error", http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, tc.want, w.Code)
+
+			if tc.want == http.StatusOK {
+				require.Equal(t, "tenant-a", w.Body.String())
+			}
+		})
+	}
+}
+
 func TestHealthCheck(t *testing.T) {
 	gin.SetMode(gin.ReleaseMode)
 
@@ -127,3 +239,24 @@ func TestHealthCheck(t *testing.T) {
 	require.Equal(t, http.StatusOK, w.Code)
 	require.Equal(t, `{"status":"ok"}`, w.Body.String())
 }
+
+func TestHealthCheck_breakers(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	b := breaker.New("redis", 1, time.Minute)
+	r := gin.New()
+	r.Use(HealthCheck("/healthcheck", b))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"status":"ok","breakers":{"redis":"closed"}}`, w.Body.String())
+
+	require.True(t, b.Allow())
+	b.Done(errors.New("test error"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"status":"degraded","breakers":{"redis":"open"}}`, w.Body.String())
+}
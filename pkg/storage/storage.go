@@ -2,9 +2,17 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Ensuring that *storage implements the Storage interface
@@ -13,22 +21,118 @@ var _ Storage = (*storage)(nil)
 // Storage is an interface that defines the methods that a storage system must implement.
 type Storage interface {
 	// Insert adds a new TinyURL record to the storage.
-	Insert(ctx context.Context, short uint64, longURL []byte) (*TinyURL, error)
-	// GetByLongURL retrieves a TinyURL record by its original URL.
-	GetByLongURL(ctx context.Context, long []byte) (*TinyURL, error)
-	// GetByShortID retrieves a TinyURL record by its short ID.
-	GetByShortID(ctx context.Context, short uint64) (*TinyURL, error)
-	// Delete a short link by short id
-	Delete(ctx context.Context, short uint64) error
+	Insert(ctx context.Context, short uint64, longURL []byte, opts ...InsertOption) (*TinyURL, error)
+	// BatchInsert adds multiple TinyURL records in a single statement, and returns the inserted records,
+	// the records which conflict with the existing ones are skipped, including the ones whose short IDs collide.
+	BatchInsert(ctx context.Context, records []*TinyURL) ([]*TinyURL, error)
+	// GetByLongURL retrieves the deduplicated TinyURL record by its original URL.
+	GetByLongURL(ctx context.Context, long []byte, opts ...QueryOption) (*TinyURL, error)
+	// ListByLongURL lists all the TinyURL records of the original URL in ID order,
+	// both the deduplicated record and the duplicated ones.
+	ListByLongURL(ctx context.Context, long []byte, opts ...QueryOption) ([]*TinyURL, error)
+	// GetByShortID retrieves a TinyURL record of the domain by its short ID.
+	GetByShortID(ctx context.Context, domain string, short uint64) (*TinyURL, error)
+	// GetByAlias retrieves a TinyURL record of the domain by its custom alias.
+	GetByAlias(ctx context.Context, domain string, alias []byte) (*TinyURL, error)
+	// SetAlias sets the custom alias of a TinyURL record which has no alias yet.
+	SetAlias(ctx context.Context, domain string, short uint64, alias []byte) error
+	// SetExpiresAt sets the expiration time of a TinyURL record, nil means never expire.
+	SetExpiresAt(ctx context.Context, domain string, short uint64, expiresAt *time.Time) error
+	// Delete a short link of the domain by short id, the record is soft-deleted,
+	// and its long URL can be shortened again.
+	Delete(ctx context.Context, domain string, short uint64) error
+	// ListAfter lists at most limit TinyURL records whose IDs are greater than id in ID order,
+	// only the domain, short ID and alias of the records are loaded.
+	ListAfter(ctx context.Context, id uint, limit int) ([]*TinyURL, error)
+	// ListRecent lists at most limit unexpired TinyURL records whose IDs are less than before in descending ID order,
+	// zero before means no bound, so the most recently created records are listed first.
+	ListRecent(ctx context.Context, before uint, limit int) ([]*TinyURL, error)
 	// Close closes the storage.
 	Close() error
 }
 
-// TinyURL represents a shortened URL record.
+// TinyURL represents a shortened URL record, the short IDs, aliases and long URLs are unique in each domain.
+// The long URLs are unbounded, so they are deduplicated by the unique index of their hashes,
+// the duplicated records of a long URL are told apart by their dedup keys.
 type TinyURL struct {
 	gorm.Model
-	LongURL []byte `gorm:"type:VARCHAR(500);uniqueIndex;not null" json:"long_url"` // The original URL.
-	Short   uint64 `gorm:"type:BIGINT;uniqueIndex;not null" json:"short"`          // The shortened URL ID.
+	Domain       string     `gorm:"type:VARCHAR(128);not null;default:'';uniqueIndex:idx_domain_tenant_long_url_dedup,priority:1;uniqueIndex:idx_domain_short,priority:1;uniqueIndex:idx_domain_alias,priority:1" json:"domain"` // The short domain, empty for the default domain.
+	Tenant       string     `gorm:"type:VARCHAR(64);not null;default:'';uniqueIndex:idx_domain_tenant_long_url_dedup,priority:2" json:"tenant"`                                                                                  // The owner tenant.
+	LongURL      []byte     `gorm:"type:TEXT;not null" json:"long_url"`                                                                                                                                                          // The original URL.
+	LongURLHash  string     `gorm:"type:CHAR(64);uniqueIndex:idx_domain_tenant_long_url_dedup,priority:3" json:"-"`                                                                                                              // The hex SHA-256 of the long URL, set on creation, NULL only before the migration backfills it.
+	DedupKey     uint64     `gorm:"type:BIGINT;not null;default:0;uniqueIndex:idx_domain_tenant_long_url_dedup,priority:4" json:"-"`                                                                                             // 0 for the deduplicated record, the short ID for the duplicated records of the long URL.
+	Short        uint64     `gorm:"type:BIGINT;not null;uniqueIndex:idx_domain_short,priority:2" json:"short"`                                                                                                                   // The shortened URL ID.
+	Alias        []byte     `gorm:"type:VARCHAR(64);uniqueIndex:idx_domain_alias,priority:2" json:"alias"`                                                                                                                       // The custom alias, NULL if not set.
+	ExpiresAt    *time.Time `json:"expires_at"`                                                                                                                                                                                  // The expiration time, NULL means never expire.
+	RedirectMode uint16     `gorm:"type:SMALLINT;not null;default:0" json:"redirect_mode"`                                                                                                                                       // The redirect status code, 0 for the domain default, 200 for the HTML interstitial page.
+}
+
+// Expired reports whether the TinyURL record has expired.
+func (t *TinyURL) Expired() bool {
+	return t.ExpiresAt != nil && !time.Now().Before(*t.ExpiresAt)
+}
+
+// InsertOption is the optional field setter of the TinyURL record to insert.
+type InsertOption func(t *TinyURL)
+
+// WithAlias sets the custom alias of the TinyURL record to insert.
+func WithAlias(alias []byte) InsertOption {
+	return func(t *TinyURL) {
+		t.Alias = alias
+	}
+}
+
+// WithExpiresAt sets the expiration time of the TinyURL record to insert.
+func WithExpiresAt(expiresAt time.Time) InsertOption {
+	return func(t *TinyURL) {
+		t.ExpiresAt = &expiresAt
+	}
+}
+
+// WithRedirectMode sets the redirect mode of the TinyURL record to insert, see TinyURL.RedirectMode.
+func WithRedirectMode(mode uint16) InsertOption {
+	return func(t *TinyURL) {
+		t.RedirectMode = mode
+	}
+}
+
+// WithDuplicate creates the TinyURL record to insert besides the existing records of the same long URL,
+// its dedup key is the short ID, so the short ID must be set before.
+func WithDuplicate() InsertOption {
+	return func(t *TinyURL) {
+		t.DedupKey = t.Short
+	}
+}
+
+// WithDomain sets the short domain of the TinyURL record to insert.
+func WithDomain(domain string) InsertOption {
+	return func(t *TinyURL) {
+		t.Domain = domain
+	}
+}
+
+// WithTenant sets the owner tenant of the TinyURL record to insert.
+func WithTenant(tenant string) InsertOption {
+	return func(t *TinyURL) {
+		t.Tenant = tenant
+	}
+}
+
+// QueryOption is the optional condition of querying the TinyURL records.
+type QueryOption func(db *gorm.DB) *gorm.DB
+
+// OwnedBy limits the query to the TinyURL records owned by the tenant.
+func OwnedBy(tenant string) QueryOption {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant = ?", tenant)
+	}
+}
+
+// InDomain limits the query to the TinyURL records of the short domain.
+func InDomain(domain string) QueryOption {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("domain = ?", domain)
+	}
 }
 
 // TableName returns the table name of the TinyURL model.
@@ -36,6 +140,136 @@ func (TinyURL) TableName() string {
 	return "tiny_urls"
 }
 
+// BeforeCreate sets the hash of the long URL before the record is created.
+func (t *TinyURL) BeforeCreate(*gorm.DB) error {
+	t.LongURLHash = hashURL(t.LongURL)
+	return nil
+}
+
+// hashURL returns the hex SHA-256 of the long URL.
+func hashURL(long []byte) string {
+	sum := sha256.Sum256(long)
+	return hex.EncodeToString(sum[:])
+}
+
+// legacyIndexes are the unique indexes of the previous versions, the long URL was globally unique,
+// then unique per tenant, and the short ID and alias were globally unique before the short domains,
+// then the long URL was unique per domain and tenant before it is deduplicated by its hash,
+// at last the hash was unique per domain and tenant before the duplicated records are allowed.
+var legacyIndexes = []string{
+	"idx_tiny_urls_long_url", "idx_tenant_long_url", "idx_tiny_urls_short", "idx_tiny_urls_alias", "idx_domain_tenant_long_url",
+	"idx_domain_tenant_long_url_hash",
+}
+
+// backfillBatchSize is the number of the records to hash in each batch of the migration
+const backfillBatchSize = 1000
+
+// Migrate creates or updates the table of the TinyURL model. The legacy indexes are dropped first, so that the long URL
+// column can be widened, and the hashes of the existing records are backfilled before their unique index is created.
+// The aliases are case-sensitive in every dialect, so the alias column uses the binary collation on MySQL.
+// The records soft-deleted by the previous versions release their long URLs, as Delete does.
+func Migrate(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&TinyURL{}) {
+		if err := db.AutoMigrate(&TinyURL{}); err != nil {
+			return err
+		}
+
+		return binaryAlias(db)
+	}
+
+	for _, index := range legacyIndexes {
+		if !m.HasIndex(&TinyURL{}, index) {
+			continue
+		}
+
+		if err := m.DropIndex(&TinyURL{}, index); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasColumn(&TinyURL{}, "LongURLHash") {
+		if err := m.AddColumn(&TinyURL{}, "LongURLHash"); err != nil {
+			return err
+		}
+	}
+
+	if err := backfillHashes(db); err != nil {
+		return err
+	}
+
+	if !m.HasColumn(&TinyURL{}, "DedupKey") {
+		if err := m.AddColumn(&TinyURL{}, "DedupKey"); err != nil {
+			return err
+		}
+	}
+
+	if err := db.Unscoped().Model(&TinyURL{}).Where("deleted_at IS NOT NULL AND dedup_key = 0").
+		Update("dedup_key", gorm.Expr("short")).Error; err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&TinyURL{}); err != nil {
+		return err
+	}
+
+	return binaryAlias(db)
+}
+
+// binaryAlias changes the alias column to the binary collation on MySQL, whose default collation is
+// case-insensitive, the aliases are compared byte by byte on PostgreSQL and SQLite already.
+func binaryAlias(db *gorm.DB) error {
+	if db.Dialector.Name() != "mysql" {
+		return nil
+	}
+
+	var collation sql.NullString
+	if err := db.Raw("SELECT COLLATION_NAME FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", tableName(db), "alias").
+		Scan(&collation).Error; err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(collation.String, "_bin") {
+		return nil
+	}
+
+	return db.Exec("ALTER TABLE ? MODIFY alias VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NULL",
+		clause.Table{Name: tableName(db)}).Error
+}
+
+// tableName returns the table name of the TinyURL model
+func tableName(db *gorm.DB) string {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&TinyURL{}); err != nil {
+		return "tiny_urls"
+	}
+
+	return stmt.Schema.Table
+}
+
+// backfillHashes sets the hashes of the long URLs of the records created before the hash column
+func backfillHashes(db *gorm.DB) error {
+	for {
+		var records []*TinyURL
+		if err := db.Select("id", "long_url").Where("long_url_hash IS NULL").
+			Limit(backfillBatchSize).Find(&records).Error; err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			if err := db.Model(&TinyURL{}).Where("id = ?", r.ID).
+				UpdateColumn("long_url_hash", hashURL(r.LongURL)).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(records) < backfillBatchSize {
+			return nil
+		}
+	}
+}
+
 // storage is a concrete implementation of the Storage interface.
 type storage struct {
 	db *gorm.DB // Database client.
@@ -54,12 +288,16 @@ func newStorage(db *gorm.DB) *storage {
 }
 
 // Insert adds a new TinyURL record to the storage.
-func (s *storage) Insert(ctx context.Context, short uint64, long []byte) (*TinyURL, error) {
+func (s *storage) Insert(ctx context.Context, short uint64, long []byte, opts ...InsertOption) (*TinyURL, error) {
 	t := TinyURL{
 		Short:   short,
 		LongURL: long,
 	}
 
+	for _, opt := range opts {
+		opt(&t)
+	}
+
 	// Create a new record in the database.
 	if err := s.db.WithContext(ctx).Create(&t).Error; err != nil {
 		return nil, err
@@ -68,24 +306,110 @@ func (s *storage) Insert(ctx context.Context, short uint64, long []byte) (*TinyU
 	return &t, nil
 }
 
-// GetByShortID retrieves a TinyURL record by its short ID.
-func (s *storage) GetByShortID(ctx context.Context, short uint64) (*TinyURL, error) {
+// BatchInsert adds multiple TinyURL records in a single statement, and returns the inserted records,
+// the records which conflict with the existing ones on long URL, alias or short ID are skipped.
+func (s *storage) BatchInsert(ctx context.Context, records []*TinyURL) ([]*TinyURL, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	db := s.db.WithContext(ctx)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	// the short IDs are unique in each domain, but a generated short ID may collide with an existing record,
+	// so only the records of the requested long URL and tenant are the inserted ones
+	type key struct {
+		domain string
+		short  uint64
+	}
+
+	shorts := make([]uint64, 0, len(records))
+	requested := make(map[key]*TinyURL, len(records))
+
+	for _, r := range records {
+		shorts = append(shorts, r.Short)
+		requested[key{r.Domain, r.Short}] = r
+	}
+
+	var found []*TinyURL
+	if err := db.Where("short IN ?", shorts).Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	// the short IDs of the other domains may be the same
+	inserted := make([]*TinyURL, 0, len(found))
+	for _, r := range found {
+		if want, ok := requested[key{r.Domain, r.Short}]; ok && r.Tenant == want.Tenant && r.LongURLHash == hashURL(want.LongURL) {
+			inserted = append(inserted, r)
+		}
+	}
+
+	return inserted, nil
+}
+
+// GetByShortID retrieves a TinyURL record of the domain by its short ID.
+func (s *storage) GetByShortID(ctx context.Context, domain string, short uint64) (*TinyURL, error) {
+	t := TinyURL{}
+	// Query the database for the record.
+	res := s.db.WithContext(ctx).Where("domain = ? AND short = ?", domain, short).Take(&t)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return &t, nil
+}
+
+// GetByLongURL retrieves the deduplicated TinyURL record by its original URL, the record is looked up by the hash
+// of the long URL, and the long URL is verified, so a hash collision is never returned.
+func (s *storage) GetByLongURL(ctx context.Context, long []byte, opts ...QueryOption) (*TinyURL, error) {
 	t := TinyURL{}
+	db := s.db.WithContext(ctx).Where("long_url_hash = ? AND dedup_key = 0", hashURL(long))
+
+	for _, opt := range opts {
+		db = opt(db)
+	}
+
 	// Query the database for the record.
-	res := s.db.WithContext(ctx).Where("short = ?", short).Take(&t)
+	res := db.Take(&t)
 
 	if res.Error != nil {
 		return nil, res.Error
 	}
 
+	if !bytes.Equal(t.LongURL, long) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
 	return &t, nil
 }
 
-// GetByLongURL retrieves a TinyURL record by its original URL.
-func (s *storage) GetByLongURL(ctx context.Context, long []byte) (*TinyURL, error) {
+// ListByLongURL lists all the TinyURL records of the original URL in ID order, the records are looked up by the hash
+// of the long URL, and the hash collisions are filtered out.
+func (s *storage) ListByLongURL(ctx context.Context, long []byte, opts ...QueryOption) ([]*TinyURL, error) {
+	var records []*TinyURL
+
+	db := s.db.WithContext(ctx).Where("long_url_hash = ?", hashURL(long))
+	for _, opt := range opts {
+		db = opt(db)
+	}
+
+	if res := db.Order("id").Find(&records); res.Error != nil {
+		return nil, res.Error
+	}
+
+	return slices.DeleteFunc(records, func(t *TinyURL) bool {
+		return !bytes.Equal(t.LongURL, long)
+	}), nil
+}
+
+// GetByAlias retrieves a TinyURL record of the domain by its custom alias.
+func (s *storage) GetByAlias(ctx context.Context, domain string, alias []byte) (*TinyURL, error) {
 	t := TinyURL{}
 	// Query the database for the record.
-	res := s.db.WithContext(ctx).Where("long_url = ?", long).Take(&t)
+	res := s.db.WithContext(ctx).Where("domain = ? AND alias = ?", domain, alias).Take(&t)
 
 	if res.Error != nil {
 		return nil, res.Error
@@ -94,9 +418,45 @@ func (s *storage) GetByLongURL(ctx context.Context, long []byte) (*TinyURL, erro
 	return &t, nil
 }
 
-// Delete a short link by short id
-func (s *storage) Delete(ctx context.Context, short uint64) error {
-	res := s.db.WithContext(ctx).Where("short = ?", short).Delete(&TinyURL{})
+// SetAlias sets the custom alias of a TinyURL record which has no alias yet.
+// It returns gorm.ErrRecordNotFound if the record does not exist or already has an alias.
+func (s *storage) SetAlias(ctx context.Context, domain string, short uint64, alias []byte) error {
+	res := s.db.WithContext(ctx).Model(&TinyURL{}).
+		Where("domain = ? AND short = ? AND alias IS NULL", domain, short).Update("alias", alias)
+
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetExpiresAt sets the expiration time of a TinyURL record, nil means never expire.
+func (s *storage) SetExpiresAt(ctx context.Context, domain string, short uint64, expiresAt *time.Time) error {
+	res := s.db.WithContext(ctx).Model(&TinyURL{}).
+		Where("domain = ? AND short = ?", domain, short).Update("expires_at", expiresAt)
+
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete a short link of the domain by short id, the record is soft-deleted. The soft-deleted record keeps its entries
+// in the unique indexes, so its dedup key is set to its short ID as the duplicated records, to release its long URL.
+// The alias of the soft-deleted record is still taken.
+func (s *storage) Delete(ctx context.Context, domain string, short uint64) error {
+	res := s.db.WithContext(ctx).Model(&TinyURL{}).Where("domain = ? AND short = ?", domain, short).
+		Updates(map[string]any{"deleted_at": time.Now(), "dedup_key": gorm.Expr("short")})
 
 	if res.Error != nil {
 		return res.Error
@@ -109,6 +469,38 @@ func (s *storage) Delete(ctx context.Context, short uint64) error {
 	return nil
 }
 
+// ListAfter lists at most limit TinyURL records whose IDs are greater than id in ID order,
+// only the domain, short ID and alias of the records are loaded.
+func (s *storage) ListAfter(ctx context.Context, id uint, limit int) ([]*TinyURL, error) {
+	var records []*TinyURL
+
+	res := s.db.WithContext(ctx).Select("id", "domain", "short", "alias").
+		Where("id > ?", id).Order("id").Limit(limit).Find(&records)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return records, nil
+}
+
+// ListRecent lists at most limit unexpired TinyURL records whose IDs are less than before in descending ID order,
+// zero before means no bound.
+func (s *storage) ListRecent(ctx context.Context, before uint, limit int) ([]*TinyURL, error) {
+	var records []*TinyURL
+
+	tx := s.db.WithContext(ctx).Where("expires_at IS NULL OR expires_at > ?", time.Now())
+	if before > 0 {
+		tx = tx.Where("id < ?", before)
+	}
+
+	if res := tx.Order("id DESC").Limit(limit).Find(&records); res.Error != nil {
+		return nil, res.Error
+	}
+
+	return records, nil
+}
+
 // Close closes the storage.
 func (s *storage) Close() error {
 	return nil
@@ -3,13 +3,15 @@ package storage
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 
 	"github.com/beihai0xff/turl/internal/tests"
-	"github.com/beihai0xff/turl/pkg/db/mysql"
+	"github.com/beihai0xff/turl/pkg/db"
 )
 
 func TestMain(m *testing.M) {
@@ -22,7 +24,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestNew(t *testing.T) {
-	db, _ := mysql.New(tests.GlobalConfig.MySQL)
+	db, _ := db.New(tests.GlobalConfig.DB)
 
 	s := New(db)
 	t.Cleanup(func() {
@@ -33,7 +35,7 @@ func TestNew(t *testing.T) {
 }
 
 func Test_newStorage(t *testing.T) {
-	db, _ := mysql.New(tests.GlobalConfig.MySQL)
+	db, _ := db.New(tests.GlobalConfig.DB)
 
 	s := newStorage(db)
 	t.Cleanup(func() {
@@ -44,7 +46,7 @@ func Test_newStorage(t *testing.T) {
 }
 
 func Test_storage_Insert(t *testing.T) {
-	db, _ := mysql.New(tests.GlobalConfig.MySQL)
+	db, _ := db.New(tests.GlobalConfig.DB)
 
 	long := []byte("www.Insert.com")
 	s, ctx := newStorage(db), context.Background()
@@ -72,7 +74,7 @@ func Test_storage_Insert(t *testing.T) {
 }
 
 func Test_storage_GetTinyURLByID(t *testing.T) {
-	db, _ := mysql.New(tests.GlobalConfig.MySQL)
+	db, _ := db.New(tests.GlobalConfig.DB)
 
 	short, long := uint64(40000), []byte("www.GetByShortID.com")
 	s, ctx := newStorage(db), context.Background()
@@ -81,16 +83,16 @@ func Test_storage_GetTinyURLByID(t *testing.T) {
 	got, err := s.Insert(ctx, short, long)
 	require.NoError(t, err)
 	require.NotNil(t, got)
-	got, err = s.GetByShortID(ctx, short)
+	got, err = s.GetByShortID(ctx, "", short)
 	require.NoError(t, err)
 	require.Equal(t, long, got.LongURL)
 
-	got, err = s.GetByShortID(ctx, 100)
+	got, err = s.GetByShortID(ctx, "", 100)
 	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
 }
 
 func Test_storage_GetByLongURL(t *testing.T) {
-	db, _ := mysql.New(tests.GlobalConfig.MySQL)
+	db, _ := db.New(tests.GlobalConfig.DB)
 
 	long := []byte("www.GetByLongURL.com")
 	s, ctx := newStorage(db), context.Background()
@@ -111,10 +113,149 @@ func Test_storage_GetByLongURL(t *testing.T) {
 		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
 		require.Nil(t, got)
 	})
+
+	t.Run("GetByLongURLOwnedBy", func(t *testing.T) {
+		// the long URL is unique per tenant
+		_, err := s.Insert(ctx, uint64(50001), long, WithTenant("tenant-a"))
+		require.NoError(t, err)
+
+		got, err := s.GetByLongURL(ctx, long, OwnedBy("tenant-a"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(50001), got.Short)
+
+		got, err = s.GetByLongURL(ctx, long, OwnedBy(""))
+		require.NoError(t, err)
+		require.Equal(t, uint64(50000), got.Short)
+
+		_, err = s.GetByLongURL(ctx, long, OwnedBy("tenant-b"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		_, err = s.Insert(ctx, uint64(50002), long, WithTenant("tenant-a"))
+		require.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+	})
+
+	t.Run("GetByLongURLOver500Bytes", func(t *testing.T) {
+		long := []byte("www.GetByLongURL.com/?utm=" + strings.Repeat("x", 2000))
+		_, err := s.Insert(ctx, uint64(50003), long)
+		require.NoError(t, err)
+
+		got, err := s.GetByLongURL(ctx, long)
+		require.NoError(t, err)
+		require.Equal(t, long, got.LongURL)
+
+		_, err = s.Insert(ctx, uint64(50004), long)
+		require.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+	})
+
+	t.Run("GetByLongURLHashCollision", func(t *testing.T) {
+		// simulate a collision, the record of the same hash but a different long URL is never returned
+		other := []byte("www.GetByLongURLHashCollision.com")
+		_, err := s.Insert(ctx, uint64(50005), other)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(&TinyURL{}).Where("short = ?", 50005).
+			UpdateColumn("long_url_hash", hashURL([]byte("www.GetByLongURLHashCollision.com/other"))).Error)
+
+		_, err = s.GetByLongURL(ctx, []byte("www.GetByLongURLHashCollision.com/other"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	require.NoError(t, db.Exec("CREATE INDEX "+legacyIndexes[0]+" ON tiny_urls (short)").Error)
+	require.NoError(t, Migrate(db))
+	for _, index := range legacyIndexes {
+		require.False(t, db.Migrator().HasIndex(&TinyURL{}, index))
+	}
+	require.True(t, db.Migrator().HasIndex(&TinyURL{}, "idx_domain_tenant_long_url_dedup"))
+	require.True(t, db.Migrator().HasIndex(&TinyURL{}, "idx_domain_short"))
+
+	t.Run("backfill", func(t *testing.T) {
+		s, ctx, long := newStorage(db), context.Background(), []byte("www.MigrateBackfill.com")
+		_, err := s.Insert(ctx, uint64(130000), long)
+		require.NoError(t, err)
+
+		// the records created before the hash column
+		m := db.Migrator()
+		require.NoError(t, m.DropIndex(&TinyURL{}, "idx_domain_tenant_long_url_dedup"))
+		require.NoError(t, m.DropColumn(&TinyURL{}, "LongURLHash"))
+		require.NoError(t, m.DropColumn(&TinyURL{}, "DedupKey"))
+
+		require.NoError(t, Migrate(db))
+		require.True(t, m.HasIndex(&TinyURL{}, "idx_domain_tenant_long_url_dedup"))
+
+		got, err := s.GetByLongURL(ctx, long)
+		require.NoError(t, err)
+		require.Equal(t, uint64(130000), got.Short)
+		require.Equal(t, hashURL(long), got.LongURLHash)
+		require.Zero(t, got.DedupKey)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		s, ctx, long := newStorage(db), context.Background(), []byte("www.MigrateRelease.com")
+		_, err := s.Insert(ctx, uint64(130001), long)
+		require.NoError(t, err)
+
+		// the record soft-deleted by the previous versions
+		require.NoError(t, db.Where("short = ?", 130001).Delete(&TinyURL{}).Error)
+		require.NoError(t, Migrate(db))
+
+		// the record is kept, and its long URL can be shortened again
+		var record TinyURL
+		require.NoError(t, db.Unscoped().Where("short = ?", 130001).Take(&record).Error)
+		require.True(t, record.DeletedAt.Valid)
+		require.Equal(t, uint64(130001), record.DedupKey)
+
+		_, err = s.Insert(ctx, uint64(130002), long)
+		require.NoError(t, err)
+	})
+}
+
+func Test_storage_ListByLongURL(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	long := []byte("www.storage_ListByLongURL.com")
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	_, err := s.Insert(ctx, uint64(140000), long)
+	require.NoError(t, err)
+	_, err = s.Insert(ctx, uint64(140001), long)
+	require.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+
+	// the duplicated records of the same long URL are allowed
+	for _, short := range []uint64{140002, 140003} {
+		got, err := s.Insert(ctx, short, long, WithDuplicate())
+		require.NoError(t, err)
+		require.Equal(t, short, got.DedupKey)
+	}
+
+	got, err := s.GetByLongURL(ctx, long)
+	require.NoError(t, err)
+	require.Equal(t, uint64(140000), got.Short)
+
+	records, err := s.ListByLongURL(ctx, long)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	for i, short := range []uint64{140000, 140002, 140003} {
+		require.Equal(t, short, records[i].Short)
+	}
+
+	// only the specified record is deleted
+	require.NoError(t, s.Delete(ctx, "", 140002))
+	records, err = s.ListByLongURL(ctx, long)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(140003), records[1].Short)
+
+	records, err = s.ListByLongURL(ctx, long, OwnedBy("tenant-a"))
+	require.NoError(t, err)
+	require.Empty(t, records)
 }
 
 func Test_storage_Delete(t *testing.T) {
-	db, _ := mysql.New(tests.GlobalConfig.MySQL)
+	db, _ := db.New(tests.GlobalConfig.DB)
 
 	long := []byte("www.storage_Delete.com")
 	s, ctx := newStorage(db), context.Background()
@@ -124,16 +265,263 @@ func Test_storage_Delete(t *testing.T) {
 		_, err := s.Insert(ctx, uint64(60000), long)
 		require.NoError(t, err)
 
-		err = s.Delete(ctx, uint64(60000))
+		err = s.Delete(ctx, "", uint64(60000))
 		require.NoError(t, err)
 
-		got, err := s.GetByShortID(ctx, uint64(60000))
+		got, err := s.GetByShortID(ctx, "", uint64(60000))
 		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
 		require.Nil(t, got)
+
+		// the record is soft-deleted, and its long URL can be shortened again
+		var record TinyURL
+		require.NoError(t, db.Unscoped().Where("short = ?", 60000).Take(&record).Error)
+		require.True(t, record.DeletedAt.Valid)
+
+		_, err = s.Insert(ctx, uint64(60001), long)
+		require.NoError(t, err)
+		require.NoError(t, s.Delete(ctx, "", uint64(60001)))
 	})
 
 	t.Run("DeleteNotFound", func(t *testing.T) {
-		err := s.Delete(ctx, uint64(60000))
+		err := s.Delete(ctx, "", uint64(60000))
 		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
 	})
 }
+
+func Test_storage_BatchInsert(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	_, err := s.Insert(ctx, uint64(100000), []byte("www.BatchInsert-existing.com"))
+	require.NoError(t, err)
+
+	records := []*TinyURL{
+		{Short: 100001, LongURL: []byte("www.BatchInsert-1.com")},
+		{Short: 100002, LongURL: []byte("www.BatchInsert-existing.com")}, // conflict with the existing long URL
+		{Short: 100003, LongURL: []byte("www.BatchInsert-2.com"), Alias: []byte("batch-insert")},
+		{Short: 100004, LongURL: []byte("www.BatchInsert-1.com")}, // conflict with the record in the same batch
+		{Short: 100000, LongURL: []byte("www.BatchInsert-3.com")}, // the short ID collides with the existing record
+	}
+
+	inserted, err := s.BatchInsert(ctx, records)
+	require.NoError(t, err)
+	require.Len(t, inserted, 2)
+
+	got := map[uint64]string{}
+	for _, r := range inserted {
+		got[r.Short] = string(r.LongURL)
+	}
+
+	require.Equal(t, map[uint64]string{100001: "www.BatchInsert-1.com", 100003: "www.BatchInsert-2.com"}, got)
+
+	record, err := s.GetByAlias(ctx, "", []byte("batch-insert"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(100003), record.Short)
+
+	inserted, err = s.BatchInsert(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, inserted)
+}
+
+func Test_storage_GetByAlias(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	long, alias := []byte("www.GetByAlias.com"), []byte("get-by-alias")
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	t.Run("GetByAlias", func(t *testing.T) {
+		_, err := s.Insert(ctx, uint64(70000), long, WithAlias(alias))
+		require.NoError(t, err)
+
+		got, err := s.GetByAlias(ctx, "", alias)
+		require.NoError(t, err)
+		require.Equal(t, long, got.LongURL)
+		require.Equal(t, uint64(70000), got.Short)
+	})
+
+	t.Run("InsertDuplicateAlias", func(t *testing.T) {
+		got, err := s.Insert(ctx, uint64(70001), []byte("www.InsertDuplicateAlias.com"), WithAlias(alias))
+		require.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+		require.Nil(t, got)
+	})
+
+	t.Run("GetByAliasNotFound", func(t *testing.T) {
+		got, err := s.GetByAlias(ctx, "", []byte("get-by-alias-not-found"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Nil(t, got)
+	})
+
+	t.Run("CaseSensitive", func(t *testing.T) {
+		// the binary collation of the alias column is set by the migration on MySQL
+		require.NoError(t, Migrate(db))
+
+		_, err := s.Insert(ctx, uint64(70002), []byte("www.GetByAliasCaseSensitive.com"), WithAlias([]byte("Get-By-Alias")))
+		require.NoError(t, err)
+
+		got, err := s.GetByAlias(ctx, "", []byte("Get-By-Alias"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(70002), got.Short)
+
+		got, err = s.GetByAlias(ctx, "", []byte("GET-BY-ALIAS"))
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Nil(t, got)
+	})
+}
+
+func Test_storage_SetAlias(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	long, alias := []byte("www.SetAlias.com"), []byte("set-alias")
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	_, err := s.Insert(ctx, uint64(80000), long)
+	require.NoError(t, err)
+
+	t.Run("SetAlias", func(t *testing.T) {
+		require.NoError(t, s.SetAlias(ctx, "", uint64(80000), alias))
+
+		got, err := s.GetByAlias(ctx, "", alias)
+		require.NoError(t, err)
+		require.Equal(t, long, got.LongURL)
+	})
+
+	t.Run("SetAliasAlreadySet", func(t *testing.T) {
+		require.ErrorIs(t, s.SetAlias(ctx, "", uint64(80000), []byte("set-alias-again")), gorm.ErrRecordNotFound)
+	})
+}
+
+func Test_storage_SetExpiresAt(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	long, expiresAt := []byte("www.SetExpiresAt.com"), time.Now().Add(-time.Hour)
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	got, err := s.Insert(ctx, uint64(90000), long, WithExpiresAt(expiresAt))
+	require.NoError(t, err)
+	require.True(t, got.Expired())
+
+	t.Run("SetExpiresAt", func(t *testing.T) {
+		require.NoError(t, s.SetExpiresAt(ctx, "", uint64(90000), nil))
+
+		got, err := s.GetByShortID(ctx, "", uint64(90000))
+		require.NoError(t, err)
+		require.Nil(t, got.ExpiresAt)
+		require.False(t, got.Expired())
+	})
+
+	t.Run("SetExpiresAtNotFound", func(t *testing.T) {
+		require.ErrorIs(t, s.SetExpiresAt(ctx, "", uint64(90001), nil), gorm.ErrRecordNotFound)
+	})
+}
+
+func TestTinyURL_Expired(t *testing.T) {
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+
+	require.False(t, (&TinyURL{}).Expired())
+	require.True(t, (&TinyURL{ExpiresAt: &past}).Expired())
+	require.False(t, (&TinyURL{ExpiresAt: &future}).Expired())
+}
+
+func Test_storage_domain(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	long, alias := []byte("www.Domain.com"), []byte("domain")
+	_, err := s.Insert(ctx, uint64(110000), long, WithAlias(alias))
+	require.NoError(t, err)
+
+	// the short ID, alias and long URL are unique in each domain
+	got, err := s.Insert(ctx, uint64(110000), long, WithAlias(alias), WithDomain("go.example.com"))
+	require.NoError(t, err)
+	require.Equal(t, "go.example.com", got.Domain)
+
+	got, err = s.GetByShortID(ctx, "go.example.com", uint64(110000))
+	require.NoError(t, err)
+	require.Equal(t, "go.example.com", got.Domain)
+
+	got, err = s.GetByAlias(ctx, "", alias)
+	require.NoError(t, err)
+	require.Empty(t, got.Domain)
+
+	got, err = s.GetByLongURL(ctx, long, InDomain("go.example.com"))
+	require.NoError(t, err)
+	require.Equal(t, "go.example.com", got.Domain)
+
+	_, err = s.GetByShortID(ctx, "other.example.com", uint64(110000))
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	inserted, err := s.BatchInsert(ctx, []*TinyURL{
+		{Short: 110001, LongURL: []byte("www.Domain-1.com"), Domain: "go.example.com"},
+		{Short: 110001, LongURL: []byte("www.Domain-2.com")},
+	})
+	require.NoError(t, err)
+	require.Len(t, inserted, 2)
+
+	require.NoError(t, s.Delete(ctx, "go.example.com", uint64(110000)))
+	_, err = s.GetByShortID(ctx, "", uint64(110000))
+	require.NoError(t, err)
+}
+
+func Test_storage_ListAfter(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	first, err := s.Insert(ctx, uint64(120000), []byte("www.storage_ListAfter.com/1"))
+	require.NoError(t, err)
+	_, err = s.Insert(ctx, uint64(120001), []byte("www.storage_ListAfter.com/2"), WithAlias([]byte("list-after")), WithDomain("go.example.com"))
+	require.NoError(t, err)
+
+	got, err := s.ListAfter(ctx, first.ID-1, 10)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, uint64(120000), got[0].Short)
+	require.Equal(t, "go.example.com", got[1].Domain)
+	require.Equal(t, []byte("list-after"), got[1].Alias)
+	require.Nil(t, got[1].LongURL)
+
+	got, err = s.ListAfter(ctx, first.ID-1, 1)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+
+	got, err = s.ListAfter(ctx, got[0].ID+1, 10)
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func Test_storage_ListRecent(t *testing.T) {
+	db, _ := db.New(tests.GlobalConfig.DB)
+
+	s, ctx := newStorage(db), context.Background()
+	t.Cleanup(func() { s.Close() })
+
+	past := time.Now().Add(-time.Hour)
+	_, err := s.Insert(ctx, uint64(71000), []byte("www.storage_ListRecent.com/1"))
+	require.NoError(t, err)
+	_, err = s.Insert(ctx, uint64(71001), []byte("www.storage_ListRecent.com/2"), WithExpiresAt(past))
+	require.NoError(t, err)
+	last, err := s.Insert(ctx, uint64(71002), []byte("www.storage_ListRecent.com/3"), WithAlias([]byte("list-recent")))
+	require.NoError(t, err)
+
+	got, err := s.ListRecent(ctx, last.ID+1, 2)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, uint64(71002), got[0].Short)
+	require.Equal(t, []byte("list-recent"), got[0].Alias)
+	require.Equal(t, []byte("www.storage_ListRecent.com/3"), got[0].LongURL)
+	// the expired records are skipped
+	require.Equal(t, uint64(71000), got[1].Short)
+
+	got, err = s.ListRecent(ctx, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.GreaterOrEqual(t, got[0].ID, last.ID)
+}
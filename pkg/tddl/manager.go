@@ -0,0 +1,311 @@
+package tddl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+const (
+	// defaultIdleTimeout is the default idle duration to close the named sequences
+	defaultIdleTimeout = time.Hour
+)
+
+var (
+	// ErrManagerClosed is returned when generating sequence numbers from a closed manager
+	ErrManagerClosed = errors.New("tddl manager is closed")
+	// ErrSequenceExhausted is returned when the named sequence reaches its max number
+	ErrSequenceExhausted = errors.New("tddl sequence is exhausted")
+
+	_ TDDL = (*namedSequence)(nil)
+)
+
+// Manager lazily creates and caches the named sequences, each name has its own sequence number space,
+// e.g. the sequences of the tenants. The sequences which are not used for the idle timeout are closed,
+// and created again on next use.
+type Manager struct {
+	conn        *gorm.DB
+	c           *configs.TDDLConfig
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	seqs   map[string]*managedSequence
+	closed bool
+
+	now func() time.Time
+
+	wg   sync.WaitGroup
+	stop chan struct{}
+}
+
+// managedSequence is a cached named sequence
+type managedSequence struct {
+	TDDL
+	// inflight is the number of the callers generating sequence numbers, the sequence is not evicted when in use
+	inflight int
+	lastUsed time.Time
+}
+
+// NewManager creates a new Manager of the named sequences, the named sequences inherit the config of c,
+// unless overridden by c.Sequences.
+func NewManager(conn *gorm.DB, c *configs.TDDLConfig) *Manager {
+	m := Manager{
+		conn:        conn,
+		c:           c,
+		idleTimeout: c.IdleTimeout,
+		seqs:        make(map[string]*managedSequence),
+		now:         time.Now,
+		stop:        make(chan struct{}),
+	}
+
+	if m.idleTimeout <= 0 {
+		m.idleTimeout = defaultIdleTimeout
+	}
+
+	m.wg.Add(1)
+	go m.evictor()
+
+	return &m
+}
+
+// Configured reports whether the named sequence is configured in TDDLConfig.Sequences
+func (m *Manager) Configured(name string) bool {
+	_, ok := m.c.Sequences[name]
+	return ok
+}
+
+// Sequence returns the TDDL of the named sequence, the returned TDDL is valid until the manager is closed,
+// the underlying sequence is created on first use, or again after evicted.
+// Closing the returned TDDL is a no-op, the sequences are closed by the manager.
+func (m *Manager) Sequence(name string) TDDL {
+	s := &namedSequence{m: m, name: name}
+	if sc := m.c.Sequences[name]; sc != nil {
+		s.maxNum = sc.MaxNum
+	}
+
+	return s
+}
+
+// Stats returns the usage statistics of the cached sequences by name
+func (m *Manager) Stats() map[string]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]Stats, len(m.seqs))
+	for name, s := range m.seqs {
+		stats[name] = s.Stats()
+	}
+
+	return stats
+}
+
+// Close closes the manager and all the cached sequences
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+
+	m.closed = true
+	seqs := m.seqs
+	m.seqs = nil
+	m.mu.Unlock()
+
+	close(m.stop)
+	m.wg.Wait()
+
+	for _, s := range seqs {
+		s.Close()
+	}
+}
+
+// config returns the tddl config of the named sequence
+func (m *Manager) config(name string) *configs.TDDLConfig {
+	c := *m.c
+	c.SeqName = fmt.Sprintf("%s.%s", m.c.SeqName, name)
+	c.Sequences = nil
+
+	if sc := m.c.Sequences[name]; sc != nil {
+		if sc.Step > 0 {
+			c.Step = sc.Step
+		}
+
+		if sc.StartNum > 0 {
+			c.StartNum = sc.StartNum
+		}
+
+		if sc.MaxStep > 0 {
+			c.MaxStep = sc.MaxStep
+		}
+	}
+
+	return &c
+}
+
+// acquire returns the named sequence and marks it in use, the sequence is created if not cached,
+// the caller should call release after generating the sequence numbers.
+func (m *Manager) acquire(name string) (TDDL, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrManagerClosed
+	}
+
+	if s, ok := m.seqs[name]; ok {
+		s.inflight++
+		m.mu.Unlock()
+
+		return s.TDDL, nil
+	}
+	m.mu.Unlock()
+
+	// create the sequence without the lock, since it reserves the first segment from the database
+	t, err := New(m.conn, m.config(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sequence %q: %w", name, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		t.Close()
+		return nil, ErrManagerClosed
+	}
+
+	s, ok := m.seqs[name]
+	if ok { // created by another caller concurrently
+		t.Close()
+	} else {
+		s = &managedSequence{TDDL: t}
+		m.seqs[name] = s
+		slog.Info("tddl sequence created", slog.String("name", name))
+	}
+
+	s.inflight++
+
+	return s.TDDL, nil
+}
+
+// release marks the named sequence not in use by the caller
+func (m *Manager) release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.seqs[name]; ok {
+		s.inflight--
+		s.lastUsed = m.now()
+	}
+}
+
+// evictor closes the idle sequences periodically
+func (m *Manager) evictor() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.idleTimeout / 2) //nolint:mnd
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.evict()
+		}
+	}
+}
+
+// evict closes the sequences which are not used for the idle timeout
+func (m *Manager) evict() {
+	var idle []TDDL
+
+	m.mu.Lock()
+	for name, s := range m.seqs {
+		if s.inflight == 0 && m.now().Sub(s.lastUsed) >= m.idleTimeout {
+			delete(m.seqs, name)
+			idle = append(idle, s.TDDL)
+			slog.Info("tddl sequence evicted", slog.String("name", name))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, t := range idle {
+		t.Close()
+	}
+}
+
+// namedSequence is the TDDL of a named sequence in the manager
+type namedSequence struct {
+	m    *Manager
+	name string
+	// maxNum is the exclusive upper bound of the sequence numbers, zero means unbounded
+	maxNum uint64
+}
+
+// Next returns the next sequence number of the named sequence
+func (s *namedSequence) Next(ctx context.Context) (uint64, error) {
+	t, err := s.m.acquire(s.name)
+	if err != nil {
+		return 0, err
+	}
+	defer s.m.release(s.name)
+
+	next, err := t.Next(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.exhausted(next) {
+		return 0, fmt.Errorf("%w: %q", ErrSequenceExhausted, s.name)
+	}
+
+	return next, nil
+}
+
+// NextN reserves n sequence numbers of the named sequence in bulk
+func (s *namedSequence) NextN(ctx context.Context, n int) ([]uint64, error) {
+	t, err := s.m.acquire(s.name)
+	if err != nil {
+		return nil, err
+	}
+	defer s.m.release(s.name)
+
+	nums, err := t.NextN(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nums) > 0 && s.exhausted(nums[len(nums)-1]) {
+		return nil, fmt.Errorf("%w: %q", ErrSequenceExhausted, s.name)
+	}
+
+	return nums, nil
+}
+
+// exhausted reports whether the sequence number is out of the range of the named sequence
+func (s *namedSequence) exhausted(num uint64) bool {
+	return s.maxNum > 0 && num >= s.maxNum
+}
+
+// Stats returns the usage statistics of the named sequence, the zero Stats is returned if it is not cached
+func (s *namedSequence) Stats() Stats {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if t, ok := s.m.seqs[s.name]; ok {
+		return t.Stats()
+	}
+
+	return Stats{}
+}
+
+// Close is a no-op, the named sequence is closed by the manager
+func (s *namedSequence) Close() {}
@@ -0,0 +1,151 @@
+package tddl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+func TestManager(t *testing.T) {
+	gormDB := newMockDB(t)
+	require.NoError(t, gormDB.Exec("DELETE FROM sequences").Error)
+
+	m := NewManager(gormDB, &configs.TDDLConfig{
+		Step:     100,
+		SeqName:  testSeqName,
+		StartNum: 10000,
+		Sequences: map[string]*configs.SequenceConfig{
+			"tenant-a": {StartNum: 1, Step: 10},
+		},
+	})
+	t.Cleanup(m.Close)
+
+	require.True(t, m.Configured("tenant-a"))
+	require.False(t, m.Configured("tenant-b"))
+
+	a, b := m.Sequence("tenant-a"), m.Sequence("tenant-b")
+	require.Equal(t, Stats{}, a.Stats())
+
+	for i := range 25 {
+		next, err := a.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i+1, int(next))
+	}
+
+	// the sequence without config inherits the step and start number
+	next, err := b.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 10000, int(next))
+
+	nums, err := b.NextN(context.Background(), 3)
+	require.NoError(t, err)
+	require.Len(t, nums, 3)
+
+	stats := m.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, uint64(10000), stats["tenant-b"].SegmentStart)
+	require.Equal(t, stats["tenant-a"], a.Stats())
+
+	// the idle sequence is evicted, and created again on next use
+	m.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	m.evict()
+	require.Empty(t, m.Stats())
+
+	next, err = a.Next(context.Background())
+	require.NoError(t, err)
+	require.Greater(t, int(next), 25)
+
+	m.Close()
+	_, err = a.Next(context.Background())
+	require.ErrorIs(t, err, ErrManagerClosed)
+}
+
+// fakeTDDL is a TDDL counting the sequence numbers in memory
+type fakeTDDL struct {
+	curr   uint64
+	closed bool
+}
+
+func (f *fakeTDDL) Next(context.Context) (uint64, error) {
+	f.curr++
+	return f.curr, nil
+}
+
+func (f *fakeTDDL) NextN(context.Context, int) ([]uint64, error) { return nil, nil }
+
+func (f *fakeTDDL) Stats() Stats { return Stats{SegmentUsed: f.curr} }
+
+func (f *fakeTDDL) Close() { f.closed = true }
+
+func TestManager_evict(t *testing.T) {
+	now := time.Now()
+	m := NewManager(nil, &configs.TDDLConfig{SeqName: testSeqName, IdleTimeout: time.Minute})
+	m.now = func() time.Time { return now }
+
+	busy, idle := &fakeTDDL{}, &fakeTDDL{}
+	m.seqs["busy"] = &managedSequence{TDDL: busy, inflight: 1}
+	m.seqs["idle"] = &managedSequence{TDDL: idle, lastUsed: now}
+
+	next, err := m.Sequence("idle").Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, int(next))
+
+	m.evict()
+	require.Len(t, m.Stats(), 2)
+
+	// the sequence in use is not evicted
+	now = now.Add(time.Minute)
+	m.evict()
+	require.Len(t, m.Stats(), 1)
+	require.True(t, idle.closed)
+	require.False(t, busy.closed)
+
+	m.Close()
+	require.True(t, busy.closed)
+}
+
+func TestManager_exhausted(t *testing.T) {
+	m := NewManager(nil, &configs.TDDLConfig{
+		SeqName:   testSeqName,
+		Sequences: map[string]*configs.SequenceConfig{"tenant-a": {StartNum: 1, MaxNum: 3}},
+	})
+	t.Cleanup(m.Close)
+
+	m.seqs["tenant-a"] = &managedSequence{TDDL: &fakeTDDL{}}
+
+	a := m.Sequence("tenant-a")
+	for i := range 2 {
+		next, err := a.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i+1, int(next))
+	}
+
+	_, err := a.Next(context.Background())
+	require.ErrorIs(t, err, ErrSequenceExhausted)
+}
+
+func TestManager_config(t *testing.T) {
+	m := &Manager{c: &configs.TDDLConfig{
+		Step:     100,
+		MaxStep:  1000,
+		SeqName:  testSeqName,
+		StartNum: 10000,
+		Sequences: map[string]*configs.SequenceConfig{
+			"tenant-a": {StartNum: 1},
+		},
+	}}
+
+	c := m.config("tenant-a")
+	require.Equal(t, testSeqName+".tenant-a", c.SeqName)
+	require.Equal(t, uint64(1), c.StartNum)
+	require.Equal(t, uint64(100), c.Step)
+	require.Equal(t, uint64(1000), c.MaxStep)
+	require.Nil(t, c.Sequences)
+
+	c = m.config("tenant-b")
+	require.Equal(t, uint64(10000), c.StartNum)
+}
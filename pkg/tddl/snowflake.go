@@ -0,0 +1,343 @@
+package tddl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/configs"
+)
+
+// the snowflake ID layout, 46 bits in total, less than 58^8, so the IDs are encoded in no more than eight Base58 characters
+// | 31 bits timestamp in seconds, about 68 years | 5 bits worker ID | 10 bits sequence of each second |
+const (
+	workerBits    = 5
+	sequenceBits  = 10
+	timestampBits = 31
+
+	maxWorkers   = 1 << workerBits
+	maxSequence  = 1<<sequenceBits - 1
+	maxTimestamp = 1<<timestampBits - 1
+
+	// maxClockBackward is the max clock rollback to wait for, a larger rollback fails the generation
+	maxClockBackward = time.Second
+)
+
+var (
+	// epoch is the start time of the snowflake timestamp
+	epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// ErrNoWorkerID is returned when all the worker IDs are leased by the other instances
+	ErrNoWorkerID = errors.New("no available snowflake worker ID")
+	// ErrLeaseExpired is returned when the worker ID lease has expired and is not renewed yet
+	ErrLeaseExpired = errors.New("snowflake worker ID lease has expired")
+	// ErrClockMovedBackwards is returned when the clock moves backwards more than maxClockBackward
+	ErrClockMovedBackwards = errors.New("clock moved backwards")
+	// ErrTimestampOverflow is returned when the timestamp is out of the snowflake ID layout
+	ErrTimestampOverflow = errors.New("snowflake timestamp overflow")
+
+	_ TDDL = (*snowflake)(nil)
+)
+
+// snowflake generates time-based sequence numbers from a worker ID,
+// the worker IDs are leased by the rows of the sequences table, with the optimistic lock version as the lease token.
+// The lease row records the max timestamp the holder may use, so the next holder of the worker ID
+// never reuses the timestamps of the previous one, even if their clocks are not synchronized.
+type snowflake struct {
+	conn     *gorm.DB
+	name     string
+	leaseTTL time.Duration
+
+	mu sync.Mutex
+	// lease is the leased worker row, its version is the lease token
+	lease    Sequence
+	workerID uint64
+	// deadline is the time the lease expires locally
+	deadline time.Time
+	// notBefore is the start time of the first timestamp the holder may use
+	notBefore time.Time
+	lastTS    uint64
+	seq       uint64
+	stats     Stats
+
+	now func() time.Time
+
+	wg   sync.WaitGroup
+	stop chan struct{}
+}
+
+// newSnowflake creates a new snowflake instance and leases a worker ID
+func newSnowflake(conn *gorm.DB, c *configs.TDDLConfig) (*snowflake, error) {
+	s := snowflake{
+		conn:     conn,
+		name:     c.SeqName,
+		leaseTTL: c.LeaseTTL,
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+
+	if err := s.acquire(context.Background()); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.renewer()
+
+	return &s, nil
+}
+
+// workerName returns the name of the lease row of the worker ID
+func (s *snowflake) workerName(id uint64) string {
+	return fmt.Sprintf("%s.worker.%d", s.name, id)
+}
+
+// leaseSeconds returns the max timestamp range a holder may use after recording its timestamp
+func (s *snowflake) leaseSeconds() uint64 {
+	return uint64((s.leaseTTL + time.Second - 1) / time.Second)
+}
+
+// acquire leases a free worker ID, a worker ID is free if its lease row does not exist or has expired
+func (s *snowflake) acquire(ctx context.Context) error {
+	for id := range uint64(maxWorkers) {
+		start := s.now()
+
+		var row Sequence
+
+		res := s.conn.WithContext(ctx).Where("name = ?", s.workerName(id)).Take(&row)
+		if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return res.Error
+		}
+
+		if res.Error == nil && row.UpdatedAt.Add(s.leaseTTL).After(start) {
+			continue // leased by another instance
+		}
+
+		// floor is the first timestamp the holder may use,
+		// the previous holder may use the timestamps up to the recorded one plus the lease ttl
+		floor := timestamp(start)
+		if res.Error == nil {
+			floor = max(floor, row.Sequence+s.leaseSeconds()+1)
+			if res = s.conn.WithContext(ctx).Model(&row).Update("sequence", floor); res.RowsAffected == 1 {
+				row.Version.Int64++ // the version is increased by the update statement
+			}
+		} else {
+			row = Sequence{Name: s.workerName(id), Sequence: floor}
+			res = s.conn.WithContext(ctx).Create(&row)
+		}
+
+		if res.Error != nil || res.RowsAffected != 1 { // leased by another instance concurrently
+			slog.Debug("lease snowflake worker ID failed", slog.Uint64("workerID", id), slog.Any("error", res.Error))
+			continue
+		}
+
+		s.mu.Lock()
+		s.lease, s.workerID, s.deadline = row, id, start.Add(s.leaseTTL)
+		s.notBefore = epoch.Add(time.Duration(floor) * time.Second)
+		s.lastTS, s.seq = floor-1, maxSequence
+		s.mu.Unlock()
+
+		slog.Info("lease snowflake worker ID success", slog.String("name", s.name), slog.Uint64("workerID", id))
+
+		return nil
+	}
+
+	return ErrNoWorkerID
+}
+
+// renew renews the worker ID lease with the current timestamp,
+// returns gorm.ErrRecordNotFound if the lease has been taken by another instance.
+func (s *snowflake) renew(ctx context.Context) error {
+	start := s.now()
+
+	s.mu.Lock()
+	lease, ts := s.lease, max(timestamp(start), s.lastTS)
+	s.mu.Unlock()
+
+	res := s.conn.WithContext(ctx).Model(&lease).Update("sequence", ts)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return gorm.ErrRecordNotFound
+	}
+
+	latency := s.now().Sub(start)
+
+	s.mu.Lock()
+	s.lease.Version.Int64++
+	s.lease.Sequence = ts
+	s.deadline = start.Add(s.leaseTTL)
+	s.stats.Renews++
+	s.stats.LastRenewLatency = latency
+	s.stats.MaxRenewLatency = max(s.stats.MaxRenewLatency, latency)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// renewer renews the lease every third of the lease ttl, and leases another worker ID if the lease is lost
+func (s *snowflake) renewer() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.leaseTTL / 3) //nolint:mnd
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx := context.Background()
+
+		err := s.renew(ctx)
+		if err == nil {
+			continue
+		}
+
+		slog.Warn("renew snowflake worker ID lease failed", slog.Uint64("workerID", s.workerID), slog.Any("error", err))
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err = s.acquire(ctx); err != nil {
+				slog.Error("lease snowflake worker ID failed", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// Next returns the next sequence number
+func (s *snowflake) Next(ctx context.Context) (uint64, error) {
+	ids, err := s.NextN(ctx, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	return ids[0], nil
+}
+
+// NextN returns n sequence numbers in ascending order, it waits for the next second
+// if the sequence numbers of the current second are exhausted.
+func (s *snowflake) NextN(ctx context.Context, n int) ([]uint64, error) {
+	if n < 1 {
+		return nil, ErrInvalidCount
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]uint64, 0, n)
+	for len(ids) < n {
+		id, wait, err := s.next(s.now())
+		if err != nil {
+			return nil, err
+		}
+
+		if wait == 0 {
+			ids = append(ids, id)
+			continue
+		}
+
+		s.stats.Stalls++
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	return ids, nil
+}
+
+// Stats returns the usage statistics of the lease renewals, the segment is the sequence numbers of the current second
+func (s *snowflake) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Step = maxSequence + 1
+	stats.SegmentStart = s.lastTS<<(workerBits+sequenceBits) | s.workerID<<sequenceBits
+	stats.SegmentMax = stats.SegmentStart + stats.Step
+	stats.SegmentUsed = min(s.seq+1, stats.Step)
+
+	return stats
+}
+
+// next returns the next sequence number at now, or the duration to wait before retrying, must be called with the lock held
+func (s *snowflake) next(now time.Time) (uint64, time.Duration, error) {
+	if !now.Before(s.deadline) {
+		return 0, 0, ErrLeaseExpired
+	}
+
+	if now.Before(s.notBefore) { // wait for the timestamps of the previous holder to pass
+		wait := s.notBefore.Sub(now)
+		if wait > s.leaseTTL+maxClockBackward {
+			return 0, 0, fmt.Errorf("%w: the worker ID is used until %s", ErrClockMovedBackwards, s.notBefore)
+		}
+
+		return 0, wait, nil
+	}
+
+	ts := timestamp(now)
+
+	switch {
+	case ts < s.lastTS:
+		wait := epoch.Add(time.Duration(s.lastTS) * time.Second).Sub(now)
+		if wait > maxClockBackward {
+			return 0, 0, fmt.Errorf("%w: refusing to generate sequence number for %s", ErrClockMovedBackwards, wait)
+		}
+
+		return 0, wait, nil
+	case ts == s.lastTS:
+		if s.seq == maxSequence { // exhausted, wait for the next second
+			return 0, epoch.Add(time.Duration(ts+1) * time.Second).Sub(now), nil
+		}
+
+		s.seq++
+	default:
+		if ts > maxTimestamp {
+			return 0, 0, ErrTimestampOverflow
+		}
+
+		s.lastTS, s.seq = ts, 0
+	}
+
+	return s.lastTS<<(workerBits+sequenceBits) | s.workerID<<sequenceBits | s.seq, 0, nil
+}
+
+// Close stops renewing and releases the lease, the next holder starts right after the last used timestamp
+func (s *snowflake) Close() {
+	close(s.stop)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// no more timestamps are used, so record the last used one minus the lease ttl
+	ts := max(timestamp(s.now()), s.lastTS)
+	res := s.conn.Model(&s.lease).UpdateColumns(map[string]any{
+		"sequence":   ts - min(ts, s.leaseSeconds()),
+		"updated_at": s.now().Add(-s.leaseTTL),
+	})
+	if res.Error != nil {
+		slog.Warn("release snowflake worker ID lease failed", slog.Any("error", res.Error))
+	}
+
+	s.deadline = time.Time{} // no more sequence numbers after released
+}
+
+// timestamp returns the snowflake timestamp of t
+func timestamp(t time.Time) uint64 {
+	if t.Before(epoch) {
+		return 0
+	}
+
+	return uint64(t.Sub(epoch) / time.Second)
+}
@@ -0,0 +1,151 @@
+package tddl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/beihai0xff/turl/configs"
+	"github.com/beihai0xff/turl/pkg/mapping"
+)
+
+func TestNewSnowflake(t *testing.T) {
+	gormDB := newMockDB(t)
+	require.NoError(t, gormDB.Exec("DELETE FROM sequences").Error)
+
+	c := &configs.TDDLConfig{Type: configs.TDDLTypeSnowflake, SeqName: testSeqName, LeaseTTL: 3 * time.Second}
+
+	s1, err := New(gormDB, c)
+	require.NoError(t, err)
+	s2, err := newSnowflake(gormDB, c)
+	require.NoError(t, err)
+	t.Cleanup(s2.Close)
+
+	// the worker IDs are leased exclusively
+	require.Equal(t, uint64(0), s1.(*snowflake).workerID)
+	require.Equal(t, uint64(1), s2.workerID)
+
+	ctx := context.Background()
+	prev, err := s1.Next(ctx)
+	require.NoError(t, err)
+	s1.Close()
+
+	// the released worker ID is leased again, and never reuses the timestamps of the previous holder
+	s3, err := newSnowflake(gormDB, c)
+	require.NoError(t, err)
+	t.Cleanup(s3.Close)
+	require.Equal(t, uint64(0), s3.workerID)
+
+	next, err := s3.Next(ctx)
+	require.NoError(t, err)
+	require.Greater(t, next, prev)
+}
+
+func Test_snowflake_renew(t *testing.T) {
+	gormDB := newMockDB(t)
+	require.NoError(t, gormDB.Exec("DELETE FROM sequences").Error)
+
+	c := &configs.TDDLConfig{Type: configs.TDDLTypeSnowflake, SeqName: testSeqName, LeaseTTL: 3 * time.Second}
+	s, err := newSnowflake(gormDB, c)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	deadline := s.deadline
+	require.NoError(t, s.renew(context.Background()))
+	require.True(t, s.deadline.After(deadline))
+
+	// the lease is taken by another instance
+	require.NoError(t, gormDB.Exec("UPDATE sequences SET version = version + 1 WHERE id = ?", s.lease.ID).Error)
+	require.ErrorIs(t, s.renew(context.Background()), gorm.ErrRecordNotFound)
+}
+
+func Test_snowflake_next(t *testing.T) {
+	now := epoch.Add(100 * time.Second)
+	s := &snowflake{
+		workerID: 3,
+		leaseTTL: 10 * time.Second,
+		deadline: now.Add(time.Minute),
+		lastTS:   99,
+		seq:      maxSequence,
+	}
+
+	id, wait, err := s.next(now)
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	require.Equal(t, uint64(100)<<15|3<<10, id)
+
+	id, _, err = s.next(now.Add(100 * time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, uint64(100)<<15|3<<10|1, id)
+
+	// the IDs are in the 8 characters Base58 space
+	require.LessOrEqual(t, len(mapping.Base58Encode(uint64(maxTimestamp)<<15|(maxWorkers-1)<<10|maxSequence)), 8)
+
+	t.Run("SequenceExhausted", func(t *testing.T) {
+		s.seq = maxSequence
+		_, wait, err = s.next(now.Add(100 * time.Millisecond))
+		require.NoError(t, err)
+		require.Equal(t, 900*time.Millisecond, wait)
+	})
+
+	t.Run("ClockMovedBackwards", func(t *testing.T) {
+		_, wait, err = s.next(now.Add(-500 * time.Millisecond))
+		require.NoError(t, err)
+		require.Equal(t, 500*time.Millisecond, wait)
+
+		_, _, err = s.next(now.Add(-5 * time.Second))
+		require.ErrorIs(t, err, ErrClockMovedBackwards)
+	})
+
+	t.Run("PreviousHolderTimestamps", func(t *testing.T) {
+		s.notBefore = now.Add(5 * time.Second)
+		_, wait, err = s.next(now)
+		require.NoError(t, err)
+		require.Equal(t, 5*time.Second, wait)
+
+		s.notBefore = now.Add(time.Minute)
+		_, _, err = s.next(now)
+		require.ErrorIs(t, err, ErrClockMovedBackwards)
+		s.notBefore = time.Time{}
+	})
+
+	t.Run("LeaseExpired", func(t *testing.T) {
+		_, _, err = s.next(s.deadline)
+		require.ErrorIs(t, err, ErrLeaseExpired)
+	})
+}
+
+func Test_snowflake_NextN(t *testing.T) {
+	now := epoch.Add(100 * time.Second)
+	s := &snowflake{
+		leaseTTL: 10 * time.Second,
+		deadline: now.Add(time.Minute),
+		now:      func() time.Time { return now },
+	}
+
+	ids, err := s.NextN(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, ids, 10)
+
+	for i := 1; i < len(ids); i++ {
+		require.Greater(t, ids[i], ids[i-1])
+	}
+
+	_, err = s.NextN(context.Background(), 0)
+	require.ErrorIs(t, err, ErrInvalidCount)
+
+	// wait for the next second when the sequence numbers are exhausted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.NextN(ctx, maxSequence+1)
+	require.ErrorIs(t, err, context.Canceled)
+
+	stats := s.Stats()
+	require.Equal(t, uint64(maxSequence+1), stats.Step)
+	require.Equal(t, stats.Step, stats.SegmentUsed)
+	require.Equal(t, uint64(1), stats.Stalls)
+}
@@ -20,11 +20,17 @@ import (
 const (
 	// retryInterval is the retry start interval when update tddl failed
 	retryInterval = 10 * time.Millisecond
+	// defaultSegmentDuration is the default expected duration to consume a segment
+	defaultSegmentDuration = 15 * time.Minute
+	// defaultPrefetchThreshold is the default consumed ratio of the current segment to fetch the next segment
+	defaultPrefetchThreshold = 0.1
 )
 
 var (
 	// ErrStepTooSmall is the error of step too small
-	ErrStepTooSmall      = errors.New("step must be greater than 0")
+	ErrStepTooSmall = errors.New("step must be greater than 0")
+	// ErrInvalidCount is the error of reserving less than one sequence number
+	ErrInvalidCount      = errors.New("count must be greater than 0")
 	_               TDDL = (*tddlSequence)(nil)
 )
 
@@ -32,12 +38,34 @@ var (
 type TDDL interface {
 	// Next returns the next sequence number
 	Next(ctx context.Context) (uint64, error)
+	// NextN reserves n sequence numbers in bulk, and returns them in ascending order
+	NextN(ctx context.Context, n int) ([]uint64, error)
+	// Stats returns the usage statistics of the tddl
+	Stats() Stats
 	// Close closes the tddl
 	Close()
-	// Renew()
 }
 
-// Sequence is the table of sequence
+// Stats is the usage statistics of tddl
+type Stats struct {
+	// Step is the size of the latest reserved segment
+	Step uint64
+	// SegmentStart and SegmentMax are the range [SegmentStart, SegmentMax) of the current segment
+	SegmentStart, SegmentMax uint64
+	// SegmentUsed is the number of sequence numbers used in the current segment
+	SegmentUsed uint64
+	// NextSegmentReady is whether the next segment has been fetched in background
+	NextSegmentReady bool
+	// Renews is the number of renews, e.g. segment reservations or lease renewals
+	Renews uint64
+	// LastRenewLatency and MaxRenewLatency are the latencies of the renews, including the retries
+	LastRenewLatency, MaxRenewLatency time.Duration
+	// Stalls is the number of times the callers waited for a renew or the next time window
+	Stalls uint64
+}
+
+// Sequence is the table of sequence, the updates always increase the version, so the affected rows of
+// the cas updates are the matched rows on both MySQL and PostgreSQL
 type Sequence struct {
 	gorm.Model
 	Name     string `gorm:"type:VARCHAR(500);not null;uniqueIndex" json:"name"`
@@ -57,21 +85,45 @@ type tddlSequence struct {
 	// rowID is the row primary key of the sequence
 	rowID uint
 
-	step uint64
-	max  uint64
-	curr atomic.Uint64
+	// step is the size of the next segment, adapts between minStep and maxStep, only accessed by the renew routine
+	step, minStep, maxStep uint64
+	// segmentDuration is the expected duration to consume a segment
+	segmentDuration time.Duration
+	// renewedAt is the time of the last renew, only accessed by the renew routine
+	renewedAt time.Time
+	// prefetchThreshold is the consumed ratio of the current segment to fetch the next segment in background
+	prefetchThreshold float64
+
+	// start and max are the range [start, max) of the current segment, only accessed by the worker
+	start, max uint64
+	curr       atomic.Uint64
+	// prefetching is whether the next segment is being fetched or has been fetched, only accessed by the worker
+	prefetching bool
+	// prefetched is the next segment fetched in background
+	prefetched chan segment
+
+	statsMu sync.Mutex
+	stats   Stats
 
 	wg sync.WaitGroup
 
-	stop chan struct{}
-	// TODO: use a buffer channel to avoid blocking
+	stop  chan struct{}
 	queue chan uint64
 
 	rateLimiter workqueue.RateLimiter[any]
 }
 
-// New returns a new tddl implementation
+// segment is the range [start, max) of sequence numbers reserved from the sequence row
+type segment struct {
+	start, max uint64
+}
+
+// New returns a new tddl implementation of the config type
 func New(conn *gorm.DB, c *configs.TDDLConfig) (TDDL, error) {
+	if c.Type == configs.TDDLTypeSnowflake {
+		return newSnowflake(conn, c)
+	}
+
 	return newSequence(conn, c)
 }
 
@@ -82,13 +134,26 @@ func newSequence(conn *gorm.DB, c *configs.TDDLConfig) (*tddlSequence, error) {
 	}
 
 	s := tddlSequence{
-		clientID:    uuid.NewString(),
-		conn:        conn,
-		step:        c.Step,
-		wg:          sync.WaitGroup{},
-		stop:        make(chan struct{}),
-		queue:       make(chan uint64),
-		rateLimiter: workqueue.NewItemExponentialFailureRateLimiter[any](retryInterval, time.Minute),
+		clientID:          uuid.NewString(),
+		conn:              conn,
+		step:              c.Step,
+		minStep:           c.Step,
+		maxStep:           max(c.Step, c.MaxStep),
+		segmentDuration:   c.SegmentDuration,
+		prefetchThreshold: c.PrefetchThreshold,
+		prefetched:        make(chan segment, 1),
+		wg:                sync.WaitGroup{},
+		stop:              make(chan struct{}),
+		queue:             make(chan uint64),
+		rateLimiter:       workqueue.NewItemExponentialFailureRateLimiter[any](retryInterval, time.Minute),
+	}
+
+	if s.segmentDuration <= 0 {
+		s.segmentDuration = defaultSegmentDuration
+	}
+
+	if s.prefetchThreshold <= 0 || s.prefetchThreshold > 1 {
+		s.prefetchThreshold = defaultPrefetchThreshold
 	}
 
 	if err := s.getRowID(c.SeqName, c.StartNum); err != nil {
@@ -96,10 +161,11 @@ func newSequence(conn *gorm.DB, c *configs.TDDLConfig) (*tddlSequence, error) {
 	}
 
 	// filling the curr and max
-	s.renew()
+	seg, _ := s.renew()
+	s.use(seg)
 
-	go s.worker()
 	s.wg.Add(1)
+	go s.worker()
 
 	return &s, nil
 }
@@ -145,31 +211,35 @@ func (s *tddlSequence) createRecord(seqName string, startNum uint64) (uint, erro
 // 	}
 // 	defer s.mu.Unlock()
 //
-// 	go s.renew()
 // 	s.wg.Add(1)
+// 	go s.renew()
 // 	s.wg.Wait()
 // }
 
-// renew function renews the sequence number
-// should be called in a single goroutine
-func (s *tddlSequence) renew() {
+// renew function reserves the next segment from the sequence row with cas,
+// returns false if the tddl is closed before success. Should be called in a single goroutine.
+func (s *tddlSequence) renew() (segment, bool) {
 	ctx := context.Background()
 	defer s.rateLimiter.Forget(ctx, s.clientID) // forget the retry times
 
-	var seq = Sequence{}
+	begin := time.Now()
+	s.adaptStep(begin)
+
+	var seg segment
 
 	for {
 		select {
 		case <-s.stop: // receive stop signal
-			return
+			return seg, false
 		default:
 		}
 
-		seq = Sequence{}
-		res := s.conn.Where("id = ?", s.rowID).Take(&seq) // update the sequence with cas
+		var seq Sequence
+		res := s.conn.Where("id = ?", s.rowID).Take(&seq)
 
 		if res.Error == nil {
-			res = s.conn.Model(&seq).Update("sequence", seq.Sequence+s.step)
+			seg = segment{start: seq.Sequence, max: seq.Sequence + s.step}
+			res = s.conn.Model(&seq).Update("sequence", seg.max) // update the sequence with cas
 			if res.Error == nil && res.RowsAffected == 1 {
 				break
 			}
@@ -182,15 +252,66 @@ func (s *tddlSequence) renew() {
 		time.Sleep(s.rateLimiter.When(ctx, s.clientID))
 	}
 
-	s.curr.Store(seq.Sequence - s.step)
-	s.max = seq.Sequence
+	latency := time.Since(begin)
+	s.renewedAt = begin
+
+	s.statsMu.Lock()
+	s.stats.Step = s.step
+	s.stats.Renews++
+	s.stats.LastRenewLatency = latency
+	s.stats.MaxRenewLatency = max(s.stats.MaxRenewLatency, latency)
+	s.statsMu.Unlock()
+
 	slog.Debug("renew tddl sequence success", slog.Group("sequence",
 		slog.String("clientID", s.clientID),
-		slog.String("name", seq.Name),
-		slog.Uint64("maxSequence", seq.Sequence),
-		slog.Uint64("currSequence", s.curr.Load()),
+		slog.Uint64("rowID", uint64(s.rowID)),
+		slog.Uint64("start", seg.start),
+		slog.Uint64("max", seg.max),
+		slog.Duration("latency", latency),
 		slog.Int("retryTimes", s.rateLimiter.Retries(ctx, s.clientID)),
 	))
+
+	return seg, true
+}
+
+// adaptStep adapts the step to the consumption rate like Leaf,
+// doubles the step if the last segment is consumed within segmentDuration,
+// and halves the step if it takes more than twice of segmentDuration.
+func (s *tddlSequence) adaptStep(now time.Time) {
+	if s.renewedAt.IsZero() || s.maxStep <= s.minStep {
+		return
+	}
+
+	switch elapsed := now.Sub(s.renewedAt); {
+	case elapsed < s.segmentDuration:
+		s.step = min(s.step*2, s.maxStep) //nolint:mnd
+	case elapsed >= 2*s.segmentDuration: //nolint:mnd
+		s.step = max(s.step/2, s.minStep) //nolint:mnd
+	}
+}
+
+// prefetch fetches the next segment in background
+func (s *tddlSequence) prefetch() {
+	s.prefetching = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if seg, ok := s.renew(); ok {
+			s.prefetched <- seg
+		}
+	}()
+}
+
+// use switches the current segment to seg
+func (s *tddlSequence) use(seg segment) {
+	s.start, s.max = seg.start, seg.max
+	s.curr.Store(seg.start)
+
+	s.statsMu.Lock()
+	s.stats.SegmentStart, s.stats.SegmentMax = seg.start, seg.max
+	s.statsMu.Unlock()
 }
 
 // Next returns the next sequence number
@@ -212,6 +333,46 @@ func (s *tddlSequence) Next(ctx context.Context) (uint64, error) {
 	}
 }
 
+// NextN reserves n consecutive sequence numbers in bulk,
+// the numbers are taken from the sequence row directly with cas, so the local segment is not exhausted.
+func (s *tddlSequence) NextN(ctx context.Context, n int) ([]uint64, error) {
+	if n < 1 {
+		return nil, ErrInvalidCount
+	}
+
+	key := uuid.NewString()              // each call retries independently
+	defer s.rateLimiter.Forget(ctx, key) // forget the retry times
+
+	for {
+		var seq Sequence
+
+		res := s.conn.WithContext(ctx).Where("id = ?", s.rowID).Take(&seq)
+		if res.Error == nil {
+			start := seq.Sequence
+			// update the sequence with cas
+			res = s.conn.WithContext(ctx).Model(&seq).Update("sequence", start+uint64(n))
+			if res.Error == nil && res.RowsAffected == 1 {
+				nums := make([]uint64, n)
+				for i := range nums {
+					nums[i] = start + uint64(i)
+				}
+
+				return nums, nil
+			}
+
+			slog.Debug("cas sequence failed")
+		} else {
+			slog.Warn("get sequence failed", slog.String("error", res.Error.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(s.rateLimiter.When(ctx, key)):
+		}
+	}
+}
+
 func (s *tddlSequence) worker() {
 	defer s.wg.Done()
 
@@ -221,16 +382,57 @@ func (s *tddlSequence) worker() {
 		select {
 		case s.queue <- next:
 			next = s.curr.Add(1)
-			for next >= s.max { // the serial number has been exhausted
-				s.renew()
-				next = s.curr.Load()
+
+			used := float64(next-s.start) / float64(s.max-s.start)
+			if !s.prefetching && used >= s.prefetchThreshold {
+				s.prefetch()
+			}
+
+			if next < s.max {
+				continue
+			}
+
+			// the serial number has been exhausted, switch to the prefetched segment
+			if !s.prefetching {
+				s.prefetch()
+			}
+
+			var seg segment
+			select {
+			case seg = <-s.prefetched:
+			default: // the next segment is not ready yet, the callers are stalled
+				s.statsMu.Lock()
+				s.stats.Stalls++
+				s.statsMu.Unlock()
+
+				select {
+				case seg = <-s.prefetched:
+				case <-s.stop:
+					return
+				}
 			}
+
+			s.prefetching = false
+			s.use(seg)
+			next = seg.start
 		case <-s.stop:
 			return
 		}
 	}
 }
 
+// Stats returns the usage statistics of the segments and renews
+func (s *tddlSequence) Stats() Stats {
+	s.statsMu.Lock()
+	stats := s.stats
+	s.statsMu.Unlock()
+
+	stats.SegmentUsed = min(s.curr.Load(), stats.SegmentMax) - stats.SegmentStart
+	stats.NextSegmentReady = len(s.prefetched) > 0
+
+	return stats
+}
+
 // Close closes the tddl
 func (s *tddlSequence) Close() {
 	close(s.stop)
@@ -14,7 +14,7 @@ import (
 
 	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
-	"github.com/beihai0xff/turl/pkg/db/mysql"
+	"github.com/beihai0xff/turl/pkg/db"
 )
 
 const (
@@ -31,7 +31,7 @@ func TestMain(m *testing.M) {
 }
 
 func newMockDB(t *testing.T) *gorm.DB {
-	db, err := mysql.New(tests.GlobalConfig.MySQL)
+	db, err := db.New(tests.GlobalConfig.DB)
 	require.NoError(t, err)
 
 	return db
@@ -236,6 +236,41 @@ func Test_tddlSequence_Next_timeout(t *testing.T) {
 	require.Equal(t, 10001, int(next))
 }
 
+func Test_tddlSequence_NextN(t *testing.T) {
+	gormDB := newMockDB(t)
+	require.NoError(t, gormDB.Exec("DELETE FROM sequences").Error)
+
+	s, err := newSequence(gormDB, &configs.TDDLConfig{
+		Step:     10,
+		SeqName:  testSeqName,
+		StartNum: 10000,
+	})
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	// the local segment [10000, 10010) is reserved by renew
+	nums, err := s.NextN(context.Background(), 25)
+	require.NoError(t, err)
+	require.Len(t, nums, 25)
+
+	for i, x := range nums {
+		require.Equal(t, i+10010, int(x))
+	}
+
+	// the local segment is not affected
+	next, err := s.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 10000, int(next))
+
+	_, err = s.NextN(context.Background(), 0)
+	require.ErrorIs(t, err, ErrInvalidCount)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.NextN(ctx, 1)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 func Test_tddlSequence_renew_failed(t *testing.T) {
 	gormDB := newMockDB(t)
 	require.NoError(t, gormDB.Exec("DELETE FROM sequences").Error)
@@ -259,3 +294,79 @@ func Test_tddlSequence_renew_failed(t *testing.T) {
 
 	s.renew()
 }
+
+func Test_tddlSequence_adaptStep(t *testing.T) {
+	now := time.Now()
+	s := tddlSequence{step: 100, minStep: 100, maxStep: 1000, segmentDuration: time.Minute}
+
+	// the first renew does not adapt the step
+	s.adaptStep(now)
+	require.Equal(t, uint64(100), s.step)
+
+	s.renewedAt = now.Add(-time.Second)
+	s.adaptStep(now)
+	require.Equal(t, uint64(200), s.step)
+
+	s.step = 800
+	s.adaptStep(now)
+	require.Equal(t, uint64(1000), s.step)
+
+	// the step keeps if the segment is consumed in expected duration
+	s.renewedAt = now.Add(-90 * time.Second)
+	s.adaptStep(now)
+	require.Equal(t, uint64(1000), s.step)
+
+	s.renewedAt = now.Add(-time.Hour)
+	s.adaptStep(now)
+	require.Equal(t, uint64(500), s.step)
+
+	s.step = 150
+	s.adaptStep(now)
+	require.Equal(t, uint64(100), s.step)
+
+	// the step does not adapt without max step
+	s.maxStep = 100
+	s.renewedAt = now.Add(-time.Second)
+	s.adaptStep(now)
+	require.Equal(t, uint64(100), s.step)
+}
+
+func Test_tddlSequence_prefetch(t *testing.T) {
+	gormDB := newMockDB(t)
+	require.NoError(t, gormDB.Exec("DELETE FROM sequences").Error)
+
+	s, err := newSequence(gormDB, &configs.TDDLConfig{
+		Step:              10,
+		MaxStep:           100,
+		SeqName:           testSeqName,
+		StartNum:          10000,
+		PrefetchThreshold: 0.5,
+	})
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	for i := range 5 {
+		next, err := s.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i+10000, int(next))
+	}
+
+	// the next segment is fetched in background once half of the current segment is used
+	require.Eventually(t, func() bool { return s.Stats().NextSegmentReady }, time.Second, 10*time.Millisecond)
+
+	stats := s.Stats()
+	require.Equal(t, uint64(10000), stats.SegmentStart)
+	require.Equal(t, uint64(10010), stats.SegmentMax)
+	require.Equal(t, uint64(5), stats.SegmentUsed)
+	require.Equal(t, uint64(2), stats.Renews)
+	// the step doubles since the first segment is consumed quickly
+	require.Equal(t, uint64(20), stats.Step)
+
+	for i := 5; i < 30; i++ {
+		next, err := s.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i+10000, int(next))
+	}
+
+	require.Positive(t, s.Stats().MaxRenewLatency)
+}
@@ -12,17 +12,19 @@ import (
 	"math"
 	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/time/rate"
+
+	"github.com/beihai0xff/turl/pkg/breaker"
 )
 
 const (
 	tokenFormat     = "{%s}.tokens" //nolint: gosec
 	timestampFormat = "{%s}.ts"
-	pingInterval    = time.Millisecond * 100
+	// rescueTimeout is the duration to use the in-process limiter before probing redis again
+	rescueTimeout = time.Second
 )
 
 // RateLimiter is an interface that knows how to limit the rate at which something is processed
@@ -263,41 +265,170 @@ func (r *MaxOfRateLimiter[T]) Forget(ctx context.Context, item T) {
 	}
 }
 
-// ItemRedisTokenRateLimiter is a rate limiter that uses a token bucket in redis to rate limit items
+// Limit is the token bucket limit of an item
+type Limit struct {
+	// Rate is the number of tokens produced per second
+	Rate int
+	// Burst is the capacity of the token bucket
+	Burst int
+}
+
+// Reservation is the state of the token bucket after taking a token
+type Reservation struct {
+	// OK is whether the token is taken
+	OK bool
+	// Limit is the capacity of the token bucket, 0 means the state is unknown
+	Limit int
+	// Remaining is the number of the remaining tokens in the bucket
+	Remaining int
+	// RetryAfter is how long to wait until a token is available, 0 if the token is taken
+	RetryAfter time.Duration
+	// ResetAfter is how long to wait until the bucket is full
+	ResetAfter time.Duration
+}
+
+// maxIdleBuckets is the number of the token buckets of ItemTokenRateLimiter to drop the full ones
+const maxIdleBuckets = 10000
+
+// ItemTokenRateLimiter is an in-process rate limiter, each item has its own token bucket,
+// it is used instead of ItemRedisTokenRateLimiter by the single instance without redis, e.g. in the embedded mode.
+type ItemTokenRateLimiter[T comparable] struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[T]*itemBucket
+}
+
+// itemBucket is the token bucket of an item, and the limit it was created with
+type itemBucket struct {
+	limit   Limit
+	limiter *rate.Limiter
+}
+
+var _ RateLimiter[any] = &ItemTokenRateLimiter[any]{}
+
+// NewItemTokenRateLimiter creates a new ItemTokenRateLimiter, r and b are the default rate and burst of each item.
+func NewItemTokenRateLimiter[T comparable](r, b int) *ItemTokenRateLimiter[T] {
+	return &ItemTokenRateLimiter[T]{
+		limit:   Limit{Rate: r, Burst: b},
+		buckets: make(map[T]*itemBucket),
+	}
+}
+
+// Take gets an item and gets to decide whether it should run now or not
+func (r *ItemTokenRateLimiter[T]) Take(ctx context.Context, item T) bool {
+	return r.Reserve(ctx, item, r.limit).OK
+}
+
+// When returns the delay until a token of the item is available, a token is taken if it is available now
+func (r *ItemTokenRateLimiter[T]) When(ctx context.Context, item T) time.Duration {
+	return r.Reserve(ctx, item, r.limit).RetryAfter
+}
+
+// Forget removes the token bucket of the item
+func (r *ItemTokenRateLimiter[T]) Forget(_ context.Context, item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.buckets, item)
+}
+
+// Retries returns 0 as the number of retries for the token bucket
+func (r *ItemTokenRateLimiter[T]) Retries(_ context.Context, _ T) int {
+	return 0
+}
+
+// Reserve takes a token from the token bucket of the item with the given limit,
+// and returns the state of the token bucket.
+func (r *ItemTokenRateLimiter[T]) Reserve(_ context.Context, item T, limit Limit) *Reservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	b, ok := r.buckets[item]
+	if !ok || b.limit != limit {
+		if len(r.buckets) >= maxIdleBuckets {
+			r.dropFull(now)
+		}
+
+		b = &itemBucket{limit: limit, limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		r.buckets[item] = b
+	}
+
+	res := &Reservation{OK: b.limiter.AllowN(now, 1), Limit: limit.Burst}
+
+	tokens := b.limiter.TokensAt(now)
+	res.Remaining = max(int(tokens), 0)
+	res.ResetAfter = tokensDuration(float64(limit.Burst)-tokens, limit.Rate)
+
+	if !res.OK {
+		res.RetryAfter = tokensDuration(1-tokens, limit.Rate)
+	}
+
+	return res
+}
+
+// dropFull drops the full token buckets, which behave the same as the new ones, so that the idle items
+// do not hold the memory forever
+func (r *ItemTokenRateLimiter[T]) dropFull(now time.Time) {
+	for item, b := range r.buckets {
+		if b.limiter.TokensAt(now) >= float64(b.limit.Burst) {
+			delete(r.buckets, item)
+		}
+	}
+}
+
+// tokensDuration returns the duration to produce the tokens at the rate per second
+func tokensDuration(tokens float64, r int) time.Duration {
+	if tokens <= 0 || r <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(tokens / float64(r) * float64(time.Second)))
+}
+
+// ItemRedisTokenRateLimiter is a rate limiter that uses a token bucket in redis to rate limit items,
+// each item has its own token bucket.
 type ItemRedisTokenRateLimiter[T comparable] struct {
-	rate     int
-	capacity int
-	tokenKey string
-	tsKey    string
+	limit  Limit
+	prefix string
 
-	rdb        redis.UniversalClient
-	redisAlive *atomic.Bool
+	rdb redis.UniversalClient
+	// breaker opens when redis is unavailable, the in-process limiter is used while it is open
+	breaker *breaker.Breaker
 
 	maxDelay      time.Duration
-	rescueLock    sync.Mutex
-	rescueLimiter RateLimiter[any]
+	rescueLimiter *ItemTokenRateLimiter[T]
 }
 
 var _ RateLimiter[any] = &ItemRedisTokenRateLimiter[any]{}
 
-// NewItemRedisTokenRateLimiter creates a new ItemRedisTokenRateLimiter
+// NewItemRedisTokenRateLimiter creates a new ItemRedisTokenRateLimiter,
+// the key is the prefix of the redis keys, r and b are the default rate and burst of each item.
 func NewItemRedisTokenRateLimiter[T comparable](rdb redis.UniversalClient, key string, r, b int, maxDelay time.Duration) *ItemRedisTokenRateLimiter[T] {
-	alive := atomic.Bool{}
-	alive.Store(true)
-
 	return &ItemRedisTokenRateLimiter[T]{
-		rate:     r,
-		capacity: b,
-		tokenKey: fmt.Sprintf(tokenFormat, key),
-		tsKey:    fmt.Sprintf(timestampFormat, key),
-
-		rdb:           rdb,
-		redisAlive:    &alive,
-		maxDelay:      maxDelay,
-		rescueLimiter: NewBucketRateLimiter[any](rate.NewLimiter(rate.Limit(r), b)),
+		limit:  Limit{Rate: r, Burst: b},
+		prefix: key,
+
+		rdb:      rdb,
+		breaker:  breaker.New("redis rate limiter", 1, rescueTimeout),
+		maxDelay: maxDelay,
+		// the in-process limiter is only used when redis is unavailable, each item still has its own token bucket
+		rescueLimiter: NewItemTokenRateLimiter[T](r, b),
 	}
 }
 
+// WithBreaker replaces the circuit breaker of redis, so that the breaker can be shared with the other
+// clients of the same redis, nil is ignored
+func (r *ItemRedisTokenRateLimiter[T]) WithBreaker(b *breaker.Breaker) *ItemRedisTokenRateLimiter[T] {
+	if b != nil {
+		r.breaker = b
+	}
+
+	return r
+}
+
 // Take gets an item and gets to decide whether it should run now or not
 func (r *ItemRedisTokenRateLimiter[T]) Take(ctx context.Context, item T) bool {
 	return r.reserveN(ctx, item)
@@ -312,9 +443,9 @@ func (r *ItemRedisTokenRateLimiter[T]) When(ctx context.Context, item T) time.Du
 	return r.maxDelay
 }
 
-// Forget removes an item from the token bucket in redis
-func (r *ItemRedisTokenRateLimiter[T]) Forget(ctx context.Context, _ T) {
-	r.rdb.Del(ctx, r.tokenKey, r.tsKey)
+// Forget removes the token bucket of the item in redis
+func (r *ItemRedisTokenRateLimiter[T]) Forget(ctx context.Context, item T) {
+	r.rdb.Del(ctx, r.keys(item)...)
 }
 
 // Retries returns 0 as the number of retries for the token bucket in redis
@@ -322,59 +453,52 @@ func (r *ItemRedisTokenRateLimiter[T]) Retries(_ context.Context, _ T) int {
 	return 0
 }
 
-func (r *ItemRedisTokenRateLimiter[T]) reserveN(ctx context.Context, item T) bool {
-	if !r.redisAlive.Load() {
-		slog.Warn("redis is not alive, use in-process limiter for rescue")
-		return r.rescueLimiter.Take(ctx, item)
+// Reserve takes a token from the token bucket of the item with the given limit,
+// and returns the state of the token bucket. The in-process token bucket of the item is used if redis is unavailable.
+func (r *ItemRedisTokenRateLimiter[T]) Reserve(ctx context.Context, item T, limit Limit) *Reservation {
+	if !r.breaker.Allow() { // redis is unavailable, the state change is logged by the breaker
+		return r.rescueLimiter.Reserve(ctx, item, limit)
 	}
 
-	ok, err := allowN.Run(ctx, r.rdb,
-		[]string{r.tokenKey, r.tsKey},
+	res, err := allowN.Run(ctx, r.rdb,
+		r.keys(item),
 		[]string{
-			strconv.Itoa(r.rate),
-			strconv.Itoa(r.capacity),
+			strconv.Itoa(limit.Rate),
+			strconv.Itoa(limit.Burst),
 			strconv.FormatInt(time.Now().UnixMilli(), 10),
 			"1",
-		}).Bool()
+		}).Int64Slice()
 
-	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) { // canceled by the caller, redis is not to blame
+		r.breaker.Release()
 		slog.Error("fail to use rate limiter", slog.Any("error", err))
-		return false
-	}
-
-	if err != nil {
-		if !errors.Is(err, redis.Nil) { // redis.Nil is a normal error, it means the key is not exist
-			slog.Error("fail to use rate limiter, use in-process limiter for rescue", slog.Any("error", err))
-
-			go r.waitForRedis()
 
-			return r.rescueLimiter.Take(ctx, item)
-		}
+		return &Reservation{}
 	}
 
-	// redis allowed == true
-	return ok
-}
+	r.breaker.Done(err)
 
-// waitForRedis start a goroutine to check redis connection status until it's alive
-func (r *ItemRedisTokenRateLimiter[T]) waitForRedis() {
-	if !r.rescueLock.TryLock() {
-		return
+	if err != nil {
+		slog.Error("fail to use rate limiter, use in-process limiter for rescue", slog.Any("error", err))
+		return r.rescueLimiter.Reserve(ctx, item, limit)
 	}
 
-	r.redisAlive.Store(false)
-
-	ticker := time.NewTicker(pingInterval)
+	return &Reservation{
+		OK:         res[0] == 1,
+		Limit:      limit.Burst,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		ResetAfter: time.Duration(res[3]) * time.Millisecond,
+	}
+}
 
-	defer func() {
-		ticker.Stop()
-		r.rescueLock.Unlock()
-	}()
+func (r *ItemRedisTokenRateLimiter[T]) reserveN(ctx context.Context, item T) bool {
+	return r.Reserve(ctx, item, r.limit).OK
+}
 
-	for range ticker.C {
-		if r.rdb.Ping(context.Background()).String() == "pong" {
-			r.redisAlive.Store(true)
-			return
-		}
-	}
+// keys returns the redis keys of the token bucket of the item,
+// the keys share the same hash tag, so they are in the same slot of redis cluster.
+func (r *ItemRedisTokenRateLimiter[T]) keys(item T) []string {
+	key := fmt.Sprintf("%s:%v", r.prefix, item)
+	return []string{fmt.Sprintf(tokenFormat, key), fmt.Sprintf(timestampFormat, key)}
 }
@@ -5,11 +5,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 
 	"github.com/beihai0xff/turl/configs"
 	"github.com/beihai0xff/turl/internal/tests"
+	"github.com/beihai0xff/turl/pkg/breaker"
 	"github.com/beihai0xff/turl/pkg/db/redis"
 )
 
@@ -222,8 +224,67 @@ func TestMaxOfRateLimiter(t *testing.T) {
 	}
 }
 
+func TestItemTokenRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Take", func(t *testing.T) {
+		r := NewItemTokenRateLimiter[any](1, 1)
+		require.True(t, r.Take(ctx, "one"))
+		require.False(t, r.Take(ctx, "one"))
+		// each item has its own token bucket
+		require.True(t, r.Take(ctx, "two"))
+	})
+
+	t.Run("When", func(t *testing.T) {
+		r := NewItemTokenRateLimiter[any](1, 1)
+		require.Zero(t, r.When(ctx, "one"))
+		require.InDelta(t, time.Second, r.When(ctx, "one"), float64(100*time.Millisecond))
+	})
+
+	t.Run("Forget", func(t *testing.T) {
+		r := NewItemTokenRateLimiter[any](1, 1)
+		require.True(t, r.Take(ctx, "one"))
+		r.Forget(ctx, "one")
+		require.True(t, r.Take(ctx, "one"))
+		require.Zero(t, r.Retries(ctx, "one"))
+	})
+
+	t.Run("Reserve", func(t *testing.T) {
+		r := NewItemTokenRateLimiter[string](1, 1)
+		limit := Limit{Rate: 10, Burst: 2}
+
+		res := r.Reserve(ctx, "one", limit)
+		require.True(t, res.OK)
+		require.Equal(t, 2, res.Limit)
+		require.Equal(t, 1, res.Remaining)
+		require.Zero(t, res.RetryAfter)
+		require.InDelta(t, 100*time.Millisecond, res.ResetAfter, float64(10*time.Millisecond))
+
+		require.True(t, r.Reserve(ctx, "one", limit).OK)
+		res = r.Reserve(ctx, "one", limit)
+		require.False(t, res.OK)
+		require.Zero(t, res.Remaining)
+		require.InDelta(t, 100*time.Millisecond, res.RetryAfter, float64(10*time.Millisecond))
+
+		// the bucket is recreated with the new limit
+		require.True(t, r.Reserve(ctx, "one", Limit{Rate: 1, Burst: 1}).OK)
+	})
+
+	t.Run("DropFull", func(t *testing.T) {
+		r := NewItemTokenRateLimiter[int](1000, 1)
+		for i := range maxIdleBuckets {
+			r.Take(ctx, i)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		require.True(t, r.Take(ctx, maxIdleBuckets))
+		require.Len(t, r.buckets, 1)
+	})
+}
+
 func TestItemRedisTokenRateLimiter(t *testing.T) {
-	rdb := redis.Client(tests.GlobalConfig.Cache.Redis)
+	rdb, err := redis.Client(tests.GlobalConfig.Cache.Redis)
+	require.NoError(t, err)
 
 	ctx := context.Background()
 
@@ -266,10 +327,77 @@ func TestItemRedisTokenRateLimiter(t *testing.T) {
 		require.False(t, r.reserveN(ctx, "one"))
 	})
 
+	t.Run("Take_PerItem", func(t *testing.T) {
+		r := NewItemRedisTokenRateLimiter[any](rdb, "test_Take_PerItem", 1, 1, time.Second)
+		require.True(t, r.Take(ctx, "one"))
+		require.False(t, r.Take(ctx, "one"))
+		// the other item has its own token bucket
+		require.True(t, r.Take(ctx, "two"))
+	})
+
+	t.Run("Reserve", func(t *testing.T) {
+		r := NewItemRedisTokenRateLimiter[any](rdb, "test_Reserve", 1, 1, time.Second)
+		limit := Limit{Rate: 10, Burst: 2}
+
+		got := r.Reserve(ctx, "one", limit)
+		require.True(t, got.OK)
+		require.Equal(t, 2, got.Limit)
+		require.Equal(t, 1, got.Remaining)
+		require.Zero(t, got.RetryAfter)
+		require.InDelta(t, 100*time.Millisecond, got.ResetAfter, float64(10*time.Millisecond))
+
+		require.True(t, r.Reserve(ctx, "one", limit).OK)
+
+		got = r.Reserve(ctx, "one", limit)
+		require.False(t, got.OK)
+		require.Zero(t, got.Remaining)
+		require.Positive(t, got.RetryAfter)
+		require.LessOrEqual(t, got.RetryAfter, 100*time.Millisecond)
+
+		r.Forget(ctx, "one")
+		require.True(t, r.Reserve(ctx, "one", limit).OK)
+	})
+
 	t.Run("reserveN_rdb_disconnect", func(t *testing.T) {
-		rdb = redis.Client(&configs.RedisConfig{Addr: make([]string, 0)})
+		rdb, err = redis.Client(&configs.RedisConfig{Addr: make([]string, 0)})
+		require.NoError(t, err)
 		r := NewItemRedisTokenRateLimiter[any](rdb, "test_reserveN_rdb_disconnect", 1, 1, time.Second)
 		require.True(t, r.reserveN(ctx, "one"))
 		require.False(t, r.reserveN(ctx, "one"))
 	})
 }
+
+func TestItemRedisTokenRateLimiter_breaker(t *testing.T) {
+	s := miniredis.RunT(t)
+	rdb, err := redis.Client(&configs.RedisConfig{Addr: []string{s.Addr()}, DialTimeout: 100 * time.Millisecond, MaxConn: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, b := context.Background(), breaker.New("redis", 1, 50*time.Millisecond)
+	r := NewItemRedisTokenRateLimiter[string](rdb, "test_breaker", 1, 2, time.Second).WithBreaker(b)
+	require.Same(t, b, r.WithBreaker(nil).breaker)
+
+	res := r.Reserve(ctx, "one", r.limit)
+	require.True(t, res.OK)
+	require.Equal(t, 1, res.Remaining)
+
+	// fall back to the in-process limiter of each item while redis is unavailable
+	s.Close()
+	require.True(t, r.Reserve(ctx, "one", r.limit).OK)
+	require.Equal(t, breaker.Open, b.State())
+	require.True(t, r.Reserve(ctx, "one", r.limit).OK)
+	require.False(t, r.Reserve(ctx, "one", r.limit).OK)
+	// the other items are not limited by the exhausted bucket
+	res = r.Reserve(ctx, "two", r.limit)
+	require.True(t, res.OK)
+	require.Equal(t, 2, res.Limit)
+
+	// the circuit closes after redis recovers
+	require.NoError(t, s.Restart())
+	time.Sleep(60 * time.Millisecond)
+
+	res = r.Reserve(ctx, "three", r.limit)
+	require.True(t, res.OK)
+	require.Equal(t, 1, res.Remaining)
+	require.Equal(t, breaker.Closed, b.State())
+}
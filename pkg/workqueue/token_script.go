@@ -47,5 +47,19 @@ end
 redis.call("PSETEX", KEYS[1], ttl, new_tokens)
 redis.call("PSETEX", KEYS[2], ttl, now)
 
-return allowed
+-- how long to wait until the consumed tokens are available, in milliseconds
+local retry_after = 0
+if not allowed then
+    retry_after = math.ceil((consumed-filled_tokens)*1000/rate)
+end
+-- how long to wait until the bucket is full, in milliseconds
+local reset_after = math.ceil((capacity-new_tokens)*1000/rate)
+
+local allowed_num = 0
+if allowed then
+    allowed_num = 1
+end
+
+-- lua numbers are truncated to integers in redis replies
+return {allowed_num, math.floor(new_tokens), retry_after, reset_after}
 `)
@@ -8,4 +8,7 @@ ${cmd} -file configs/server_config.go
 ${cmd} -file configs/log_config.go
 ${cmd} -file configs/tddl_config.go
 ${cmd} -file configs/cache_config.go
-${cmd} -file configs/mysql_config.go
\ No newline at end of file
+${cmd} -file configs/mysql_config.go
+${cmd} -file configs/analytics_config.go
+${cmd} -file configs/rate_limit_config.go
+${cmd} -file configs/generator_config.go